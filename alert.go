@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ViolationKind — какое правило работы конвейера нарушено.
+type ViolationKind string
+
+const (
+	ViolationSequence ViolationKind = "sequence" // число пришло не по порядку или повторно
+	ViolationChecksum ViolationKind = "checksum" // суммы или количества чисел разошлись
+	ViolationStall    ViolationKind = "stall"    // обработчик перестал отвечать
+	ViolationCircuit  ViolationKind = "circuit"  // цепь к получателю или этапу разомкнулась или замкнулась
+
+	// их находит только прогон на выносливость
+	ViolationDrain      ViolationKind = "drain"      // конвейер не опустел на паузе
+	ViolationMemory     ViolationKind = "memory"     // куча растёт
+	ViolationGoroutines ViolationKind = "goroutines" // горутины копятся
+)
+
+var alertsFired = newCounter("alerts") // сколько раз нарушались правила
+
+// Violation — нарушение правила работы конвейера.
+type Violation struct {
+	Kind   ViolationKind `json:"kind"`
+	Worker int           `json:"worker"` // номер обработчика или -1, если нарушение общее
+	Seq    int64         `json:"seq"`    // номер числа или 0
+	Detail string        `json:"detail"`
+	Time   time.Time     `json:"time"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s", v.Kind, v.Detail)
+}
+
+// AlertHook вызывается в тот момент, когда нарушено правило, а не
+// в итоговых проверках. Он вызывается из того этапа, который нашёл
+// нарушение, поэтому долгий обработчик задерживает этот этап.
+type AlertHook func(v Violation)
+
+// LogAlert журналирует нарушение.
+func LogAlert(v Violation) {
+	slog.Warn("нарушено правило", "kind", v.Kind, "worker", v.Worker, "seq", v.Seq, "detail", v.Detail)
+}
+
+// ExitAlert завершает процесс с ошибкой.
+func ExitAlert(v Violation) {
+	log.Fatalf("Ошибка: нарушено правило %v\n", v)
+}
+
+// WebhookAlert возвращает обработчик, который отправляет нарушение
+// в JSON POST-запросом на url и ждёт ответа не дольше timeout.
+func WebhookAlert(url string, timeout time.Duration) AlertHook {
+	client := &http.Client{Timeout: timeout}
+	return func(v Violation) {
+		body, err := json.Marshal(v)
+		if err != nil {
+			log.Printf("alert: %v\n", err)
+			return
+		}
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("alert: не удалось отправить нарушение %v: %v\n", v, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("alert: не удалось отправить нарушение %v: %s\n", v, resp.Status)
+		}
+	}
+}
+
+// ParseAlert собирает обработчик нарушений из списка через запятую:
+// log, exit и адресов http:// или https:// для WebhookAlert. exit
+// выполняется последним, чтобы остальные успели сработать. С пустым
+// списком нарушения только подсчитываются.
+func ParseAlert(spec string, timeout time.Duration) (AlertHook, error) {
+	var hooks []AlertHook
+	exit := false
+	for name := range strings.FieldsFuncSeq(spec, func(r rune) bool { return r == ',' }) {
+		switch name = strings.TrimSpace(name); {
+		case name == "log":
+			hooks = append(hooks, LogAlert)
+		case name == "exit":
+			exit = true
+		case strings.HasPrefix(name, "http://"), strings.HasPrefix(name, "https://"):
+			hooks = append(hooks, WebhookAlert(name, timeout))
+		default:
+			return nil, fmt.Errorf("неизвестный обработчик нарушений %q: нужен log, exit или адрес http(s)://", name)
+		}
+	}
+	if exit {
+		hooks = append(hooks, ExitAlert)
+	}
+	return func(v Violation) {
+		alertsFired.Add(1)
+		if v.Time.IsZero() {
+			v.Time = time.Now()
+		}
+		for _, hook := range hooks {
+			hook(v)
+		}
+	}, nil
+}
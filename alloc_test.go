@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// allocRuns — сколько раз выполняется каждый шаг при проверке выделений.
+const allocRuns = 10_000
+
+// hotStep — шаг, который проходит каждое число.
+type hotStep struct {
+	name string
+	run  func()
+}
+
+// TestHotPathAllocs выполняет каждый шаг пути числа allocRuns раз и
+// проверяет, что в установившемся режиме они не выделяют память: иначе
+// быстрые прогоны меряют сборщик мусора, а не устройство конвейера.
+func TestHotPathAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("детектор гонок сам выделяет память")
+	}
+	steps, stop := hotPath()
+	defer stop()
+	for _, st := range steps {
+		if allocs := testing.AllocsPerRun(allocRuns, st.run); allocs > 0 {
+			t.Errorf("%s: %g выделений на число", st.name, allocs)
+		}
+	}
+}
+
+// hotPath собирает шаги пути числа так же, как их собирает конвейер,
+// и возвращает их вместе с функцией, которая останавливает горутины.
+func hotPath() ([]hotStep, func()) {
+	ctx := context.Background()
+	var seq int64
+	next := func() Item {
+		seq++
+		return Item{Seq: seq, Value: seq, Trace: NewTraceID(), Born: time.Now()}
+	}
+
+	genOpts := GeneratorOptions{
+		TTL:          time.Minute,
+		Backpressure: &Backpressure{Threshold: time.Second},
+		Memory:       NewMemoryGuard(),
+		Control:      NewControl(1),
+	}
+	gen := make(chan Item, 1)
+
+	in := make(chan Item)
+	worker := NewWorker(0, in, NewMonitor(time.Second),
+		WithDelay(LatencyProfile{}),
+		WithProcess(Instrument("bench", passThrough)),
+		WithControl(NewControl(1)))
+	go worker.Run(ctx)
+
+	// сборщик получает результаты так же, как в main: успехи уходят
+	// в out, остальное — в свои каналы
+	results := make(chan Result[Item])
+	out, expired, failed := make(chan Item), make(chan Item), make(chan Item)
+	var amount int64
+	go fanIn(results, out, expired, failed, &amount)
+
+	weighted := WeightedPicker(map[int]int{0: 2}, 5)
+	keyed := KeyPicker(64, nil, 5)
+	// все числа приходят от одного из двух обработчиков, поэтому буфер
+	// заполняется до предела и дальше числа проходят через кучу
+	reorder := newReorderBuffer(2, 64)
+	line := NewLineSink(io.Discard, "")
+
+	var generated int64
+	collect := NewCollector(1)
+	collect.Sinks, collect.Budget = []Sink{NewLineSink(io.Discard, "")}, &Budget{}
+	collect.Alert, collect.Generated = func(Violation) {}, &generated
+
+	steps := []hotStep{
+		{"отправка генератора", func() {
+			seq++
+			genOpts.send(ctx, gen, seq, seq)
+			<-gen
+		}},
+		{"обработчик", func() {
+			in <- next()
+			<-worker.Out()
+		}},
+		{"обработчик со сроком", func() {
+			it := next()
+			it.Deadline = it.Born.Add(time.Minute)
+			in <- it
+			<-worker.Out()
+		}},
+		{"сборщик", func() {
+			results <- Result[Item]{Value: next()}
+			<-out
+		}},
+		{"раздача по весам", func() { weighted(next()) }},
+		{"раздача по ключам", func() { keyed(next()) }},
+		{"восстановление порядка", func() { reorder.Push(next(), func(Item) {}) }},
+		{"запись строки", func() { line.Put(next()) }},
+		{"получение", func() {
+			generated++
+			collect.Add(next())
+		}},
+	}
+	return steps, func() {
+		close(in)
+		for range worker.Out() {
+		}
+		close(results)
+	}
+}
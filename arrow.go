@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	arrowBatchRows     = 8192        // столько строк в пакете
+	arrowFlushInterval = time.Second // а неполный пакет отправляется не реже этого
+)
+
+// константы формата Arrow (Schema.fbs, Message.fbs)
+const (
+	arrowV5              = 4 // MetadataVersion.V5
+	arrowHeaderSchema    = 1 // MessageHeader.Schema
+	arrowHeaderBatch     = 3 // MessageHeader.RecordBatch
+	arrowTypeInt         = 2 // Type.Int
+	arrowTypeTimestamp   = 10
+	arrowMicrosecond     = 2 // TimeUnit.MICROSECOND
+	arrowContinuation    = 0xffffffff
+	arrowBufferAlignment = 8
+)
+
+// ArrowSink отправляет результаты потоком Arrow IPC: сначала схема,
+// затем пакеты по arrowBatchRows строк с теми же столбцами, что
+// у ParquetSink. Неполный пакет отправляется, если он копится дольше
+// arrowFlushInterval, поэтому подписчик на сокете видит свежие данные.
+type ArrowSink struct {
+	c         io.Closer
+	w         *bufio.Writer
+	rows      [][]int64
+	lastFlush time.Time
+}
+
+// CreateArrow начинает поток Arrow IPC в файл path или, если path
+// начинается с «unix:», в Unix-сокет по остатку пути.
+func CreateArrow(path string) (*ArrowSink, error) {
+	var wc io.WriteCloser
+	var err error
+	if sock, ok := strings.CutPrefix(path, "unix:"); ok {
+		wc, err = net.Dial("unix", sock)
+	} else {
+		wc, err = os.Create(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &ArrowSink{
+		c:         wc,
+		w:         bufio.NewWriter(wc),
+		rows:      make([][]int64, len(itemColumns)),
+		lastFlush: time.Now(),
+	}
+	if err := s.message(arrowSchema(), nil); err != nil {
+		wc.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Put добавляет число it в текущий пакет и при необходимости отправляет его.
+func (s *ArrowSink) Put(it Item) error {
+	now := time.Now()
+	for i, c := range itemColumns {
+		s.rows[i] = append(s.rows[i], c.value(it, now))
+	}
+	if len(s.rows[0]) < arrowBatchRows && now.Sub(s.lastFlush) < arrowFlushInterval {
+		return nil
+	}
+	return s.flush()
+}
+
+// flush отправляет накопленные строки пакетом.
+func (s *ArrowSink) flush() error {
+	s.lastFlush = time.Now()
+	n := len(s.rows[0])
+	if n == 0 {
+		return nil
+	}
+	var body []byte
+	bufs := make([][2]int64, 0, 2*len(itemColumns))
+	for i, c := range itemColumns {
+		// битовой карты пустых значений нет: пустых значений не бывает
+		bufs = append(bufs, [2]int64{int64(len(body)), 0})
+		start := len(body)
+		body = c.appendValues(body, s.rows[i])
+		bufs = append(bufs, [2]int64{int64(start), int64(len(body) - start)})
+		body = padTo(body, arrowBufferAlignment)
+		s.rows[i] = s.rows[i][:0]
+	}
+	if err := s.message(arrowBatch(n, bufs, len(body)), body); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// message пишет сообщение IPC: метаданные meta и тело body.
+func (s *ArrowSink) message(meta, body []byte) error {
+	meta = padTo(meta, arrowBufferAlignment)
+	var head [8]byte
+	binary.LittleEndian.PutUint32(head[:], arrowContinuation)
+	binary.LittleEndian.PutUint32(head[4:], uint32(len(meta)))
+	for _, p := range [][]byte{head[:], meta, body} {
+		if _, err := s.w.Write(p); err != nil {
+			return err
+		}
+	}
+	sinkBytes.Add(int64(len(head) + len(meta) + len(body)))
+	return nil
+}
+
+// Close отправляет оставшиеся строки и признак конца потока.
+func (s *ArrowSink) Close() error {
+	err := s.flush()
+	if err == nil {
+		var eos [8]byte
+		binary.LittleEndian.PutUint32(eos[:], arrowContinuation)
+		if _, err = s.w.Write(eos[:]); err == nil {
+			err = s.w.Flush()
+		}
+	}
+	if cerr := s.c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// padTo дополняет b нулями до длины, кратной align.
+func padTo(b []byte, align int) []byte {
+	for len(b)%align != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// arrowSchema возвращает сообщение со схемой itemColumns.
+func arrowSchema() []byte {
+	var b fbBuilder
+	fields := make([]int, len(itemColumns))
+	for i, c := range itemColumns {
+		var typ int
+		typeKind := byte(arrowTypeInt)
+		switch c.kind {
+		case columnTimestamp:
+			typeKind = arrowTypeTimestamp
+			b.startTable()
+			b.addInt16(0, arrowMicrosecond)
+			typ = b.endTable()
+		default:
+			width := int32(64)
+			if c.kind == columnInt32 {
+				width = 32
+			}
+			b.startTable()
+			b.addInt32(0, width)
+			b.addBool(1, true)
+			typ = b.endTable()
+		}
+		children := b.vectorOfOffsets(nil)
+		name := b.string(c.name)
+
+		b.startTable()
+		b.addOffset(0, name)
+		b.addBool(1, false)
+		b.addByte(2, typeKind)
+		b.addOffset(3, typ)
+		b.addOffset(5, children)
+		fields[i] = b.endTable()
+	}
+	vec := b.vectorOfOffsets(fields)
+
+	b.startTable()
+	b.addInt16(0, 0) // little-endian
+	b.addOffset(1, vec)
+	schema := b.endTable()
+	return arrowMessage(&b, arrowHeaderSchema, schema, 0)
+}
+
+// arrowBatch возвращает метаданные пакета из rows строк с буферами bufs
+// (смещение и длина в теле) и телом длины bodyLen.
+func arrowBatch(rows int, bufs [][2]int64, bodyLen int) []byte {
+	var b fbBuilder
+	// структуры Buffer{offset, length} и FieldNode{length, null_count}
+	// по 16 байт; элементы вектора добавляются с конца
+	b.prep(8, 16*len(bufs))
+	for i := len(bufs) - 1; i >= 0; i-- {
+		b.prependInt64(bufs[i][1])
+		b.prependInt64(bufs[i][0])
+	}
+	buffers := b.endVector(len(bufs))
+	b.prep(8, 16*len(itemColumns))
+	for range itemColumns {
+		b.prependInt64(0)
+		b.prependInt64(int64(rows))
+	}
+	nodes := b.endVector(len(itemColumns))
+
+	b.startTable()
+	b.addInt64(0, int64(rows))
+	b.addOffset(1, nodes)
+	b.addOffset(2, buffers)
+	batch := b.endTable()
+	return arrowMessage(&b, arrowHeaderBatch, batch, bodyLen)
+}
+
+// arrowMessage дописывает в b корневую таблицу Message с заголовком header
+// вида kind и возвращает готовый буфер.
+func arrowMessage(b *fbBuilder, kind byte, header, bodyLen int) []byte {
+	b.startTable()
+	b.addInt16(0, arrowV5)
+	b.addByte(1, kind)
+	b.addOffset(2, header)
+	b.addInt64(3, int64(bodyLen))
+	return b.finish(b.endTable())
+}
+
+// fbBuilder собирает буфер FlatBuffers так же, как официальные
+// библиотеки: с конца к началу. Смещение объекта — его расстояние
+// от конца буфера в момент создания.
+type fbBuilder struct {
+	buf      []byte // собранное, начиная с последнего добавленного
+	minAlign int
+	fields   []int // смещения полей открытой таблицы по номерам
+	start    int   // смещение начала открытой таблицы
+}
+
+func (b *fbBuilder) offset() int { return len(b.buf) }
+
+func (b *fbBuilder) prepend(p ...byte) {
+	b.buf = append(append(make([]byte, 0, len(p)+len(b.buf)), p...), b.buf...)
+}
+
+// prep выравнивает буфер так, чтобы после добавления extra байт
+// следующее значение размера size оказалось выровненным.
+func (b *fbBuilder) prep(size, extra int) {
+	b.minAlign = max(b.minAlign, size)
+	pad := (-(len(b.buf) + extra)) & (size - 1)
+	b.prepend(make([]byte, pad)...)
+}
+
+func (b *fbBuilder) prependInt64(v int64) {
+	b.prep(8, 0)
+	b.prepend(binary.LittleEndian.AppendUint64(nil, uint64(v))...)
+}
+
+func (b *fbBuilder) prependUint32(v uint32) {
+	b.prep(4, 0)
+	b.prepend(binary.LittleEndian.AppendUint32(nil, v)...)
+}
+
+// prependOffset добавляет ссылку на объект со смещением off.
+func (b *fbBuilder) prependOffset(off int) {
+	b.prep(4, 0)
+	b.prependUint32(uint32(b.offset() + 4 - off))
+}
+
+func (b *fbBuilder) string(s string) int {
+	b.prep(4, len(s)+1)
+	b.prepend(0)
+	b.prepend([]byte(s)...)
+	return b.endVector(len(s))
+}
+
+// endVector завершает вектор из n уже добавленных элементов.
+func (b *fbBuilder) endVector(n int) int {
+	b.prependUint32(uint32(n))
+	return b.offset()
+}
+
+func (b *fbBuilder) vectorOfOffsets(offs []int) int {
+	b.prep(4, 4*len(offs))
+	for i := len(offs) - 1; i >= 0; i-- {
+		b.prependOffset(offs[i])
+	}
+	return b.endVector(len(offs))
+}
+
+func (b *fbBuilder) startTable() {
+	b.fields = b.fields[:0]
+	b.start = b.offset()
+}
+
+func (b *fbBuilder) slot(i int) {
+	for len(b.fields) <= i {
+		b.fields = append(b.fields, 0)
+	}
+	b.fields[i] = b.offset()
+}
+
+func (b *fbBuilder) addBool(i int, v bool) {
+	var x byte
+	if v {
+		x = 1
+	}
+	b.addByte(i, x)
+}
+
+func (b *fbBuilder) addByte(i int, v byte) {
+	b.prep(1, 0)
+	b.prepend(v)
+	b.slot(i)
+}
+
+func (b *fbBuilder) addInt16(i int, v int16) {
+	b.prep(2, 0)
+	b.prepend(binary.LittleEndian.AppendUint16(nil, uint16(v))...)
+	b.slot(i)
+}
+
+func (b *fbBuilder) addInt32(i int, v int32) {
+	b.prependUint32(uint32(v))
+	b.slot(i)
+}
+
+func (b *fbBuilder) addInt64(i int, v int64) {
+	b.prependInt64(v)
+	b.slot(i)
+}
+
+func (b *fbBuilder) addOffset(i int, off int) {
+	b.prependOffset(off)
+	b.slot(i)
+}
+
+// endTable завершает таблицу и добавляет перед ней её vtable.
+func (b *fbBuilder) endTable() int {
+	b.prependUint32(0) // место для ссылки на vtable
+	obj := b.offset()
+
+	vt := make([]byte, 4+2*len(b.fields))
+	binary.LittleEndian.PutUint16(vt, uint16(len(vt)))
+	binary.LittleEndian.PutUint16(vt[2:], uint16(obj-b.start))
+	for i, f := range b.fields {
+		if f != 0 {
+			binary.LittleEndian.PutUint16(vt[4+2*i:], uint16(obj-f))
+		}
+	}
+	b.prep(2, len(vt))
+	b.prepend(vt...)
+	binary.LittleEndian.PutUint32(b.buf[b.offset()-obj:], uint32(b.offset()-obj))
+	return obj
+}
+
+// finish добавляет ссылку на корневую таблицу root и возвращает буфер.
+func (b *fbBuilder) finish(root int) []byte {
+	b.prep(max(b.minAlign, 4), 4)
+	b.prependOffset(root)
+	return b.buf
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestFbBuilderBytes сравнивает буфер с одной таблицей { a: int = 7 }
+// с тем, что для неё собирают официальные библиотеки FlatBuffers.
+func TestFbBuilderBytes(t *testing.T) {
+	var b fbBuilder
+	b.startTable()
+	b.addInt32(0, 7)
+	got := b.finish(b.endTable())
+	want := []byte{
+		12, 0, 0, 0, // ссылка на корневую таблицу
+		0, 0, // выравнивание
+		6, 0, 8, 0, 4, 0, // vtable: её размер, размер таблицы, смещение поля a
+		6, 0, 0, 0, // таблица: расстояние до vtable
+		7, 0, 0, 0, // поле a
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("буфер % x, ожидался % x", got, want)
+	}
+}
+
+// fbTable — таблица FlatBuffers в буфере buf, начинающаяся с pos.
+type fbTable struct {
+	buf []byte
+	pos int
+}
+
+func fbRoot(buf []byte) fbTable {
+	return fbTable{buf, int(binary.LittleEndian.Uint32(buf))}
+}
+
+// field возвращает смещение поля i от начала таблицы или 0, если поля нет.
+func (t fbTable) field(i int) int {
+	vt := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	if o := 4 + 2*i; o < int(binary.LittleEndian.Uint16(t.buf[vt:])) {
+		return int(binary.LittleEndian.Uint16(t.buf[vt+o:]))
+	}
+	return 0
+}
+
+func (t fbTable) byte(i int) byte {
+	if o := t.field(i); o != 0 {
+		return t.buf[t.pos+o]
+	}
+	return 0
+}
+
+func (t fbTable) int16(i int) int16 {
+	if o := t.field(i); o != 0 {
+		return int16(binary.LittleEndian.Uint16(t.buf[t.pos+o:]))
+	}
+	return 0
+}
+
+func (t fbTable) int32(i int) int32 {
+	if o := t.field(i); o != 0 {
+		return int32(binary.LittleEndian.Uint32(t.buf[t.pos+o:]))
+	}
+	return 0
+}
+
+func (t fbTable) int64(i int) int64 {
+	if o := t.field(i); o != 0 {
+		return int64(binary.LittleEndian.Uint64(t.buf[t.pos+o:]))
+	}
+	return 0
+}
+
+// deref переходит по ссылке в поле i и возвращает, куда она ведёт.
+func (t fbTable) deref(i int) int {
+	p := t.pos + t.field(i)
+	return p + int(binary.LittleEndian.Uint32(t.buf[p:]))
+}
+
+func (t fbTable) table(i int) fbTable {
+	return fbTable{t.buf, t.deref(i)}
+}
+
+// vector возвращает начало элементов вектора в поле i и их число.
+func (t fbTable) vector(i int) (start, n int) {
+	v := t.deref(i)
+	return v + 4, int(binary.LittleEndian.Uint32(t.buf[v:]))
+}
+
+func (t fbTable) string(i int) string {
+	start, n := t.vector(i)
+	return string(t.buf[start : start+n])
+}
+
+// tables возвращает таблицы вектора ссылок в поле i.
+func (t fbTable) tables(i int) []fbTable {
+	start, n := t.vector(i)
+	tables := make([]fbTable, n)
+	for j := range tables {
+		e := start + 4*j
+		tables[j] = fbTable{t.buf, e + int(binary.LittleEndian.Uint32(t.buf[e:]))}
+	}
+	return tables
+}
+
+// readArrowMessage читает очередное сообщение IPC. В конце потока
+// возвращает io.EOF.
+func readArrowMessage(r io.Reader) (msg fbTable, body []byte, err error) {
+	var head [8]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return msg, nil, err
+	}
+	if c := binary.LittleEndian.Uint32(head[:]); c != arrowContinuation {
+		return msg, nil, errors.New("нет признака продолжения")
+	}
+	n := binary.LittleEndian.Uint32(head[4:])
+	if n == 0 {
+		return msg, nil, io.EOF
+	}
+	meta := make([]byte, n)
+	if _, err := io.ReadFull(r, meta); err != nil {
+		return msg, nil, err
+	}
+	msg = fbRoot(meta)
+	body = make([]byte, msg.int64(3))
+	_, err = io.ReadFull(r, body)
+	return msg, body, err
+}
+
+// TestArrowStream записывает несколько чисел в поток Arrow IPC и читает
+// его обратно: схему, пакет и признак конца потока.
+func TestArrowStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.arrow")
+	s, err := CreateArrow(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	born := time.Now().Add(-time.Second).Truncate(time.Microsecond)
+	items := []Item{
+		{Seq: 1, Value: 10, Worker: 0, Born: born, Run: 7},
+		{Seq: 2, Value: -20, Worker: 3, Born: born.Add(time.Millisecond), Run: 7},
+		{Seq: 3, Value: 30, Worker: 1, Born: born.Add(2 * time.Millisecond), Run: 7},
+	}
+	for _, it := range items {
+		if err := s.Put(it); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	msg, _, err := readArrowMessage(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, kind := msg.int16(0), msg.byte(1); v != arrowV5 || kind != arrowHeaderSchema {
+		t.Fatalf("первое сообщение: версия %d, вид %d, ожидалась схема V5", v, kind)
+	}
+	fields := msg.table(2).tables(1)
+	if len(fields) != len(itemColumns) {
+		t.Fatalf("в схеме %d полей, ожидалось %d", len(fields), len(itemColumns))
+	}
+	for i, c := range itemColumns {
+		f := fields[i]
+		if name := f.string(0); name != c.name {
+			t.Errorf("поле %d: имя %q, ожидалось %q", i, name, c.name)
+		}
+		typ := f.table(3)
+		switch c.kind {
+		case columnTimestamp:
+			if f.byte(2) != arrowTypeTimestamp || typ.int16(0) != arrowMicrosecond {
+				t.Errorf("поле %s: тип %d, единица %d, ожидалось время в микросекундах", c.name, f.byte(2), typ.int16(0))
+			}
+		default:
+			width := int32(64)
+			if c.kind == columnInt32 {
+				width = 32
+			}
+			if f.byte(2) != arrowTypeInt || typ.int32(0) != width || typ.byte(1) != 1 {
+				t.Errorf("поле %s: тип %d, ширина %d, ожидалось целое со знаком шириной %d", c.name, f.byte(2), typ.int32(0), width)
+			}
+		}
+	}
+
+	msg, body, err := readArrowMessage(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind := msg.byte(1); kind != arrowHeaderBatch {
+		t.Fatalf("второе сообщение вида %d, ожидался пакет", kind)
+	}
+	batch := msg.table(2)
+	if n := batch.int64(0); n != int64(len(items)) {
+		t.Fatalf("в пакете %d строк, ожидалось %d", n, len(items))
+	}
+	nodes, n := batch.vector(1)
+	if n != len(itemColumns) {
+		t.Fatalf("в пакете %d узлов, ожидалось %d", n, len(itemColumns))
+	}
+	for i := range n {
+		if length := int64(binary.LittleEndian.Uint64(msg.buf[nodes+16*i:])); length != int64(len(items)) {
+			t.Errorf("узел %d: длина %d, ожидалась %d", i, length, len(items))
+		}
+	}
+	buffers, n := batch.vector(2)
+	if n != 2*len(itemColumns) {
+		t.Fatalf("в пакете %d буферов, ожидалось %d", n, 2*len(itemColumns))
+	}
+	column := func(i int) []int64 {
+		p := buffers + 16*(2*i+1) // буфер значений идёт после битовой карты
+		off := binary.LittleEndian.Uint64(msg.buf[p:])
+		data := body[off : off+binary.LittleEndian.Uint64(msg.buf[p+8:])]
+		var vs []int64
+		for len(data) > 0 {
+			if itemColumns[i].kind == columnInt32 {
+				vs = append(vs, int64(int32(binary.LittleEndian.Uint32(data))))
+				data = data[4:]
+			} else {
+				vs = append(vs, int64(binary.LittleEndian.Uint64(data)))
+				data = data[8:]
+			}
+		}
+		return vs
+	}
+	for i, c := range itemColumns {
+		if c.name == "received" || c.name == "latency_us" {
+			continue // зависят от времени записи
+		}
+		got := column(i)
+		if len(got) != len(items) {
+			t.Fatalf("столбец %s: %d значений, ожидалось %d", c.name, len(got), len(items))
+		}
+		for j, it := range items {
+			if want := c.value(it, time.Time{}); got[j] != want {
+				t.Errorf("столбец %s, строка %d: %d, ожидалось %d", c.name, j, got[j], want)
+			}
+		}
+	}
+
+	if _, _, err := readArrowMessage(r); err != io.EOF {
+		t.Fatalf("после пакета: %v, ожидался конец потока", err)
+	}
+	if rest, _ := io.ReadAll(r); len(rest) != 0 {
+		t.Errorf("после конца потока ещё %d байт", len(rest))
+	}
+}
+
+// errWriter не может ничего записать.
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, errFlaky }
+
+// TestArrowWriteError проверяет, что ошибка записи не теряется,
+// а возвращается из Put и Close.
+func TestArrowWriteError(t *testing.T) {
+	s := &ArrowSink{
+		c:    io.NopCloser(nil),
+		w:    bufio.NewWriterSize(errWriter{}, 16),
+		rows: make([][]int64, len(itemColumns)),
+	}
+	if err := s.Put(Item{Seq: 1, Born: time.Now()}); !errors.Is(err, errFlaky) {
+		t.Errorf("Put: ошибка %v, ожидалась ошибка записи", err)
+	}
+	s.Put(Item{Seq: 2, Born: time.Now()})
+	if err := s.Close(); !errors.Is(err, errFlaky) {
+		t.Errorf("Close: ошибка %v, ожидалась ошибка записи", err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// EnvToken — переменная окружения с токеном доступа к HTTP-серверу.
+// Как и EnvKey, флагом не задаётся.
+const EnvToken = EnvPrefix + "TOKEN"
+
+// authFailures — сколько запросов отклонено из-за неверного токена.
+var authFailures = newCounter("auth_failures")
+
+// openPaths — пути, доступные без токена: оркестратор проверяет
+// готовность и живость без учётных данных.
+var openPaths = map[string]bool{"/readyz": true, "/livez": true}
+
+// loadSecret читает секрет из файла path или, если путь пустой,
+// берёт значение env. Пробелы по краям отбрасываются.
+func loadSecret(path, env string) (string, error) {
+	if path == "" {
+		return strings.TrimSpace(env), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// RequireToken пропускает к next только запросы с заголовком
+// «Authorization: Bearer token», кроме openPaths. Отклонённые запросы
+// журналируются и считаются. Если token пустой, проверки нет.
+func RequireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if openPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			authFailures.Add(1)
+			log.Printf("http: отказано в доступе к %s %s с %s\n", r.Method, r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sprint9"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
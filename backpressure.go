@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Alarm — предупреждение о том, что генератор слишком долго не может
+// отправить число дальше.
+type Alarm struct {
+	Blocked    time.Duration // сколько генератор уже ждёт
+	Bottleneck string        // этап, который не успевает забирать числа
+}
+
+// Backpressure следит, как долго генератор ждёт отправки очередного числа,
+// и поднимает тревогу, если ожидание длится дольше Threshold.
+type Backpressure struct {
+	Threshold time.Duration
+	// OnAlarm, если задана, вызывается при каждой тревоге.
+	OnAlarm func(Alarm)
+
+	blockedSince atomic.Int64 // UnixNano начала ожидания или 0
+}
+
+// backpressureAlarms — сколько раз поднималась тревога.
+var backpressureAlarms = newCounter("backpressure_alarms")
+
+// Block отмечает, что генератор начал ждать отправки. Вызов для nil
+// ничего не делает.
+func (b *Backpressure) Block() {
+	if b != nil {
+		b.blockedSince.Store(time.Now().UnixNano())
+	}
+}
+
+// Unblock отмечает, что число отправлено.
+func (b *Backpressure) Unblock() {
+	if b != nil {
+		b.blockedSince.Store(0)
+	}
+}
+
+// Run проверяет ожидание генератора, пока не отменён контекст ctx.
+// Узкое место определяется по заполненности каналов depths, перечисленных
+// от генератора к получателю.
+func (b *Backpressure) Run(ctx context.Context, depths []*ChannelDepth) {
+	tick := time.NewTicker(b.Threshold / 2)
+	defer tick.Stop()
+
+	var alarmed int64 // начало ожидания, о котором уже предупредили
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C:
+			since := b.blockedSince.Load()
+			if since == 0 || since == alarmed {
+				continue
+			}
+			blocked := now.Sub(time.Unix(0, since))
+			if blocked < b.Threshold {
+				continue
+			}
+			alarmed = since
+
+			alarm := Alarm{Blocked: blocked, Bottleneck: bottleneck(depths)}
+			backpressureAlarms.Add(1)
+			slog.Warn("генератор заблокирован",
+				"blocked", alarm.Blocked.Round(time.Millisecond),
+				"bottleneck", alarm.Bottleneck)
+			if b.OnAlarm != nil {
+				b.OnAlarm(alarm)
+			}
+		}
+	}
+}
+
+// bottleneck возвращает этап, читающий из самого дальнего заполненного
+// канала. Если заполненных каналов нет, не успевает читатель первого канала.
+func bottleneck(depths []*ChannelDepth) string {
+	for i := len(depths) - 1; i >= 0; i-- {
+		d := depths[i]
+		if d.cap > 0 && d.len() >= d.cap {
+			return d.Consumer
+		}
+	}
+	if len(depths) == 0 {
+		return ""
+	}
+	return depths[0].Consumer
+}
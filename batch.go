@@ -0,0 +1,87 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"sync"
+	"time"
+)
+
+// batchMaxFactor — во сколько раз пакет может вырасти по сравнению
+// с начальным размером.
+const batchMaxFactor = 16
+
+// BatchTuner подбирает размер пакета для получателя, который пишет
+// пакетами. Задержкой пакета считается время от рождения самого старого
+// числа в нём до конца записи. Пока задержка меньше половины Target, пакет
+// растёт на восьмую часть: большие пакеты дешевле на число. Как только
+// задержка превышает Target, пакет уменьшается вдвое. Между этими порогами
+// размер не меняется, чтобы не раскачиваться. Каждый новый размер пишется
+// в журнал и в metrics как batch_<name>.
+type BatchTuner struct {
+	Target time.Duration
+
+	name     string
+	max      int
+	gauge    *expvar.Int
+	mu       sync.Mutex
+	size     int
+	smallest int
+	largest  int
+}
+
+// NewBatchTuner создаёт подбор размера пакетов получателя name, начиная
+// с initial чисел в пакете.
+func NewBatchTuner(name string, target time.Duration, initial int) *BatchTuner {
+	t := &BatchTuner{
+		Target:   target,
+		name:     name,
+		max:      initial * batchMaxFactor,
+		gauge:    newCounter("batch_" + name),
+		size:     initial,
+		smallest: initial,
+		largest:  initial,
+	}
+	t.gauge.Set(int64(initial))
+	return t
+}
+
+// Size возвращает, сколько чисел сейчас набирать в пакет.
+func (t *BatchTuner) Size() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size
+}
+
+// Observe учитывает пакет из n чисел, самое старое из которых родилось
+// в oldest, а запись закончилась только что.
+func (t *BatchTuner) Observe(n int, oldest time.Time) {
+	latency := time.Since(oldest)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	size := t.size
+	switch {
+	case latency > t.Target:
+		size = max(size/2, 1)
+	case latency < t.Target/2 && n >= size:
+		// растём, только если пакет набрался целиком: неполный пакет
+		// ограничен частотой чисел, а не размером
+		size = min(size+size/8+1, t.max)
+	}
+	if size == t.size {
+		return
+	}
+	log.Printf("%s: размер пакета %d вместо %d, задержка %v при цели %v\n",
+		t.name, size, t.size, latency.Round(time.Microsecond), t.Target)
+	t.size = size
+	t.smallest = min(t.smallest, size)
+	t.largest = max(t.largest, size)
+	t.gauge.Set(int64(size))
+}
+
+// Range возвращает последний, наименьший и наибольший размер пакета.
+func (t *BatchTuner) Range() (last, smallest, largest int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size, t.smallest, t.largest
+}
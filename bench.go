@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// benchTransport — способ передать число от генератора обработчикам.
+type benchTransport interface {
+	Send(it Item)
+	// Recv возвращает очередное число или false, если передача закончена.
+	Recv() (Item, bool)
+	// Close заканчивает передачу; читатели дочитывают то, что осталось.
+	Close()
+}
+
+// chanTransport передаёт числа через канал — так устроен конвейер.
+type chanTransport chan Item
+
+func (c chanTransport) Send(it Item) { c <- it }
+func (c chanTransport) Close()       { close(c) }
+
+func (c chanTransport) Recv() (Item, bool) {
+	it, ok := <-c
+	return it, ok
+}
+
+// ringTransport — кольцевой буфер под мьютексом: писатель ждёт
+// свободного места, читатели — чисел.
+type ringTransport struct {
+	mu       sync.Mutex
+	notEmpty sync.Cond
+	notFull  sync.Cond
+	buf      []Item
+	head     int // откуда читать
+	n        int // сколько чисел в буфере
+	closed   bool
+}
+
+func newRingTransport(size int) *ringTransport {
+	r := &ringTransport{buf: make([]Item, size)}
+	r.notEmpty.L = &r.mu
+	r.notFull.L = &r.mu
+	return r
+}
+
+func (r *ringTransport) Send(it Item) {
+	r.mu.Lock()
+	for r.n == len(r.buf) {
+		r.notFull.Wait()
+	}
+	r.buf[(r.head+r.n)%len(r.buf)] = it
+	r.n++
+	r.mu.Unlock()
+	r.notEmpty.Signal()
+}
+
+func (r *ringTransport) Recv() (Item, bool) {
+	r.mu.Lock()
+	for r.n == 0 && !r.closed {
+		r.notEmpty.Wait()
+	}
+	if r.n == 0 {
+		r.mu.Unlock()
+		return Item{}, false
+	}
+	it := r.buf[r.head]
+	r.head = (r.head + 1) % len(r.buf)
+	r.n--
+	r.mu.Unlock()
+	r.notFull.Signal()
+	return it, true
+}
+
+func (r *ringTransport) Close() {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	r.notEmpty.Broadcast()
+}
+
+// lockFreeTransport — ограниченная очередь без блокировок для многих
+// писателей и читателей (очередь Вьюкова): у каждой ячейки свой номер,
+// по которому писатель узнаёт, что ячейка свободна, а читатель — что
+// в ней есть число. Кто не может ни записать, ни прочитать, уступает
+// процессор, а не засыпает.
+type lockFreeTransport struct {
+	cells  []lockFreeCell
+	mask   uint64
+	head   atomic.Uint64 // куда писать
+	_      [56]byte      // head и tail на разных строках кеша
+	tail   atomic.Uint64 // откуда читать
+	closed atomic.Bool
+}
+
+type lockFreeCell struct {
+	seq atomic.Uint64
+	it  Item
+}
+
+// newLockFreeTransport создаёт очередь на size чисел; size — степень двойки.
+func newLockFreeTransport(size int) *lockFreeTransport {
+	q := &lockFreeTransport{cells: make([]lockFreeCell, size), mask: uint64(size - 1)}
+	for i := range q.cells {
+		q.cells[i].seq.Store(uint64(i))
+	}
+	return q
+}
+
+func (q *lockFreeTransport) Send(it Item) {
+	for {
+		pos := q.head.Load()
+		c := &q.cells[pos&q.mask]
+		switch seq := c.seq.Load(); {
+		case seq == pos:
+			if q.head.CompareAndSwap(pos, pos+1) {
+				c.it = it
+				c.seq.Store(pos + 1)
+				return
+			}
+		case seq < pos:
+			// очередь полна
+			runtime.Gosched()
+		}
+	}
+}
+
+func (q *lockFreeTransport) Recv() (Item, bool) {
+	for {
+		pos := q.tail.Load()
+		c := &q.cells[pos&q.mask]
+		switch seq := c.seq.Load(); {
+		case seq == pos+1:
+			if q.tail.CompareAndSwap(pos, pos+1) {
+				it := c.it
+				c.seq.Store(pos + q.mask + 1)
+				return it, true
+			}
+		case seq < pos+1:
+			// очередь пуста; после Close писать уже некому, и если
+			// все записанные числа разобраны, передача закончена
+			if q.closed.Load() && q.tail.Load() == q.head.Load() {
+				return Item{}, false
+			}
+			runtime.Gosched()
+		}
+	}
+}
+
+func (q *lockFreeTransport) Close() { q.closed.Store(true) }
+
+// benchCase — транспорт, который участвует в сравнении.
+type benchCase struct {
+	name string
+	new  func() benchTransport
+}
+
+// benchCases — транспорты для сравнения: каналы, как в конвейере,
+// и очереди, которыми их можно заменить. Буферы взяты такие, какие
+// бывают у -buffer.
+var benchCases = []benchCase{
+	{"канал без буфера", func() benchTransport { return make(chanTransport) }},
+	{"канал, буфер 64", func() benchTransport { return make(chanTransport, 64) }},
+	{"канал, буфер 1024", func() benchTransport { return make(chanTransport, 1024) }},
+	{"кольцевой буфер 1024", func() benchTransport { return newRingTransport(1024) }},
+	{"очередь без блокировок 1024", func() benchTransport { return newLockFreeTransport(1024) }},
+}
+
+// BenchResult — итог прогона одного транспорта.
+type BenchResult struct {
+	Name      string
+	PerSecond float64       // чисел в секунду
+	Allocs    float64       // выделений памяти на число
+	P99       time.Duration // задержка между отправкой и получением
+}
+
+// RunBench выполняет подкоманду bench: bench compare прогоняет одну
+// и ту же нагрузку через каждый транспорт и пишет в w таблицу сравнения.
+// Что путь числа не выделяет память, проверяет TestHotPathAllocs.
+func RunBench(args []string, w io.Writer) error {
+	if len(args) > 0 && args[0] == "compare" {
+		return benchCompare(args[1:], w)
+	}
+	return errors.New("использование: bench compare [-n число] [-workers число]")
+}
+
+// benchCompare сравнивает транспорты.
+func benchCompare(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("bench compare", flag.ContinueOnError)
+	n := fs.Int("n", 1_000_000, "сколько чисел передать через каждый транспорт")
+	workers := fs.Int("workers", 5, "сколько обработчиков читают числа")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *n <= 0 || *workers <= 0 {
+		return errors.New("n и workers должны быть больше нуля")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "транспорт\tчисел/с\tвыделений на число\tp99 задержки\t")
+	for _, c := range benchCases {
+		// разогрев, чтобы не мерить запуск горутин и рост стеков
+		benchRun(c, *n/10+1, *workers)
+		r := benchRun(c, *n, *workers)
+		fmt.Fprintf(tw, "%s\t%s\t%.2f\t%v\t\n", r.Name,
+			strconv.FormatFloat(r.PerSecond, 'f', 0, 64), r.Allocs, r.P99.Round(100*time.Nanosecond))
+	}
+	return tw.Flush()
+}
+
+// benchRun передаёт n чисел через транспорт c от одного генератора
+// workers обработчикам.
+func benchRun(c benchCase, n, workers int) BenchResult {
+	t := c.new()
+	// задержки каждый обработчик копит в своём заранее выделенном срезе,
+	// чтобы замеры не добавляли выделений памяти
+	latencies := make([][]time.Duration, workers)
+	for i := range latencies {
+		latencies[i] = make([]time.Duration, 0, n)
+	}
+	var wg sync.WaitGroup
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				it, ok := t.Recv()
+				if !ok {
+					return
+				}
+				latencies[i] = append(latencies[i], time.Since(it.Born))
+			}
+		}()
+	}
+	for seq := int64(1); seq <= int64(n); seq++ {
+		t.Send(Item{Seq: seq, Value: seq, Born: time.Now()})
+	}
+	t.Close()
+	wg.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	all := slices.Concat(latencies...)
+	slices.Sort(all)
+	return BenchResult{
+		Name:      c.name,
+		PerSecond: float64(n) / elapsed.Seconds(),
+		Allocs:    float64(after.Mallocs-before.Mallocs) / float64(n),
+		P99:       all[len(all)*99/100],
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestBenchTransports проверяет, что каждый транспорт доставляет каждое
+// число ровно один раз и после Close отпускает всех читателей.
+func TestBenchTransports(t *testing.T) {
+	const n, workers = 10_000, 5
+	for _, c := range benchCases {
+		t.Run(c.name, func(t *testing.T) {
+			tr := c.new()
+			seen := make([][]int64, workers)
+			var wg sync.WaitGroup
+			for i := range workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						it, ok := tr.Recv()
+						if !ok {
+							return
+						}
+						seen[i] = append(seen[i], it.Seq)
+					}
+				}()
+			}
+			for seq := int64(1); seq <= n; seq++ {
+				tr.Send(Item{Seq: seq})
+			}
+			tr.Close()
+			wg.Wait()
+
+			got := make([]int, n+1)
+			for _, s := range seen {
+				for _, seq := range s {
+					got[seq]++
+				}
+			}
+			for seq := 1; seq <= n; seq++ {
+				if got[seq] != 1 {
+					t.Fatalf("число %d получено %d раз", seq, got[seq])
+				}
+			}
+		})
+	}
+}
+
+// TestRunBench прогоняет bench compare на малой нагрузке.
+func TestRunBench(t *testing.T) {
+	var out bytes.Buffer
+	if err := RunBench([]string{"compare", "-n", "1000"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range benchCases {
+		if !strings.Contains(out.String(), c.name) {
+			t.Errorf("в таблице нет строки %q:\n%s", c.name, out.String())
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrCircuitOpen — цепь разомкнута, и число не передавалось дальше.
+var ErrCircuitOpen = errors.New("цепь разомкнута")
+
+// BreakerState — состояние цепи.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // обращения идут как обычно
+	BreakerOpen                         // обращения не делаются
+	BreakerHalfOpen                     // идёт одно пробное обращение
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// Breaker размыкает цепь к ненадёжному получателю или этапу, когда доля
+// ошибок среди последних window обращений достигает rate. Пока цепь
+// разомкнута, обращения не делаются, и числа сразу уходят туда же, куда
+// неудачные. Через cooldown цепь становится полуоткрытой: пропускается одно
+// пробное обращение, и по его исходу цепь замыкается или снова размыкается.
+// Состояние видно в metrics как breaker_<name> (0 — замкнута, 1 —
+// разомкнута, 2 — полуоткрыта), а каждый переход сообщается в alert.
+//
+// Цепью пользуются сразу несколько обработчиков, поэтому исход обращения
+// может прийти уже после смены состояния. Каждый переход начинает новое
+// поколение, Allow выдаёт обращению номер поколения, а Done не учитывает
+// исходы прошлых поколений: пробным считается только то обращение, которое
+// пропустила полуоткрытая цепь.
+type Breaker struct {
+	name     string
+	rate     float64
+	window   int
+	cooldown time.Duration
+	alert    AlertHook
+
+	mu       sync.Mutex
+	state    BreakerState
+	gen      uint64 // поколение: сколько раз менялось состояние
+	results  []bool // исходы последних обращений по кругу, true — ошибка
+	pos      int    // куда записать следующий исход
+	calls    int    // сколько исходов в results
+	errors   int    // сколько из них ошибок
+	openedAt time.Time
+	probing  bool // пробное обращение уже идёт
+
+	gauge   *expvar.Int
+	trips   *expvar.Int
+	skipped *expvar.Int
+}
+
+// NewBreaker создаёт цепь name. alert может быть nil.
+func NewBreaker(name string, rate float64, window int, cooldown time.Duration, alert AlertHook) *Breaker {
+	return &Breaker{
+		name:     name,
+		rate:     rate,
+		window:   window,
+		cooldown: cooldown,
+		alert:    alert,
+		results:  make([]bool, window),
+		gauge:    newCounter("breaker_" + name),
+		trips:    newCounter("breaker_" + name + "_trips"),
+		skipped:  newCounter("breaker_" + name + "_skipped"),
+	}
+}
+
+// Allow сообщает, можно ли обратиться к получателю сейчас, и возвращает
+// поколение, которому принадлежит обращение. После каждого разрешённого
+// обращения нужно вызвать Done с этим поколением.
+func (b *Breaker) Allow() (gen uint64, ok bool) {
+	b.mu.Lock()
+	var v *Violation
+	defer func() {
+		b.mu.Unlock()
+		b.report(v)
+	}()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			b.skipped.Add(1)
+			return b.gen, false
+		}
+		v = b.set(BreakerHalfOpen, "пробуем снова")
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing {
+			b.skipped.Add(1)
+			return b.gen, false
+		}
+		b.probing = true
+	}
+	return b.gen, true
+}
+
+// Done учитывает исход обращения поколения gen, которое разрешил Allow.
+// Исходы обращений, разрешённых до смены состояния, не учитываются.
+func (b *Breaker) Done(gen uint64, err error) {
+	b.mu.Lock()
+	var v *Violation
+	defer func() {
+		b.mu.Unlock()
+		b.report(v)
+	}()
+	if gen != b.gen {
+		return
+	}
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+		if err != nil {
+			b.openedAt = time.Now()
+			v = b.set(BreakerOpen, fmt.Sprintf("пробное обращение не удалось: %v", err))
+			return
+		}
+		b.calls, b.errors, b.pos = 0, 0, 0
+		v = b.set(BreakerClosed, "пробное обращение удалось")
+		return
+	}
+
+	if b.calls == b.window {
+		if b.results[b.pos] {
+			b.errors--
+		}
+	} else {
+		b.calls++
+	}
+	b.results[b.pos] = err != nil
+	if err != nil {
+		b.errors++
+	}
+	b.pos = (b.pos + 1) % b.window
+	if b.state == BreakerClosed && b.calls == b.window && float64(b.errors) >= b.rate*float64(b.window) {
+		b.openedAt = time.Now()
+		v = b.set(BreakerOpen, fmt.Sprintf("%d ошибок из %d обращений, последняя: %v", b.errors, b.window, err))
+	}
+}
+
+// set переводит цепь в состояние state и возвращает нарушение, о котором
+// надо сообщить. Вызывается под b.mu.
+func (b *Breaker) set(state BreakerState, why string) *Violation {
+	log.Printf("цепь %s: %v → %v: %s\n", b.name, b.state, state, why)
+	b.state = state
+	b.gen++
+	b.gauge.Set(int64(state))
+	if state == BreakerOpen {
+		b.trips.Add(1)
+	}
+	return &Violation{Kind: ViolationCircuit, Worker: -1,
+		Detail: fmt.Sprintf("цепь %s: %v: %s", b.name, state, why)}
+}
+
+// report сообщает о переходе v, если он был, уже не держа b.mu: alert
+// может быть долгим.
+func (b *Breaker) report(v *Violation) {
+	if v != nil && b.alert != nil {
+		b.alert(*v)
+	}
+}
+
+// Skipped возвращает, сколько обращений не сделано из-за разомкнутой цепи.
+func (b *Breaker) Skipped() int64 {
+	return b.skipped.Value()
+}
+
+// Stage оборачивает этап next: пока цепь разомкнута, он сразу возвращает
+// ErrCircuitOpen, и число уходит к неудачным.
+func (b *Breaker) Stage(next Stage) Stage {
+	return func(ctx context.Context, it Item) (Item, error) {
+		gen, ok := b.Allow()
+		if !ok {
+			return it, ErrCircuitOpen
+		}
+		res, err := next(ctx, it)
+		b.Done(gen, err)
+		return res, err
+	}
+}
+
+// BatchSink — получатель, который пишет числа пакетами. Исход записи
+// пакета Put не возвращает: пакет пишется позже, а то и в фоне. Поэтому
+// цепь такому получателю передаётся целиком, и через неё он пропускает
+// запись каждого пакета.
+type BatchSink interface {
+	Sink
+	// Guard пропускает запись пакетов через цепь b. Числа пакета, который
+	// цепь не пропустила или который не удалось записать, передаются fail.
+	// Вызывать Guard можно только до первого Put.
+	Guard(b *Breaker, fail func([]Item))
+}
+
+// batchGuard — цепь, через которую BatchSink пишет пакеты.
+type batchGuard struct {
+	b    *Breaker
+	fail func([]Item)
+}
+
+// write записывает пакет items функцией write через цепь g. Если цепи
+// нет, возвращает ошибку write. Иначе пакет, который цепь не пропустила
+// или который не удалось записать, передаётся g.fail, а write возвращает nil.
+func (g *batchGuard) write(items []Item, write func() error) error {
+	if g == nil {
+		return write()
+	}
+	gen, ok := g.b.Allow()
+	if !ok {
+		g.fail(items)
+		return nil
+	}
+	err := write()
+	g.b.Done(gen, err)
+	if err != nil {
+		log.Printf("цепь %s: не записано %d чисел: %v\n", g.b.name, len(items), err)
+		g.fail(items)
+	}
+	return nil
+}
+
+// BreakerSink передаёт числа получателю через цепь. Ошибки получателя
+// не останавливают конвейер: число, которое не удалось записать или
+// которое пришло, пока цепь разомкнута, уходит в dead — получателя
+// недоставленных чисел — или, если его нет, только учитывается в Failed.
+// Получателю, который пишет пакетами, цепь передаётся целиком, и тогда
+// через неё идёт запись каждого пакета, а не каждого числа.
+type BreakerSink struct {
+	Sink
+	b      *Breaker
+	dead   Sink
+	batch  bool // получатель пишет пакетами и сам пропускает их через цепь
+	failed atomic.Int64
+}
+
+// NewBreakerSink оборачивает получателя s цепью b. dead может быть nil;
+// если нет, Put у него должен быть безопасен для вызова из разных горутин.
+func NewBreakerSink(s Sink, b *Breaker, dead Sink) *BreakerSink {
+	bs := &BreakerSink{Sink: s, b: b, dead: dead}
+	if batch, ok := s.(BatchSink); ok {
+		batch.Guard(b, bs.undelivered)
+		bs.batch = true
+	}
+	return bs
+}
+
+// Put записывает число it через цепь.
+func (s *BreakerSink) Put(it Item) error {
+	if s.batch {
+		return s.Sink.Put(it)
+	}
+	if gen, ok := s.b.Allow(); ok {
+		err := s.Sink.Put(it)
+		s.b.Done(gen, err)
+		if err == nil {
+			return nil
+		}
+	}
+	s.undelivered([]Item{it})
+	return nil
+}
+
+// undelivered учитывает числа items, которые не дошли до получателя,
+// и передаёт их в dead.
+func (s *BreakerSink) undelivered(items []Item) {
+	s.failed.Add(int64(len(items)))
+	if s.dead == nil {
+		return
+	}
+	for _, it := range items {
+		if err := s.dead.Put(it); err != nil {
+			log.Fatalf("Ошибка записи в файл dead-letter: %v\n", err)
+		}
+	}
+}
+
+// Failed возвращает, сколько чисел не дошло до получателя.
+func (s *BreakerSink) Failed() int64 {
+	return s.failed.Load()
+}
+
+// Close закрывает получателя. Ошибка при этом тоже не останавливает
+// конвейер, а только журналируется: то, что получатель не дописал,
+// уже не спасти.
+func (s *BreakerSink) Close() error {
+	if err := s.Sink.Close(); err != nil {
+		log.Printf("цепь %s: получатель закрыт с ошибкой: %v\n", s.b.name, err)
+	}
+	return nil
+}
+
+// DeadLetterSink пишет числа, которые не дошли до получателей, по одному
+// в строке. В него пишут и цепи получателей, и фоновые отправки пакетов,
+// поэтому Put можно вызывать из разных горутин. Записанное им не
+// учитывается в sink_bytes: до получателей эти числа как раз не дошли.
+type DeadLetterSink struct {
+	mu   sync.Mutex
+	line *LineSink
+}
+
+// NewDeadLetterSink создаёт получателя недоставленных чисел, который
+// пишет в w.
+func NewDeadLetterSink(w io.Writer) *DeadLetterSink {
+	line := NewLineSink(w, "")
+	line.counted = false
+	return &DeadLetterSink{line: line}
+}
+
+// Put записывает число it.
+func (s *DeadLetterSink) Put(it Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.line.Put(it)
+}
+
+// Close дописывает буферизованные строки. Сам w не закрывается.
+func (s *DeadLetterSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.line.Close()
+}
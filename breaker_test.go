@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var errFlaky = errors.New("сбой")
+
+// TestBreakerTrip проверяет, что цепь размыкается при доле ошибок rate
+// и через cooldown пропускает одно пробное обращение.
+func TestBreakerTrip(t *testing.T) {
+	b := NewBreaker(t.Name(), 0.5, 4, 20*time.Millisecond, nil)
+	for i := range 4 {
+		gen, ok := b.Allow()
+		if !ok {
+			t.Fatalf("обращение %d не пропущено замкнутой цепью", i)
+		}
+		var err error
+		if i%2 == 0 {
+			err = errFlaky
+		}
+		b.Done(gen, err)
+	}
+	if _, ok := b.Allow(); ok {
+		t.Fatal("цепь не разомкнулась при половине ошибок")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	probe, ok := b.Allow()
+	if !ok {
+		t.Fatal("после cooldown не пропущено пробное обращение")
+	}
+	if _, ok := b.Allow(); ok {
+		t.Fatal("полуоткрытая цепь пропустила второе обращение")
+	}
+	b.Done(probe, nil)
+	if _, ok := b.Allow(); !ok {
+		t.Fatal("цепь не замкнулась после удачного пробного обращения")
+	}
+}
+
+// TestBreakerStaleDone проверяет, что исход обращения, разрешённого до
+// размыкания, не принимается за исход пробного.
+func TestBreakerStaleDone(t *testing.T) {
+	b := NewBreaker(t.Name(), 1, 1, 20*time.Millisecond, nil)
+	slow, _ := b.Allow() // долгое обращение другого обработчика
+	fast, _ := b.Allow()
+	b.Done(fast, errFlaky)
+	if _, ok := b.Allow(); ok {
+		t.Fatal("цепь не разомкнулась")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	probe, ok := b.Allow()
+	if !ok {
+		t.Fatal("после cooldown не пропущено пробное обращение")
+	}
+	// долгое обращение заканчивается удачно уже при полуоткрытой цепи
+	b.Done(slow, nil)
+	if _, ok := b.Allow(); ok {
+		t.Fatal("исход старого обращения принят за пробное: цепь замкнулась")
+	}
+	b.Done(probe, errFlaky)
+	if _, ok := b.Allow(); ok {
+		t.Fatal("цепь не разомкнулась после неудачного пробного обращения")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// sinkBytes — сколько байт записали получатели результатов, до сжатия
+// и шифрования.
+var sinkBytes = newCounter("sink_bytes")
+
+// BudgetError — причина остановки источника: достигнутый предел.
+type BudgetError struct {
+	Name  string // какой предел: чисел, суммы, времени или байт
+	Limit string
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("достигнут предел %s %s", e.Name, e.Limit)
+}
+
+// Budget — пределы запуска. Когда достигнут любой из них, источник
+// останавливается штатно, как по истечении времени: то, что уже в пути,
+// дорабатывается. Нулевой предел не ограничивает. Пределы чисел и суммы
+// не превышаются никогда: источник проверяет их до отправки числа,
+// а учитывает число, только когда оно отправлено. Предел
+// байт проверяется после записи, поэтому его превысят числа, которые уже
+// были в пути, а получатели, которые пишут пакетами или группами строк,
+// — ещё и на целый пакет.
+type Budget struct {
+	Items int64         // сколько чисел можно сгенерировать
+	Sum   int64         // больше какой суммы чисел не генерировать
+	Bytes int64         // сколько байт могут записать получатели
+	Time  time.Duration // сколько работает источник
+
+	items, sum int64 // сколько чисел и на какую сумму уже отправлено
+	stop       context.CancelCauseFunc
+}
+
+// Start возвращает контекст источника, который отменяется, когда
+// достигнут любой из пределов, и функцию, которая отменяет его раньше.
+func (b *Budget) Start(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, stop := context.WithCancelCause(parent)
+	b.stop = stop
+	if b.Time <= 0 {
+		return ctx, func() { stop(nil) }
+	}
+	ctx, cancel := context.WithTimeoutCause(ctx, b.Time, &BudgetError{"времени", b.Time.String()})
+	return ctx, func() {
+		cancel()
+		stop(nil)
+	}
+}
+
+// Allow вызывается источником перед отправкой числа value и сообщает,
+// укладывается ли оно в пределы. Если нет, источник останавливается.
+// Вызов для nil ничего не ограничивает.
+func (b *Budget) Allow(value int64) bool {
+	if b == nil {
+		return true
+	}
+	switch {
+	case b.Items > 0 && b.items >= b.Items:
+		b.stop(&BudgetError{"чисел", fmt.Sprint(b.Items)})
+		return false
+	case b.Sum > 0 && b.sum+value > b.Sum:
+		b.stop(&BudgetError{"суммы", fmt.Sprint(b.Sum)})
+		return false
+	}
+	return true
+}
+
+// Sent учитывает число value, которое источник отправил после Allow.
+func (b *Budget) Sent(value int64) {
+	if b == nil {
+		return
+	}
+	b.items++
+	b.sum += value
+}
+
+// CheckBytes останавливает источник, если получатели записали не меньше
+// Bytes байт.
+func (b *Budget) CheckBytes() {
+	if b.Bytes > 0 && sinkBytes.Value() >= b.Bytes {
+		b.stop(&BudgetError{"байт", fmt.Sprint(b.Bytes)})
+	}
+}
+
+// StopReason описывает, почему закончился источник с контекстом ctx.
+func StopReason(ctx context.Context) string {
+	var be *BudgetError
+	switch cause := context.Cause(ctx); {
+	case cause == nil:
+		return "ввод закончился"
+	case errors.As(cause, &be):
+		return be.Error()
+	default:
+		return "остановлен сигналом или командой"
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// TestBudgetCountsSentOnly проверяет, что число, которое не удалось
+// отправить, не расходует пределы.
+func TestBudgetCountsSentOnly(t *testing.T) {
+	b := &Budget{Items: 2}
+	ctx, cancel := b.Start(context.Background())
+	defer cancel()
+
+	for range 5 {
+		// источник проверяет предел, но отправить число не успевает
+		if !b.Allow(1) {
+			t.Fatal("предел исчерпан числами, которые не были отправлены")
+		}
+	}
+	for i := range 2 {
+		if !b.Allow(1) {
+			t.Fatalf("число %d не пропущено", i+1)
+		}
+		b.Sent(1)
+	}
+	if b.Allow(1) {
+		t.Fatal("пропущено третье число при пределе в два")
+	}
+	if got, want := StopReason(ctx), "достигнут предел чисел 2"; got != want {
+		t.Errorf("причина остановки %q, ожидалась %q", got, want)
+	}
+}
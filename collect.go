@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// fanIn пересылает результаты обработчика из in: успехи — в out,
+// устаревшие числа — в expired, необработанные — в failed. amount
+// считает успехи.
+func fanIn(in <-chan Result[Item], out, expired, failed chan<- Item, amount *int64) {
+	for r := range in {
+		switch {
+		case errors.Is(r.Err, ErrExpired):
+			expired <- r.Value
+		case r.Err != nil:
+			failed <- r.Value
+		default:
+			*amount++
+			out <- r.Value
+		}
+	}
+}
+
+// Collector — последний этап конвейера: он считает полученные числа,
+// передаёт их получателям и проверяет, что числа идут по порядку.
+type Collector struct {
+	Ordered   bool           // числа должны идти в общем порядке
+	Sinks     []Sink         // получатели результатов
+	Budget    *Budget        // его предел байт проверяется после каждого числа
+	Alert     AlertHook      // куда сообщать о нарушениях
+	Generated *int64         // сколько чисел отправил источник; читается атомарно
+	Stats     *PipelineStats // счётчики конвейера, по умолчанию основного
+
+	Count    int64   // количество полученных чисел
+	Sum      int64   // их сумма
+	ByWorker []int64 // разбивка по обработчикам, посчитанная по самим числам
+	Disorder int64   // сколько раз нарушался порядок
+	Late     int64   // сколько чисел пришло вне общего порядка
+	Retried  int64   // сколько чисел выдавалось заново после сбоя
+
+	// lastByWorker — порядковый номер последнего числа от каждого
+	// обработчика: обработчик берёт числа по порядку, поэтому номера
+	// от него должны идти по возрастанию
+	lastByWorker []int64
+	lastSeq      int64
+	overflow     bool // получено больше чисел, чем сгенерировано
+}
+
+// NewCollector создаёт сборщик результатов workers обработчиков.
+func NewCollector(workers int) *Collector {
+	return &Collector{
+		Stats:        mainStats,
+		ByWorker:     make([]int64, workers),
+		lastByWorker: make([]int64, workers),
+	}
+}
+
+// Collect учитывает все числа из seq. Первая ошибка получателей
+// прекращает сбор и возвращается.
+func (c *Collector) Collect(seq iter.Seq[Item]) error {
+	for v := range seq {
+		if err := c.Add(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add учитывает число v и передаёт его получателям. Возвращает ошибку
+// первого получателя, который не смог его записать.
+func (c *Collector) Add(v Item) error {
+	if traceItems {
+		tracef(v, "получено, в пути %v", time.Since(v.Born))
+	}
+	c.Count++
+	c.Stats.Processed.Add(1)
+	c.Stats.ProcessedSum.Add(v.Value)
+	c.Sum += v.Value
+	c.ByWorker[v.Worker]++
+	// источник учитывает число уже после отправки, поэтому одно
+	// число может опередить учёт
+	if generated := atomic.LoadInt64(c.Generated); c.Count > generated+1 && !c.overflow {
+		c.overflow = true
+		c.Alert(Violation{Kind: ViolationChecksum, Worker: v.Worker, Seq: v.Seq,
+			Detail: fmt.Sprintf("получено %d чисел, а сгенерировано %d", c.Count, generated)})
+	}
+	for _, sink := range c.Sinks {
+		if err := sink.Put(v); err != nil {
+			return err
+		}
+	}
+	c.Budget.CheckBytes()
+	switch {
+	case v.Retries > 0:
+		// выданное заново после сбоя число законно приходит позже
+		// следующих за ним
+		c.Retried++
+	case v.Seq <= c.lastByWorker[v.Worker]:
+		log.Printf("[%v] обработчик %d: число №%d пришло после №%d\n",
+			v.Trace, v.Worker, v.Seq, c.lastByWorker[v.Worker])
+		c.Disorder++
+		c.Alert(Violation{Kind: ViolationSequence, Worker: v.Worker, Seq: v.Seq,
+			Detail: fmt.Sprintf("обработчик %d: число №%d пришло после №%d", v.Worker, v.Seq, c.lastByWorker[v.Worker])})
+	default:
+		c.lastByWorker[v.Worker] = v.Seq
+	}
+	if v.Late {
+		c.Late++
+		return nil
+	}
+	if c.Ordered && v.Seq <= c.lastSeq {
+		log.Printf("[%v] число №%d пришло после №%d\n", v.Trace, v.Seq, c.lastSeq)
+		c.Disorder++
+		c.Alert(Violation{Kind: ViolationSequence, Worker: -1, Seq: v.Seq,
+			Detail: fmt.Sprintf("число №%d пришло после №%d", v.Seq, c.lastSeq)})
+	}
+	c.lastSeq = v.Seq
+	return nil
+}
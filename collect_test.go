@@ -0,0 +1,44 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestCollectorDisorder проверяет, что сборщик замечает число, пришедшее
+// от обработчика после следующего за ним, и не путает с ним выданное
+// заново после сбоя.
+func TestCollectorDisorder(t *testing.T) {
+	var generated int64 = 4
+	var alerts []Violation
+	c := NewCollector(2)
+	c.Budget, c.Generated = &Budget{}, &generated
+	c.Alert = func(v Violation) { alerts = append(alerts, v) }
+
+	items := []Item{
+		{Seq: 1, Value: 1, Worker: 0},
+		{Seq: 3, Value: 3, Worker: 0},
+		{Seq: 2, Value: 2, Worker: 1},
+		{Seq: 2, Value: 2, Worker: 0, Retries: 1},
+	}
+	if err := c.Collect(slices.Values(items)); err != nil {
+		t.Fatal(err)
+	}
+	if c.Count != 4 || c.Sum != 8 {
+		t.Errorf("получено %d чисел на сумму %d, ожидалось 4 на сумму 8", c.Count, c.Sum)
+	}
+	if c.Disorder != 0 || c.Retried != 1 || len(alerts) != 0 {
+		t.Errorf("без общего порядка: нарушений %d, выдано заново %d, тревог %v", c.Disorder, c.Retried, alerts)
+	}
+
+	c = NewCollector(1)
+	c.Ordered, c.Budget, c.Generated = true, &Budget{}, &generated
+	c.Alert = func(v Violation) { alerts = append(alerts, v) }
+	items = []Item{{Seq: 1, Value: 1}, {Seq: 3, Value: 3}, {Seq: 2, Value: 2}}
+	if err := c.Collect(slices.Values(items)); err != nil {
+		t.Fatal(err)
+	}
+	if c.Disorder != 2 || len(alerts) != 2 {
+		t.Errorf("число №2 после №3: нарушений %d, тревог %d, ожидалось по 2", c.Disorder, len(alerts))
+	}
+}
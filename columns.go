@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// columnKind — вид столбца в табличных выгрузках.
+type columnKind int
+
+const (
+	columnInt64     columnKind = iota
+	columnInt32                // целое в 4 байтах
+	columnTimestamp            // время в микросекундах Unix, 8 байт
+)
+
+// itemColumn — столбец табличной выгрузки результатов: название, вид
+// и значение для числа it, полученного в момент received.
+type itemColumn struct {
+	name  string
+	kind  columnKind
+	value func(it Item, received time.Time) int64
+}
+
+// itemColumns — что записывается о каждом числе в Parquet и Arrow.
+var itemColumns = []itemColumn{
+	{"seq", columnInt64, func(it Item, _ time.Time) int64 { return it.Seq }},
+	{"value", columnInt64, func(it Item, _ time.Time) int64 { return it.Value }},
+	{"worker", columnInt32, func(it Item, _ time.Time) int64 { return int64(it.Worker) }},
+	{"born", columnTimestamp, func(it Item, _ time.Time) int64 { return it.Born.UnixMicro() }},
+	{"received", columnTimestamp, func(_ Item, t time.Time) int64 { return t.UnixMicro() }},
+	{"latency_us", columnInt64, func(it Item, t time.Time) int64 { return t.Sub(it.Born).Microseconds() }},
+	{"run", columnInt64, func(it Item, _ time.Time) int64 { return int64(it.Run) }},
+}
+
+// appendValues дописывает к b значения столбца vs в порядке little-endian.
+func (c itemColumn) appendValues(b []byte, vs []int64) []byte {
+	for _, v := range vs {
+		if c.kind == columnInt32 {
+			b = binary.LittleEndian.AppendUint32(b, uint32(v))
+		} else {
+			b = binary.LittleEndian.AppendUint64(b, uint64(v))
+		}
+	}
+	return b
+}
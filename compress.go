@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressor сжимает записанное. Flush отправляет дальше всё сжатое
+// к этому моменту, чтобы получатель мог его прочитать, а Close завершает
+// сжатый поток, не закрывая того, в кого он пишет.
+type compressor interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+// nopCompressor пишет как есть.
+type nopCompressor struct{ io.Writer }
+
+func (nopCompressor) Flush() error { return nil }
+func (nopCompressor) Close() error { return nil }
+
+// ValidateCompress проверяет название сжатия: пустое, gzip или zstd.
+func ValidateCompress(kind string) error {
+	switch kind {
+	case "", "gzip", "zstd":
+		return nil
+	default:
+		return fmt.Errorf("неизвестное сжатие %q, поддерживаются gzip и zstd", kind)
+	}
+}
+
+// sealedCompressor — сжатие поверх шифрования: Close завершает сжатый
+// поток, а за ним и зашифрованный.
+type sealedCompressor struct {
+	compressor
+	seal *sealWriter
+}
+
+func (c sealedCompressor) Close() error {
+	return errors.Join(c.compressor.Close(), c.seal.Close())
+}
+
+// newCompressor возвращает писателя в w со сжатием kind. Если w шифрует
+// (NewSealWriter), Close писателя заканчивает и зашифрованный поток.
+func newCompressor(w io.Writer, kind string) compressor {
+	z := newPlainCompressor(w, kind)
+	if s, ok := w.(*sealWriter); ok {
+		return sealedCompressor{z, s}
+	}
+	return z
+}
+
+// newPlainCompressor возвращает писателя в w со сжатием kind.
+func newPlainCompressor(w io.Writer, kind string) compressor {
+	switch kind {
+	case "gzip":
+		return gzip.NewWriter(w)
+	case "zstd":
+		// ошибку NewWriter возвращает только при неверных параметрах
+		z, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			panic(err)
+		}
+		return z
+	}
+	return nopCompressor{w}
+}
+
+// Decompress возвращает читателя из r, распаковывающего сжатый gzip или
+// zstd поток. Несжатый поток читается как есть.
+func Decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(head, zstdMagic):
+		return zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+	}
+	return br, nil
+}
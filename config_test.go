@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigSoak(t *testing.T) {
+	cfg, err := ParseConfig([]string{"soak", "-duration", "0"}, nil)
+	if err != nil {
+		t.Fatalf("soak без предела времени: %v", err)
+	}
+	if !cfg.Soak {
+		t.Error("подкоманда soak не включила прогон на выносливость")
+	}
+	cfg, err = ParseConfig([]string{"soak"}, nil)
+	if err != nil || cfg.Duration != 0 {
+		t.Errorf("soak по умолчанию: duration %v, ошибка %v; ожидался прогон без предела времени", cfg.Duration, err)
+	}
+
+	if _, err := ParseConfig([]string{"-duration", "0"}, nil); err == nil || !strings.Contains(err.Error(), "предел") {
+		t.Errorf("без подкоманды soak и без пределов: ошибка %v, ожидалась ошибка о пределе", err)
+	}
+}
+
+func TestValidateMaxBytesNeedsSink(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Duration, cfg.MaxBytes = 0, 1000
+	if err := cfg.Validate(); err == nil {
+		t.Error("max_bytes без получателей прошёл проверку, а остановить источник он не сможет")
+	}
+	cfg.Parquet = "results.parquet"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("max_bytes с получателем: %v", err)
+	}
+}
+
+func TestValidateElectNeedsHost(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Elect = "leader.lock"
+	for _, addr := range []string{":9000", "0.0.0.0:9000", "[::]:9000"} {
+		cfg.Coordinator = addr
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("выборы с адресом %s прошли проверку, а обработчики по нему не подключатся", addr)
+		}
+	}
+	cfg.Advertise = "node1:9000"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("выборы с advertise: %v", err)
+	}
+	cfg.Coordinator, cfg.Advertise = "node1:9000", ""
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("выборы с хостом в coordinator: %v", err)
+	}
+}
+
+func TestPipelinesConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"pipelines": {"fast": {"workers": 2, "max_items": 100}}}`), 0o644)
+	cfg, err := ParseConfig([]string{"-config", path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast := cfg.Pipelines["fast"]
+	if fast.Workers != 2 || fast.MaxItems != 100 || fast.ReorderBuffer != DefaultConfig().ReorderBuffer {
+		t.Errorf("конвейер fast прочитан не поверх настроек по умолчанию: %+v", fast)
+	}
+
+	for _, data := range []string{
+		`{"pipelines": {"a b": {"workers": 2}}}`,
+		`{"pipelines": {"a": {"workers": 2, "console": true}}}`,
+		`{"pipelines": {"a": {"workers": 2}}, "max_bytes": 10, "parquet": "r.parquet"}`,
+	} {
+		os.WriteFile(path, []byte(data), 0o644)
+		if _, err := ParseConfig([]string{"-config", path}, nil); err == nil {
+			t.Errorf("настройки %s прошли проверку", data)
+		}
+	}
+}
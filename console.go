@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Control — ручное управление работающим конвейером: пауза источника
+// и число обработчиков, которые берут числа. Методы nil-значения ничего
+// не ограничивают.
+type Control struct {
+	mu       sync.Mutex
+	workers  int           // сколько обработчиков запущено
+	limit    int           // до скольких обработчиков Scale может их нарастить
+	start    func(id int)  // запускает обработчик id
+	active   int           // сколько из них берут числа
+	paused   bool          // стоит ли источник на паузе
+	changed  chan struct{} // закрывается при каждом изменении
+	released bool          // источник закончился, ограничения сняты
+	parked   int           // сколько источников сейчас ждут снятия паузы
+}
+
+// NewControl создаёт управление конвейером из workers обработчиков.
+func NewControl(workers int) *Control {
+	return &Control{workers: workers, limit: workers, active: workers, changed: make(chan struct{})}
+}
+
+// Grow разрешает Scale запускать новые обработчики, пока их не станет
+// limit; обработчик id запускает start. start вызывается под блокировкой
+// и не должен сам обращаться к c. Вызывать Grow нужно до первого Scale.
+func (c *Control) Grow(limit int, start func(id int)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limit, c.start = max(limit, c.workers), start
+}
+
+// notify будит всех, кто ждёт изменений. Вызывается под c.mu.
+func (c *Control) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// Pause останавливает источник: он будет ждать Resume.
+func (c *Control) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = !c.released
+	c.notify()
+}
+
+// Resume снимает паузу.
+func (c *Control) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = false
+	c.notify()
+}
+
+// Scale оставляет брать числа только обработчикам с номерами меньше n,
+// остальные ждут, продолжая присылать сигналы жизни. Если запущено меньше
+// n обработчиков, недостающие запускаются. Когда источник закончился,
+// число обработчиков не меняется.
+func (c *Control) Scale(n int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n < 1 || n > c.limit {
+		return fmt.Errorf("обработчиков может быть от 1 до %d", c.limit)
+	}
+	if !c.released {
+		for ; c.workers < n; c.workers++ {
+			c.start(c.workers)
+		}
+		c.active = n
+	}
+	c.notify()
+	return nil
+}
+
+// Release снимает все ограничения, чтобы конвейер доработал, когда
+// источник закончился.
+func (c *Control) Release() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.released = true
+	c.paused = false
+	c.active = c.workers
+	c.notify()
+}
+
+// State возвращает, стоит ли источник на паузе и сколько обработчиков
+// берут числа.
+func (c *Control) State() (paused bool, active int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused, c.active
+}
+
+// Workers возвращает, сколько обработчиков запущено: без управления
+// это все all обработчиков.
+func (c *Control) Workers(all int) int {
+	if c == nil {
+		return all
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.workers
+}
+
+// Active возвращает, сколько обработчиков берут числа — без управления
+// все all, — и канал, который закроется, когда это может измениться.
+func (c *Control) Active(all int) (int, <-chan struct{}) {
+	if c == nil {
+		return all, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active, c.changed
+}
+
+// Allows сообщает, может ли обработчик id брать числа, и возвращает канал,
+// который закроется, когда это может измениться.
+func (c *Control) Allows(id int) (bool, <-chan struct{}) {
+	if c == nil {
+		return true, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return id < c.active, c.changed
+}
+
+// Wait ждёт снятия паузы. Возвращает false, если контекст ctx отменён
+// раньше.
+func (c *Control) Wait(ctx context.Context) bool {
+	if c == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.paused {
+		changed := c.changed
+		c.parked++
+		c.mu.Unlock()
+		select {
+		case <-ctx.Done():
+		case <-changed:
+		}
+		c.mu.Lock()
+		c.parked--
+		if ctx.Err() != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Parked сообщает, стоит ли источник на паузе прямо сейчас, то есть
+// всё, что он успел отправить, уже учтено. Источник, который закончился,
+// тоже считается стоящим.
+func (c *Control) Parked() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.parked > 0 || c.released
+}
+
+// Released сообщает, закончился ли источник.
+func (c *Control) Released() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.released
+}
+
+// RunConsole читает команды из r и отвечает в w, пока r не закончится
+// или не прозвучит quit. scalable — можно ли менять число обработчиков:
+// удалённые обработчики координатора приходят и уходят сами.
+// stats пишет сводку, quit останавливает источник.
+func RunConsole(r io.Reader, w io.Writer, ctl *Control, scalable bool, stats func(io.Writer), quit func()) {
+	const help = "команды: pause, resume, scale N, stats, quit"
+	fmt.Fprintln(w, help)
+	sc := bufio.NewScanner(r)
+	for fmt.Fprint(w, "> "); sc.Scan(); fmt.Fprint(w, "> ") {
+		cmd := strings.Fields(sc.Text())
+		if len(cmd) == 0 {
+			continue
+		}
+		switch cmd[0] {
+		case "pause":
+			ctl.Pause()
+			fmt.Fprintln(w, "источник на паузе")
+		case "resume":
+			ctl.Resume()
+			fmt.Fprintln(w, "источник продолжает")
+		case "scale":
+			if !scalable {
+				fmt.Fprintln(w, "через координатора число обработчиков не меняется")
+				continue
+			}
+			n, err := 0, fmt.Errorf("нужно число обработчиков: scale N")
+			if len(cmd) == 2 {
+				n, err = strconv.Atoi(cmd[1])
+			}
+			if err == nil {
+				err = ctl.Scale(n)
+			}
+			if err != nil {
+				fmt.Fprintln(w, err)
+				continue
+			}
+			fmt.Fprintln(w, "числа берут обработчиков:", n)
+		case "stats":
+			stats(w)
+		case "quit":
+			fmt.Fprintln(w, "источник остановлен, дорабатываем то, что в пути")
+			quit()
+			return
+		default:
+			fmt.Fprintln(w, help)
+		}
+	}
+}
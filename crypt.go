@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// EnvKey — переменная окружения с ключом шифрования результатов
+// в шестнадцатеричном виде. Флага для неё нет, чтобы ключ не попадал
+// в список процессов.
+const EnvKey = EnvPrefix + "KEY"
+
+const (
+	sealMagic    = "S9GCM2\n" // с этой строки начинается зашифрованный поток
+	sealMaxFrame = 1 << 24    // больше этого кадр считается повреждённым
+	sealFinal    = 1 << 31    // бит в длине кадра: последний кадр потока
+)
+
+// sealAAD возвращает проверяемые данные кадра номер frame: номер и
+// признак последнего кадра.
+func sealAAD(frame uint64, final bool) []byte {
+	aad := binary.BigEndian.AppendUint64(make([]byte, 0, 9), frame)
+	if final {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
+}
+
+// LoadKey читает ключ AES из файла path или, если путь пустой, из значения
+// переменной EnvKey env. Ключ записывается шестнадцатеричной строкой
+// длиной 32, 48 или 64 символа (AES-128, AES-192, AES-256). Если ключ
+// не задан, возвращает nil: результаты пишутся открытым текстом.
+func LoadKey(path, env string) (cipher.AEAD, error) {
+	text, err := loadSecret(path, env)
+	if err != nil || text == "" {
+		return nil, err
+	}
+	key, err := hex.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("ключ должен быть шестнадцатеричной строкой: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealWriter шифрует каждый вызов Write отдельным кадром: длина, nonce
+// и шифротекст. Номер кадра входит в проверяемые данные, поэтому
+// переставленные или выброшенные из середины кадры не расшифруются.
+// Close дописывает пустой последний кадр; признак последнего кадра тоже
+// проверяется, поэтому поток, оборванный на границе кадра, читатель
+// отличит от законченного.
+type sealWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	frame  uint64
+	header bool
+	closed bool
+	buf    []byte
+}
+
+// NewSealWriter возвращает писателя, который шифрует всё записанное в w
+// ключом aead. Если aead — nil, возвращает сам w. Зашифрованный поток
+// нужно закончить Close; newCompressor делает это сам при своём Close.
+func NewSealWriter(w io.Writer, aead cipher.AEAD) io.Writer {
+	if aead == nil {
+		return w
+	}
+	return &sealWriter{w: w, aead: aead}
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if s.closed {
+		return 0, errors.New("запись в законченный зашифрованный поток")
+	}
+	if err := s.seal(p, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close дописывает последний кадр. Сам w не закрывается.
+func (s *sealWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.seal(nil, true)
+}
+
+// seal шифрует p очередным кадром и пишет его в w.
+func (s *sealWriter) seal(p []byte, final bool) error {
+	s.buf = s.buf[:0]
+	if !s.header {
+		s.buf = append(s.buf, sealMagic...)
+	}
+	size := uint32(s.aead.NonceSize() + len(p) + s.aead.Overhead())
+	if final {
+		size |= sealFinal
+	}
+	s.buf = binary.BigEndian.AppendUint32(s.buf, size)
+	start := len(s.buf)
+	s.buf = append(s.buf, make([]byte, s.aead.NonceSize())...)
+	nonce := s.buf[start:]
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	s.buf = s.aead.Seal(s.buf, nonce, p, sealAAD(s.frame, final))
+
+	if _, err := s.w.Write(s.buf); err != nil {
+		return err
+	}
+	s.header = true
+	s.frame++
+	return nil
+}
+
+// openReader расшифровывает поток, записанный sealWriter.
+type openReader struct {
+	r     *bufio.Reader
+	aead  cipher.AEAD
+	frame uint64
+	done  bool   // прочитан последний кадр
+	plain []byte // расшифрованное, но ещё не прочитанное
+	buf   []byte
+}
+
+// OpenStream возвращает читателя открытого текста из r. Если поток
+// зашифрован, он расшифровывается ключом aead, а без ключа возвращается
+// ошибка. Незашифрованный поток читается как есть.
+func OpenStream(r io.Reader, aead cipher.AEAD) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(sealMagic))
+	if err != nil || !bytes.Equal(head, []byte(sealMagic)) {
+		// короткий или открытый поток читается как есть
+		return br, nil
+	}
+	if aead == nil {
+		return nil, fmt.Errorf("поток зашифрован, задайте ключ в %s или -key-file", EnvKey)
+	}
+	br.Discard(len(sealMagic))
+	return &openReader{r: br, aead: aead}, nil
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.plain) == 0 {
+		if err := o.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, o.plain)
+	o.plain = o.plain[n:]
+	return n, nil
+}
+
+// next читает и расшифровывает очередной кадр. Поток заканчивается
+// только последним кадром: конец ввода до него — io.ErrUnexpectedEOF.
+func (o *openReader) next() error {
+	if o.done {
+		return io.EOF
+	}
+	var size uint32
+	if err := binary.Read(o.r, binary.BigEndian, &size); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("кадр %d: поток оборван до последнего кадра: %w", o.frame, err)
+	}
+	final := size&sealFinal != 0
+	size &^= sealFinal
+	ns := o.aead.NonceSize()
+	if size < uint32(ns+o.aead.Overhead()) || size > sealMaxFrame {
+		return fmt.Errorf("кадр %d: неверная длина %d", o.frame, size)
+	}
+	o.buf = append(o.buf[:0], make([]byte, size)...)
+	if _, err := io.ReadFull(o.r, o.buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("кадр %d: %w", o.frame, err)
+	}
+	plain, err := o.aead.Open(o.buf[ns:ns], o.buf[:ns], o.buf[ns:], sealAAD(o.frame, final))
+	if err != nil {
+		return fmt.Errorf("кадр %d: не удалось расшифровать: %w", o.frame, err)
+	}
+	if final {
+		switch _, err := o.r.Peek(1); {
+		case err == nil:
+			return fmt.Errorf("кадр %d: данные после последнего кадра", o.frame)
+		case err != io.EOF:
+			return fmt.Errorf("кадр %d: %w", o.frame, err)
+		}
+		o.done = true
+	}
+	o.frame++
+	o.plain = plain
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"io"
+	"testing"
+)
+
+func testAEAD(t *testing.T) cipher.AEAD {
+	t.Helper()
+	block, err := aes.NewCipher(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aead
+}
+
+// sealed шифрует строки lines отдельными кадрами и заканчивает поток.
+func sealed(t *testing.T, aead cipher.AEAD, lines ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewSealWriter(&buf, aead).(*sealWriter)
+	for _, l := range lines {
+		if _, err := io.WriteString(w, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func openAll(aead cipher.AEAD, data []byte) (string, error) {
+	r, err := OpenStream(bytes.NewReader(data), aead)
+	if err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(r)
+	return string(b), err
+}
+
+// TestSealTruncated проверяет, что поток, оборванный на границе кадра,
+// не принимается за законченный.
+func TestSealTruncated(t *testing.T) {
+	aead := testAEAD(t)
+	data := sealed(t, aead, "1\n", "2\n")
+	if got, err := openAll(aead, data); err != nil || got != "1\n2\n" {
+		t.Fatalf("прочитано %q, ошибка %v", got, err)
+	}
+
+	// последний кадр пустой: длина, nonce и метка проверки
+	final := 4 + aead.NonceSize() + aead.Overhead()
+	if _, err := openAll(aead, data[:len(data)-final]); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("поток без последнего кадра: ошибка %v, ожидалась io.ErrUnexpectedEOF", err)
+	}
+
+	// признак последнего кадра проверяется вместе с ним: поставить его
+	// среднему кадру нельзя
+	forged := bytes.Clone(data[:len(data)-final])
+	forged[len(sealMagic)] |= 0x80
+	if _, err := openAll(aead, forged); err == nil {
+		t.Error("кадр с подделанным признаком последнего расшифрован")
+	}
+
+	if _, err := openAll(aead, append(bytes.Clone(data), '3')); err == nil {
+		t.Error("данные после последнего кадра прочитаны без ошибки")
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+)
+
+// Dispatch раздаёт числа из in по каналам outs, выбирая канал функцией
+// pick, и закрывает outs, когда in закрыт. В отличие от общего канала,
+// из которого числа забирает первый освободившийся обработчик, здесь
+// каждое число ждёт именно выбранного обработчика.
+func Dispatch(in <-chan Item, outs []chan Item, pick func(Item) int) {
+	defer func() {
+		for _, out := range outs {
+			close(out)
+		}
+	}()
+	for it := range in {
+		outs[pick(it)] <- it
+	}
+}
+
+// ScaledPicker возвращает функцию выбора для Dispatch, которая выбирает
+// только из обработчиков, берущих числа по ctl, из всех all. Когда их
+// число меняется, выбор заново строит build: при раздаче по ключам кольцо
+// перестраивается, и новых хозяев получает лишь часть ключей.
+func ScaledPicker(ctl *Control, all int, build func(workers int) func(Item) int) func(Item) int {
+	n, _ := ctl.Active(all)
+	pick := build(n)
+	return func(it Item) int {
+		if m, _ := ctl.Active(all); m != n {
+			n, pick = m, build(m)
+		}
+		return pick(it)
+	}
+}
+
+// StartDispatch запускает раздачу чисел из in n обработчикам так, как
+// задано в cfg: по ключам, по весам или кругами, — среди тех, кто берёт
+// числа по ctl. Возвращает собственные входы обработчиков или nil, если
+// раздатчик не нужен и все они читают из in.
+func StartDispatch(cfg Config, in <-chan Item, n int, ctl *Control) []chan Item {
+	// pick выбирает обработчика из m, которые берут числа
+	var pick func(m int) func(Item) int
+	switch {
+	case cfg.Keys > 0:
+		pick = func(m int) func(Item) int { return KeyPicker(cfg.Keys, cfg.Weights, m) }
+	case len(cfg.Weights) > 0:
+		pick = func(m int) func(Item) int { return WeightedPicker(cfg.Weights, m) }
+	case cfg.Fair == 0:
+		return nil
+	}
+	ins := make([]chan Item, n)
+	for i := range ins {
+		ins[i] = make(chan Item)
+	}
+	if pick != nil {
+		go Dispatch(in, ins, ScaledPicker(ctl, n, pick))
+	} else {
+		go FairDispatch(in, ins, cfg.Fair, ctl)
+	}
+	return ins
+}
+
+// WeightFor возвращает вес обработчика id из weights или 1.
+func WeightFor(weights map[int]int, id int) int {
+	if w, ok := weights[id]; ok {
+		return w
+	}
+	return 1
+}
+
+// WeightedPicker возвращает функцию выбора для Dispatch, которая раздаёт
+// числа workers обработчикам пропорционально весам weights. Используется
+// плавный взвешенный round-robin: обработчик с весом 2 получает каждое
+// второе число, а не два подряд.
+func WeightedPicker(weights map[int]int, workers int) func(Item) int {
+	w := make([]int, workers)
+	current := make([]int, workers)
+	var total int
+	for i := range w {
+		w[i] = WeightFor(weights, i)
+		total += w[i]
+	}
+	return func(Item) int {
+		best := 0
+		for i := range current {
+			current[i] += w[i]
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		return best
+	}
+}
+
+// WeightedShares возвращает, какую долю чисел должен получить каждый
+// из workers обработчиков при раздаче по весам weights.
+func WeightedShares(weights map[int]int, workers int) []float64 {
+	shares := make([]float64, workers)
+	var total float64
+	for i := range shares {
+		shares[i] = float64(WeightFor(weights, i))
+		total += shares[i]
+	}
+	for i := range shares {
+		shares[i] /= total
+	}
+	return shares
+}
+
+// ringReplicas — сколько точек на кольце у обработчика с весом 1.
+const ringReplicas = 128
+
+// hashRing — кольцо согласованного хеширования: ключ достаётся
+// обработчику, чья точка на кольце следует за хешем ключа. Если число
+// обработчиков изменится, новых хозяев получит лишь часть ключей
+// пропорционально изменению, остальные останутся на прежних местах.
+type hashRing struct {
+	points []uint64 // точки кольца по возрастанию
+	owners []int    // обработчик, которому принадлежит каждая точка
+}
+
+// newHashRing строит кольцо для workers обработчиков; у обработчика
+// с весом w из weights w*ringReplicas точек.
+func newHashRing(weights map[int]int, workers int) *hashRing {
+	type point struct {
+		hash  uint64
+		owner int
+	}
+	var ps []point
+	for id := 0; id < workers; id++ {
+		for r := 0; r < WeightFor(weights, id)*ringReplicas; r++ {
+			ps = append(ps, point{ringHash(uint64(id)<<32 | uint64(r)), id})
+		}
+	}
+	slices.SortFunc(ps, func(a, b point) int { return cmp.Compare(a.hash, b.hash) })
+
+	r := &hashRing{points: make([]uint64, len(ps)), owners: make([]int, len(ps))}
+	for i, p := range ps {
+		r.points[i], r.owners[i] = p.hash, p.owner
+	}
+	return r
+}
+
+// owner возвращает обработчик, которому принадлежит ключ key.
+func (r *hashRing) owner(key int64) int {
+	h := ringHash(uint64(key) ^ 0x9e3779b97f4a7c15)
+	i, _ := slices.BinarySearch(r.points, h)
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// ringHash перемешивает биты x (финализатор splitmix64). Хеш не зависит
+// от запуска, поэтому ключи остаются за теми же обработчиками и после
+// перезапуска.
+func ringHash(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// numKey возвращает ключ числа v при keys ключах: остаток от деления,
+// неотрицательный и для отрицательных чисел.
+func numKey(v, keys int64) int64 {
+	return (v%keys + keys) % keys
+}
+
+// KeyPicker возвращает функцию выбора для Dispatch, которая отправляет
+// все числа с одинаковым ключом value % keys одному и тому же обработчику,
+// так что порядок чисел внутри ключа сохраняется. Веса weights задают
+// долю кольца каждого обработчика. Через ScaledPicker кольцо
+// перестраивается, когда меняется число обработчиков; у ключа, сменившего
+// хозяина, число, которое ещё обрабатывает прежний, может выйти позже
+// следующего.
+func KeyPicker(keys int64, weights map[int]int, workers int) func(Item) int {
+	ring := newHashRing(weights, workers)
+	return func(it Item) int {
+		return ring.owner(numKey(it.Value, keys))
+	}
+}
+
+// KeyShares возвращает, какую долю чисел получит каждый из workers
+// обработчиков при раздаче по keys ключам, если ключи встречаются
+// одинаково часто, как у чисел генератора.
+func KeyShares(keys int64, weights map[int]int, workers int) []float64 {
+	ring := newHashRing(weights, workers)
+	shares := make([]float64, workers)
+	for k := int64(0); k < keys; k++ {
+		shares[ring.owner(k)] += 1 / float64(keys)
+	}
+	return shares
+}
+
+// FairDispatch раздаёт числа из in по каналам outs кругами: за круг каждый
+// обработчик получает не больше quota чисел, а круг заканчивается, когда
+// свою долю получили все. Внутри круга число достаётся первому
+// освободившемуся из тех, кто долю ещё не выбрал, поэтому быстрые
+// обработчики не могут оставить медленных без работы. В кругах участвуют
+// только обработчики, берущие числа по ctl; когда их число меняется,
+// начинается новый круг. Каналы outs закрываются, когда in закрыт.
+func FairDispatch(in <-chan Item, outs []chan Item, quota int, ctl *Control) {
+	n := len(outs)
+	var mu sync.Mutex
+	// tokens[i] — сколько чисел обработчик i ещё может получить в этом круге
+	tokens := make([]int, n)
+	var round int               // сколько обработчиков участвует в круге
+	var left int                // сколько чисел осталось раздать до конца круга
+	wake := make(chan struct{}) // закрывается, когда начинается новый круг
+	refill := func(active int) {
+		round, left = active, active*quota
+		for i := range tokens {
+			tokens[i] = 0
+			if i < active {
+				tokens[i] = quota
+			}
+		}
+		close(wake)
+		wake = make(chan struct{})
+	}
+	// take ждёт, пока обработчик i сможет получить число в этом круге.
+	// Возвращает false, если in закончился. Вызывается под mu.
+	take := func(i int, stop <-chan struct{}) bool {
+		for {
+			if active, _ := ctl.Active(n); active != round {
+				refill(active)
+			}
+			if tokens[i] > 0 {
+				tokens[i]--
+				return true
+			}
+			_, changed := ctl.Active(n)
+			next := wake
+			mu.Unlock()
+			select {
+			case <-stop:
+				mu.Lock()
+				return false
+			case <-next:
+			case <-changed:
+			}
+			mu.Lock()
+		}
+	}
+
+	stop := make(chan struct{}) // закрывается, когда in закончился
+	var once sync.Once
+	var wg sync.WaitGroup
+	for i, out := range outs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(out)
+			for {
+				mu.Lock()
+				ok := take(i, stop)
+				mu.Unlock()
+				if !ok {
+					return
+				}
+				it, ok := <-in
+				if !ok {
+					once.Do(func() { close(stop) })
+					return
+				}
+				out <- it
+				// последнее число круга открывает следующий
+				mu.Lock()
+				if left--; left <= 0 {
+					refill(round)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
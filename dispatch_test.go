@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestControlScaleStartsWorkers проверяет, что scale выше запущенного
+// числа обработчиков запускает недостающие, но не больше предела и не
+// после того, как источник закончился.
+func TestControlScaleStartsWorkers(t *testing.T) {
+	ctl := NewControl(5)
+	var started []int
+	ctl.Grow(10, func(id int) { started = append(started, id) })
+	if err := ctl.Scale(8); err != nil {
+		t.Fatal(err)
+	}
+	if len(started) != 3 || started[0] != 5 || started[2] != 7 {
+		t.Errorf("запущены обработчики %v, ожидались 5, 6 и 7", started)
+	}
+	if n := ctl.Workers(0); n != 8 {
+		t.Errorf("запущено %d обработчиков, ожидалось 8", n)
+	}
+	if err := ctl.Scale(2); err != nil {
+		t.Fatal(err)
+	}
+	if active, _ := ctl.Active(0); active != 2 || len(started) != 3 {
+		t.Errorf("после scale 2 берут числа %d, запущено %v", active, started)
+	}
+	if err := ctl.Scale(11); err == nil {
+		t.Error("scale выше предела прошёл")
+	}
+	ctl.Release()
+	if err := ctl.Scale(10); err != nil || len(started) != 3 {
+		t.Errorf("scale после конца источника: ошибка %v, запущены %v", err, started)
+	}
+}
+
+// TestScaledKeyPicker проверяет, что при раздаче по ключам числа
+// достаются только обработчикам, берущим числа, а при добавлении
+// обработчика хозяина меняет лишь часть ключей.
+func TestScaledKeyPicker(t *testing.T) {
+	const keys = 1000
+	ctl := NewControl(8)
+	pick := ScaledPicker(ctl, 8, func(n int) func(Item) int { return KeyPicker(keys, nil, n) })
+	owners := func() []int {
+		o := make([]int, keys)
+		for k := range o {
+			o[k] = pick(Item{Value: int64(k)})
+		}
+		return o
+	}
+
+	ctl.Scale(4)
+	before := owners()
+	for k, w := range before {
+		if w >= 4 {
+			t.Fatalf("ключ %d достался обработчику %d, а числа берут 4", k, w)
+		}
+	}
+	ctl.Scale(5)
+	var moved int
+	for k, w := range owners() {
+		if w != before[k] {
+			if w != 4 {
+				t.Errorf("ключ %d перешёл от %d к %d, а не к новому обработчику", k, before[k], w)
+			}
+			moved++
+		}
+	}
+	// новому обработчику достаётся около пятой части ключей
+	if moved < keys/10 || moved > keys*3/10 {
+		t.Errorf("при добавлении обработчика переехало %d ключей из %d", moved, keys)
+	}
+}
+
+// TestFairDispatchScaled проверяет, что круги раздачи идут только между
+// обработчиками, берущими числа.
+func TestFairDispatchScaled(t *testing.T) {
+	ctl := NewControl(4)
+	ctl.Scale(2)
+	in := make(chan Item)
+	outs := make([]chan Item, 4)
+	for i := range outs {
+		outs[i] = make(chan Item)
+	}
+	go func() {
+		defer close(in)
+		for n := int64(1); n <= 100; n++ {
+			in <- Item{Seq: n, Value: n}
+		}
+	}()
+	go FairDispatch(in, outs, 3, ctl)
+
+	counts := make([]int, len(outs))
+	var wg sync.WaitGroup
+	for i, out := range outs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range out {
+				counts[i]++
+			}
+		}()
+	}
+	wg.Wait()
+	if counts[0]+counts[1] != 100 || counts[2] != 0 || counts[3] != 0 {
+		t.Errorf("разбивка %v, ожидалось 100 чисел только у обработчиков 0 и 1", counts)
+	}
+	if d := counts[0] - counts[1]; d < -3 || d > 3 {
+		t.Errorf("разбивка %v: круги разошлись больше чем на долю", counts)
+	}
+}
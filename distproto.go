@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// distWorkMethod — полное имя метода Work службы из dist.proto.
+const distWorkMethod = "/sprint9.Coordinator/Work"
+
+// distServer — то, что служба Coordinator требует от сервера.
+type distServer interface {
+	admit(stream grpc.ServerStream) error
+}
+
+// distService описывает службу Coordinator из dist.proto так же, как её
+// описал бы код, сгенерированный protoc.
+var distService = grpc.ServiceDesc{
+	ServiceName: "sprint9.Coordinator",
+	HandlerType: (*distServer)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName: "Work",
+		Handler: func(srv any, stream grpc.ServerStream) error {
+			return srv.(distServer).admit(stream)
+		},
+		ServerStreams: true,
+		ClientStreams: true,
+	}},
+	Metadata: "dist.proto",
+}
+
+// distCodec кодирует distMessage в формат protobuf по схеме dist.proto.
+// Он называется proto, как стандартный кодек gRPC, поэтому координатор
+// понимает клиентов, сгенерированных protoc из той же схемы.
+type distCodec struct{}
+
+func (distCodec) Name() string { return "proto" }
+
+func (distCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(*distMessage)
+	if !ok {
+		return nil, fmt.Errorf("distCodec: неизвестный тип %T", v)
+	}
+	return msg.appendProto(nil), nil
+}
+
+func (distCodec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(*distMessage)
+	if !ok {
+		return fmt.Errorf("distCodec: неизвестный тип %T", v)
+	}
+	*msg = distMessage{}
+	return msg.parseProto(data)
+}
+
+// appendProto дописывает к b сообщение Message.
+func (m *distMessage) appendProto(b []byte) []byte {
+	b = appendProtoInt(b, 1, int64(m.Kind))
+	b = appendProtoInt(b, 2, m.LeaseID)
+	for _, it := range m.Items {
+		b = appendProtoItem(b, 3, it)
+	}
+	b = appendProtoInt(b, 4, int64(m.LeaseTTL))
+	if m.Item != (Item{}) {
+		b = appendProtoItem(b, 5, m.Item)
+	}
+	return appendProtoInt(b, 6, m.Processed)
+}
+
+// parseProto разбирает сообщение Message.
+func (m *distMessage) parseProto(b []byte) error {
+	return parseProto(b, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			m.Kind = distKind(v)
+		case 2:
+			m.LeaseID = int64(v)
+		case 3:
+			var it Item
+			if err := parseProtoItem(data, &it); err != nil {
+				return err
+			}
+			m.Items = append(m.Items, it)
+		case 4:
+			m.LeaseTTL = time.Duration(v)
+		case 5:
+			return parseProtoItem(data, &m.Item)
+		case 6:
+			m.Processed = int64(v)
+		}
+		return nil
+	})
+}
+
+// appendProtoItem дописывает к b число как поле num типа Item.
+func appendProtoItem(b []byte, num protowire.Number, it Item) []byte {
+	var f []byte
+	f = appendProtoInt(f, 1, it.Seq)
+	f = appendProtoInt(f, 2, it.Value)
+	f = appendProtoInt(f, 3, int64(it.Trace))
+	f = appendProtoInt(f, 4, int64(it.Worker))
+	if it.Late {
+		f = appendProtoInt(f, 5, 1)
+	}
+	f = appendProtoInt(f, 6, int64(it.Retries))
+	f = appendProtoInt(f, 7, protoTime(it.Born))
+	f = appendProtoInt(f, 8, protoTime(it.Deadline))
+	f = appendProtoInt(f, 9, int64(it.Run))
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, f)
+}
+
+// parseProtoItem разбирает сообщение Item.
+func parseProtoItem(b []byte, it *Item) error {
+	return parseProto(b, func(num protowire.Number, v uint64, _ []byte) error {
+		switch num {
+		case 1:
+			it.Seq = int64(v)
+		case 2:
+			it.Value = int64(v)
+		case 3:
+			it.Trace = TraceID(v)
+		case 4:
+			it.Worker = int(int64(v))
+		case 5:
+			it.Late = v != 0
+		case 6:
+			it.Retries = int(int64(v))
+		case 7:
+			it.Born = fromProtoTime(int64(v))
+		case 8:
+			it.Deadline = fromProtoTime(int64(v))
+		case 9:
+			it.Run = RunID(v)
+		}
+		return nil
+	})
+}
+
+// appendProtoInt дописывает к b целое поле num. Нулевые значения, как
+// принято в proto3, не передаются.
+func appendProtoInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// parseProto проходит по полям сообщения protobuf и вызывает field для
+// целых полей (v) и полей с длиной (data). Поля других типов пропускаются,
+// чтобы схему можно было расширять.
+func parseProto(b []byte, field func(num protowire.Number, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var v uint64
+		var data []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			data, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.VarintType && typ != protowire.BytesType {
+			continue
+		}
+		if err := field(num, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// protoTime переводит время в наносекунды Unix; нулевое время — в 0.
+func protoTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromProtoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
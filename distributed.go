@@ -0,0 +1,524 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+)
+
+// distKind — вид сообщения между координатором и удалённым обработчиком.
+type distKind int
+
+const (
+	distHello     distKind = iota // обработчик подключился
+	distAssign                    // координатор выдал аренду
+	distResult                    // обработчик вернул число
+	distExpired                   // число устарело, не дождавшись обработки
+	distRenew                     // обработчик продлевает аренду
+	distRangeDone                 // обработчик закончил аренду
+	distStop                      // чисел больше не будет
+	distBye                       // обработчик завершился
+)
+
+// distMessage — сообщение между координатором и удалённым обработчиком.
+// Координатор — сервер gRPC со службой из dist.proto: каждый обработчик
+// открывает один двунаправленный поток Work (при заданных сертификатах —
+// поверх TLS), и сообщения в нём приходят по порядку.
+type distMessage struct {
+	Kind      distKind
+	LeaseID   int64         // distAssign: номер аренды
+	Items     []Item        // distAssign: диапазон подряд идущих чисел
+	LeaseTTL  time.Duration // distAssign: срок аренды без продления
+	Item      Item          // distResult и distExpired
+	Processed int64         // distBye: сколько чисел обработчик обработал по своему счёту
+}
+
+const (
+	distFlushInterval = 100 * time.Millisecond // как долго координатор копит неполную аренду
+	distRenewParts    = 3                      // обработчик продлевает аренду столько раз за её срок, пока выдерживает паузу
+)
+
+// Lease — аренда: диапазон подряд идущих чисел, выданный одному удалённому
+// обработчику. Обработчик продлевает аренду, присылая результаты, а во
+// время паузы после числа — сообщения о продлении. Продлевает её сам
+// цикл обработки, поэтому аренда зависшего обработчика истекает. Если
+// обработчик отключился или аренда истекла, необработанный остаток
+// диапазона выдаётся другому обработчику, поэтому ни одно число не
+// теряется и не попадает на выход дважды.
+type Lease struct {
+	ID    int64
+	Items []Item
+}
+
+// leaseQueue — очередь аренд, ожидающих выдачи.
+type leaseQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []Lease // ждут выдачи; возвращённые после сбоя идут первыми
+	limit   int     // сколько новых аренд может ждать выдачи
+	active  int     // сколько аренд выдано и ещё не закончено
+	closed  bool    // новых аренд больше не будет
+}
+
+func newLeaseQueue(limit int) *leaseQueue {
+	q := &leaseQueue{limit: limit}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Add добавляет новую аренду, дожидаясь места в очереди.
+func (q *leaseQueue) Add(l Lease) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pending) >= q.limit {
+		q.cond.Wait()
+	}
+	q.pending = append(q.pending, l)
+	q.cond.Broadcast()
+}
+
+// Close сообщает, что новых аренд не будет.
+func (q *leaseQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// Get выдаёт очередную аренду. Возвращает false, когда все аренды
+// закончены и новых не будет.
+func (q *leaseQueue) Get() (Lease, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pending) == 0 && !q.finished() {
+		q.cond.Wait()
+	}
+	if len(q.pending) == 0 {
+		return Lease{}, false
+	}
+	l := q.pending[0]
+	q.pending = q.pending[1:]
+	q.active++
+	q.cond.Broadcast()
+	return l, true
+}
+
+// Done отмечает, что выданная аренда закончена.
+func (q *leaseQueue) Done() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.active--
+	q.cond.Broadcast()
+}
+
+// Requeue возвращает в начало очереди необработанный остаток аренды.
+func (q *leaseQueue) Requeue(l Lease) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.active--
+	if len(l.Items) > 0 {
+		q.pending = append([]Lease{l}, q.pending...)
+	}
+	q.cond.Broadcast()
+}
+
+// Wait ждёт, пока все аренды будут закончены.
+func (q *leaseQueue) Wait() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for !q.finished() {
+		q.cond.Wait()
+	}
+}
+
+func (q *leaseQueue) finished() bool {
+	return q.closed && q.active == 0 && len(q.pending) == 0
+}
+
+var leasesReassigned = newCounter("leases_reassigned") // сколько аренд выдано заново после сбоя
+
+// Coordinator раздаёт числа удалённым обработчикам арендами и собирает
+// от них результаты. Удалённые обработчики занимают пронумерованные места,
+// поэтому разбивка по каналам и проверки остаются такими же,
+// как у локальных обработчиков.
+type Coordinator struct {
+	ln        net.Listener
+	srv       *grpc.Server
+	leaseSize int
+	leaseTTL  time.Duration
+	queue     *leaseQueue
+
+	// куда отправлять числа от обработчиков; задаются в Run до начала приёма
+	out, expired chan<- Item
+	amounts      []int64
+	mon          *Monitor
+
+	mu     sync.Mutex
+	free   []int // свободные места
+	closed bool  // обработчики больше не нужны
+}
+
+// ListenCoordinator начинает принимать удалённых обработчиков по адресу
+// addr. Одновременно работают не больше workers обработчиков, каждый
+// получает аренды по leaseSize чисел сроком leaseTTL. Если conf не nil,
+// обработчики подключаются по TLS.
+func ListenCoordinator(addr string, workers, leaseSize int, leaseTTL time.Duration, conf *tls.Config) (*Coordinator, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	opts := []grpc.ServerOption{grpc.ForceServerCodec(distCodec{})}
+	if conf != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(conf)))
+	}
+	c := &Coordinator{
+		ln:        ln,
+		srv:       grpc.NewServer(opts...),
+		leaseSize: leaseSize,
+		leaseTTL:  leaseTTL,
+		queue:     newLeaseQueue(workers),
+	}
+	c.srv.RegisterService(&distService, c)
+	for i := workers - 1; i >= 0; i-- {
+		c.free = append(c.free, i)
+	}
+	return c, nil
+}
+
+// Addr возвращает адрес, на котором координатор принимает обработчиков.
+func (c *Coordinator) Addr() net.Addr {
+	return c.ln.Addr()
+}
+
+// Run раздаёт числа из in и отправляет результаты в out, а устаревшие
+// числа — в expired. amounts[i] считает числа, полученные от места i.
+// Когда in закрыт и все аренды закончены, Run закрывает out
+// и перестаёт принимать обработчиков.
+func (c *Coordinator) Run(in <-chan Item, out *ChannelOwner[Item], expired chan<- Item, amounts []int64, mon *Monitor) {
+	c.out, c.expired, c.amounts, c.mon = out.C, expired, amounts, mon
+	go func() {
+		if err := c.srv.Serve(c.ln); err != nil {
+			log.Printf("coordinator: %v\n", err)
+		}
+	}()
+
+	c.lease(in)
+	c.queue.Wait()
+
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	// GracefulStop дожидается, пока все обработчики попрощаются
+	c.srv.GracefulStop()
+	out.Close("координатор")
+}
+
+// lease нарезает числа из in на аренды.
+func (c *Coordinator) lease(in <-chan Item) {
+	defer c.queue.Close()
+
+	tick := time.NewTicker(distFlushInterval)
+	defer tick.Stop()
+
+	var id int64
+	var batch []Item
+	flush := func() {
+		if len(batch) > 0 {
+			id++
+			c.queue.Add(Lease{ID: id, Items: batch})
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case it, ok := <-in:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, it)
+			if len(batch) >= c.leaseSize {
+				flush()
+			}
+		case <-tick.C:
+			flush()
+		}
+	}
+}
+
+// admit обслуживает вызов Work: выдаёт подключившемуся обработчику
+// свободное место и раздаёт ему аренды. При TLS вызов начинается только
+// после успешного рукопожатия, поэтому обработчик без годного
+// сертификата места не занимает.
+func (c *Coordinator) admit(stream grpc.ServerStream) error {
+	var addr net.Addr
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		addr = p.Addr
+	}
+	s := newDistStream(stream)
+
+	c.mu.Lock()
+	if c.closed || len(c.free) == 0 {
+		c.mu.Unlock()
+		log.Printf("coordinator: %v: нет свободных мест\n", addr)
+		return reject(s)
+	}
+	slot := c.free[len(c.free)-1]
+	c.free = c.free[:len(c.free)-1]
+	c.mu.Unlock()
+
+	log.Printf("coordinator: обработчик %v занял место %d\n", addr, slot)
+	err := c.serve(s, slot)
+	if err != nil {
+		log.Printf("coordinator: место %d: %v\n", slot, err)
+	}
+	c.mu.Lock()
+	c.free = append(c.free, slot)
+	c.mu.Unlock()
+	return err
+}
+
+// reject сообщает обработчику, что чисел для него не будет.
+func reject(s *distStream) error {
+	if _, err := s.recv(time.Second); err != nil {
+		return err
+	}
+	if err := s.send(distMessage{Kind: distStop}); err != nil {
+		return err
+	}
+	_, err := s.recv(time.Second)
+	return err
+}
+
+// serve выдаёт аренды одному удалённому обработчику на месте slot.
+func (c *Coordinator) serve(s *distStream, slot int) error {
+	msg, err := s.recv(c.leaseTTL)
+	if err != nil {
+		return err
+	}
+	if msg.Kind != distHello {
+		return fmt.Errorf("ожидалось приветствие, получено сообщение %d", msg.Kind)
+	}
+
+	// processed — сколько результатов пришло от этого обработчика; если он
+	// отключится, не попрощавшись, его собственного счёта не будет
+	var processed int64
+	for {
+		lease, ok := c.queue.Get()
+		if !ok {
+			break
+		}
+		done, err := c.process(s, lease, slot, &processed)
+		if err != nil {
+			rest := Lease{ID: lease.ID, Items: lease.Items[done:]}
+			for i := range rest.Items {
+				rest.Items[i].Retries++
+			}
+			c.queue.Requeue(rest)
+			leasesReassigned.Add(1)
+			c.mon.Done(Heartbeat{Worker: slot, Processed: processed})
+			return fmt.Errorf("аренда %d: %w, %d чисел будут выданы заново", lease.ID, err, len(rest.Items))
+		}
+		c.queue.Done()
+	}
+
+	if err := s.send(distMessage{Kind: distStop}); err != nil {
+		c.mon.Done(Heartbeat{Worker: slot, Processed: processed})
+		return err
+	}
+	msg, err = s.recv(c.leaseTTL)
+	if err != nil {
+		c.mon.Done(Heartbeat{Worker: slot, Processed: processed})
+		return err
+	}
+	c.mon.Done(Heartbeat{Worker: slot, Processed: msg.Processed})
+	return nil
+}
+
+// process передаёт обработчику аренду lease и пересылает результаты.
+// Возвращает, сколько чисел аренды обработано: обработчик возвращает их
+// по порядку, так что при ошибке остаток — lease.Items[done:].
+func (c *Coordinator) process(s *distStream, lease Lease, slot int, processed *int64) (done int, err error) {
+	assign := distMessage{Kind: distAssign, LeaseID: lease.ID, Items: lease.Items, LeaseTTL: c.leaseTTL}
+	if err := s.send(assign); err != nil {
+		return 0, err
+	}
+	for {
+		// любое сообщение от обработчика продлевает аренду
+		msg, err := s.recv(c.leaseTTL)
+		if err != nil {
+			return done, err
+		}
+		msg.Item.Worker = slot
+		switch msg.Kind {
+		case distRenew:
+		case distResult:
+			done++
+			c.amounts[slot]++
+			*processed++
+			c.out <- msg.Item
+		case distExpired:
+			done++
+			c.expired <- msg.Item
+		case distRangeDone:
+			return done, nil
+		default:
+			return done, fmt.Errorf("неожиданное сообщение %d", msg.Kind)
+		}
+	}
+}
+
+// distStream — сеанс Work на стороне координатора. Сообщения обработчика
+// читает отдельная горутина, чтобы ожидание каждого можно было ограничить
+// сроком аренды.
+type distStream struct {
+	stream grpc.ServerStream
+	msgs   chan distMessage
+	err    error // почему закрыт msgs
+	timer  *time.Timer
+}
+
+func newDistStream(stream grpc.ServerStream) *distStream {
+	s := &distStream{
+		stream: stream,
+		msgs:   make(chan distMessage),
+		timer:  time.NewTimer(0),
+	}
+	go func() {
+		defer close(s.msgs)
+		for {
+			var msg distMessage
+			if err := stream.RecvMsg(&msg); err != nil {
+				s.err = err
+				return
+			}
+			select {
+			case s.msgs <- msg:
+			case <-stream.Context().Done():
+				s.err = stream.Context().Err()
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// recv ждёт следующее сообщение обработчика не дольше ttl.
+func (s *distStream) recv(ttl time.Duration) (distMessage, error) {
+	s.timer.Reset(ttl)
+	defer s.timer.Stop()
+	select {
+	case msg, ok := <-s.msgs:
+		if !ok {
+			return msg, s.err
+		}
+		return msg, nil
+	case <-s.timer.C:
+		return distMessage{}, fmt.Errorf("обработчик молчит дольше %v", ttl)
+	}
+}
+
+func (s *distStream) send(msg distMessage) error {
+	return s.stream.SendMsg(&msg)
+}
+
+// JoinCoordinator подключается к координатору по адресу addr и обрабатывает
+// выданные им аренды, выдерживая паузы по профилю lat, пока координатор
+// не сообщит, что чисел больше не будет. Если conf не nil, подключение
+// идёт по TLS.
+func JoinCoordinator(addr string, lat LatencyProfile, conf *tls.Config) error {
+	creds := insecure.NewCredentials()
+	if conf != nil {
+		creds = credentials.NewTLS(conf)
+	}
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(creds),
+		// аренда из многих чисел может не уложиться в ограничение по умолчанию
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(distCodec{}), grpc.MaxCallRecvMsgSize(math.MaxInt32)),
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := conn.NewStream(ctx, &distService.Streams[0], distWorkMethod)
+	if err != nil {
+		return err
+	}
+	send := func(msg distMessage) error { return stream.SendMsg(&msg) }
+	if err := send(distMessage{Kind: distHello}); err != nil {
+		return err
+	}
+
+	var processed int64
+	for {
+		var msg distMessage
+		if err := stream.RecvMsg(&msg); err != nil {
+			return err
+		}
+		switch msg.Kind {
+		case distAssign:
+			if err := processLease(msg.Items, lat, msg.LeaseTTL/distRenewParts, send, &processed); err != nil {
+				return err
+			}
+			if err := send(distMessage{Kind: distRangeDone}); err != nil {
+				return err
+			}
+		case distStop:
+			log.Printf("координатор завершил работу, обработано %d\n", processed)
+			if err := send(distMessage{Kind: distBye, Processed: processed}); err != nil {
+				return err
+			}
+			if err := stream.CloseSend(); err != nil {
+				return err
+			}
+			// вызов закончен, когда координатор принял прощание
+			if err := stream.RecvMsg(&msg); err != io.EOF {
+				return err
+			}
+			return nil
+		default:
+			return fmt.Errorf("неожиданное сообщение %d", msg.Kind)
+		}
+	}
+}
+
+// processLease обрабатывает числа аренды и отправляет результаты. Если
+// пауза после числа дольше renew, аренда продлевается каждые renew.
+func processLease(items []Item, lat LatencyProfile, renew time.Duration, send func(distMessage) error, processed *int64) error {
+	for _, it := range items {
+		kind := distResult
+		if it.Expired(time.Now()) {
+			kind = distExpired
+		}
+		if err := send(distMessage{Kind: kind, Item: it}); err != nil {
+			return err
+		}
+		if kind != distResult {
+			continue
+		}
+		*processed++
+		d := lat.Next()
+		for ; renew > 0 && d > renew; d -= renew {
+			time.Sleep(renew)
+			if err := send(distMessage{Kind: distRenew}); err != nil {
+				return err
+			}
+		}
+		time.Sleep(d)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// TestProcessLeaseRenews проверяет, что аренда продлевается во время
+// длинной паузы после числа и только тогда.
+func TestProcessLeaseRenews(t *testing.T) {
+	var kinds []distKind
+	send := func(msg distMessage) error {
+		kinds = append(kinds, msg.Kind)
+		return nil
+	}
+	lat := LatencyProfile{Delay: Duration(25 * time.Millisecond)}
+	var processed int64
+	items := []Item{{Seq: 1, Value: 1}, {Seq: 2, Value: 2}}
+	if err := processLease(items, lat, 10*time.Millisecond, send, &processed); err != nil {
+		t.Fatal(err)
+	}
+	want := []distKind{distResult, distRenew, distRenew, distResult, distRenew, distRenew}
+	if len(kinds) != len(want) {
+		t.Fatalf("сообщения %v, ожидались %v", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("сообщения %v, ожидались %v", kinds, want)
+		}
+	}
+	if processed != 2 {
+		t.Errorf("обработано %d, ожидалось 2", processed)
+	}
+}
+
+// TestCoordinatorLongPause раздаёт числа удалённому обработчику, пауза
+// которого дольше срока аренды, и проверяет, что аренда не истекает:
+// все числа приходят по одному разу и ничего не выдаётся заново.
+func TestCoordinatorLongPause(t *testing.T) {
+	const ttl = 60 * time.Millisecond
+	c, err := ListenCoordinator("127.0.0.1:0", 1, 4, ttl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan Item)
+	go func() {
+		for i := range 4 {
+			in <- Item{Seq: int64(i + 1), Value: int64(i + 1)}
+		}
+		close(in)
+	}()
+	out := NewChannelOwner[Item]("result", "координатор", 0)
+	expired := make(chan Item)
+	amounts := make([]int64, 1)
+	go c.Run(in, out, expired, amounts, NewMonitor(time.Second))
+
+	joined := make(chan error, 1)
+	go func() { joined <- JoinCoordinator(c.Addr().String(), LatencyProfile{Delay: Duration(2 * ttl)}, nil) }()
+
+	before := leasesReassigned.Value()
+	var got []int64
+	for v := range out.C {
+		got = append(got, v.Seq)
+	}
+	if err := <-joined; err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 {
+		t.Errorf("получены числа %v, ожидались 1..4 по одному разу", got)
+	}
+	if n := leasesReassigned.Value() - before; n != 0 {
+		t.Errorf("аренд выдано заново: %d", n)
+	}
+}
+
+// TestDistCodec проверяет, что сообщение переживает кодирование, а его
+// байты разбирает стандартная библиотека protobuf по схеме dist.proto.
+func TestDistCodec(t *testing.T) {
+	born := time.Unix(1700000000, 123456789)
+	msg := distMessage{
+		Kind:     distAssign,
+		LeaseID:  7,
+		LeaseTTL: 3 * time.Second,
+		Items: []Item{
+			{Seq: 1, Value: -5, Trace: 1 << 63, Retries: 2, Born: born, Deadline: born.Add(time.Second), Run: 9},
+			{Seq: 2, Value: 6, Late: true},
+		},
+	}
+	data, err := distCodec{}.Marshal(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got distMessage
+	if err := (distCodec{}).Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Kind != msg.Kind || got.LeaseID != msg.LeaseID || got.LeaseTTL != msg.LeaseTTL || len(got.Items) != 2 {
+		t.Fatalf("получено %+v, ожидалось %+v", got, msg)
+	}
+	for i, it := range got.Items {
+		want := msg.Items[i]
+		if !it.Born.Equal(want.Born) || !it.Deadline.Equal(want.Deadline) {
+			t.Errorf("число %d: время %v/%v, ожидалось %v/%v", i, it.Born, it.Deadline, want.Born, want.Deadline)
+		}
+		it.Born, it.Deadline, want.Born, want.Deadline = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+		if it != want {
+			t.Errorf("число %d: %+v, ожидалось %+v", i, it, want)
+		}
+	}
+
+	dyn := dynamicpb.NewMessage(distDescriptor(t).Messages().ByName("Message"))
+	if err := proto.Unmarshal(data, dyn); err != nil {
+		t.Fatal(err)
+	}
+	fields := dyn.Descriptor().Fields()
+	if k := dyn.Get(fields.ByName("kind")).Enum(); k != 1 {
+		t.Errorf("kind = %d, ожидался ASSIGN", k)
+	}
+	if ttl := dyn.Get(fields.ByName("lease_ttl_nanos")).Int(); ttl != int64(3*time.Second) {
+		t.Errorf("lease_ttl_nanos = %d", ttl)
+	}
+	items := dyn.Get(fields.ByName("items")).List()
+	if items.Len() != 2 {
+		t.Fatalf("items: %d, ожидалось 2", items.Len())
+	}
+	first := items.Get(0).Message()
+	itemFields := first.Descriptor().Fields()
+	if v := first.Get(itemFields.ByName("value")).Int(); v != -5 {
+		t.Errorf("value = %d, ожидалось -5", v)
+	}
+	if tr := first.Get(itemFields.ByName("trace")).Uint(); tr != 1<<63 {
+		t.Errorf("trace = %d", tr)
+	}
+	if b := first.Get(itemFields.ByName("born_unix_nano")).Int(); b != born.UnixNano() {
+		t.Errorf("born_unix_nano = %d, ожидалось %d", b, born.UnixNano())
+	}
+}
+
+// distDescriptor строит описание схемы dist.proto.
+func distDescriptor(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+		label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		if repeated {
+			label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		}
+		f := &descriptorpb.FieldDescriptorProto{Name: &name, Number: &num, Type: typ.Enum(), Label: label.Enum()}
+		if typeName != "" {
+			f.TypeName = &typeName
+		}
+		return f
+	}
+	const (
+		i64 = descriptorpb.FieldDescriptorProto_TYPE_INT64
+		u64 = descriptorpb.FieldDescriptorProto_TYPE_UINT64
+	)
+	var kinds []*descriptorpb.EnumValueDescriptorProto
+	for i, name := range []string{"HELLO", "ASSIGN", "RESULT", "EXPIRED", "RENEW", "RANGE_DONE", "STOP", "BYE"} {
+		kinds = append(kinds, &descriptorpb.EnumValueDescriptorProto{Name: proto.String(name), Number: proto.Int32(int32(i))})
+	}
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:     proto.String("dist.proto"),
+		Package:  proto.String("sprint9"),
+		Syntax:   proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{Name: proto.String("Kind"), Value: kinds}},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Item"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("seq", 1, i64, "", false),
+				field("value", 2, i64, "", false),
+				field("trace", 3, u64, "", false),
+				field("worker", 4, i64, "", false),
+				field("late", 5, descriptorpb.FieldDescriptorProto_TYPE_BOOL, "", false),
+				field("retries", 6, i64, "", false),
+				field("born_unix_nano", 7, i64, "", false),
+				field("deadline_unix_nano", 8, i64, "", false),
+				field("run", 9, i64, "", false),
+			},
+		}, {
+			Name: proto.String("Message"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("kind", 1, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".sprint9.Kind", false),
+				field("lease_id", 2, i64, "", false),
+				field("items", 3, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".sprint9.Item", true),
+				field("lease_ttl_nanos", 4, i64, "", false),
+				field("item", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".sprint9.Item", false),
+				field("processed", 6, i64, "", false),
+			},
+		}},
+	}
+	file, err := protodesc.NewFile(fd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Plan проверяет настройки cfg так, как их проверил бы запуск: загружает
+// ключи и сертификаты, пробует занять адреса серверов и проверяет адреса
+// получателей, но ничего не создаёт и ни к кому не подключается. Устройство
+// конвейера выводится в w. Возвращаются все найденные ошибки сразу.
+func Plan(w io.Writer, cfg Config) error {
+	var errs []error
+	check := func(what string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", what, err))
+		}
+	}
+	tls := cfg.TLS()
+	_, err := tls.ServerConfig()
+	check("сертификаты TLS", err)
+	if tls.Enabled() {
+		fmt.Fprintln(w, "TLS: да")
+	}
+
+	if cfg.Join != "" {
+		_, err := tls.ClientConfig(cfg.Join)
+		check("сертификаты TLS", err)
+		fmt.Fprintln(w, "Режим: удалённый обработчик координатора", cfg.Join)
+		fmt.Fprintln(w, "Задержка обработки:", planLatency(LatencyFor(cfg.Latency, 0)))
+		return errors.Join(errs...)
+	}
+	if cfg.Elect != "" {
+		check("выборы координатора", planDir(cfg.Elect))
+		fmt.Fprintln(w, "Режим: выборы координатора через", cfg.Elect)
+	}
+
+	switch {
+	case cfg.Stdin:
+		fmt.Fprintln(w, "Источник: stdin, профиль нагрузки", cfg.Schedule, "скорость воспроизведения", cfg.Speed)
+	default:
+		fmt.Fprintln(w, "Источник: генератор, профиль нагрузки", cfg.Schedule)
+	}
+	var limits []string
+	if cfg.Duration > 0 && !cfg.Stdin {
+		limits = append(limits, "время "+time.Duration(cfg.Duration).String())
+	}
+	if cfg.MaxItems > 0 {
+		limits = append(limits, fmt.Sprint("чисел ", cfg.MaxItems))
+	}
+	if cfg.MaxSum > 0 {
+		limits = append(limits, fmt.Sprint("сумма ", cfg.MaxSum))
+	}
+	if cfg.MaxBytes > 0 {
+		limits = append(limits, fmt.Sprint("байт ", cfg.MaxBytes))
+	}
+	if len(limits) > 0 {
+		fmt.Fprintln(w, "Пределы:", strings.Join(limits, ", "))
+	}
+	if cfg.TTL > 0 {
+		fmt.Fprintln(w, "Срок годности чисел:", time.Duration(cfg.TTL))
+	}
+	aead, err := LoadKey(cfg.KeyFile, os.Getenv(EnvKey))
+	check("ключ шифрования", err)
+	if aead != nil {
+		fmt.Fprintln(w, "Шифрование: AES-GCM")
+	}
+
+	switch {
+	case cfg.Coordinator != "":
+		check("координатор", planListen(cfg.Coordinator))
+		fmt.Fprintf(w, "Раздача: координатор на %s, %d удалённых обработчиков, аренды по %d чисел на %v\n",
+			cfg.Coordinator, cfg.Workers, cfg.LeaseSize, time.Duration(cfg.LeaseTTL))
+	case cfg.Keys > 0:
+		fmt.Fprintf(w, "Раздача: по ключу value %% %d, доли %v\n", cfg.Keys, planShares(KeyShares(cfg.Keys, cfg.Weights, cfg.Workers)))
+	case len(cfg.Weights) > 0:
+		fmt.Fprintln(w, "Раздача: по весам, доли", planShares(WeightedShares(cfg.Weights, cfg.Workers)))
+	case cfg.Fair > 0:
+		fmt.Fprintln(w, "Раздача: кругами, не больше", cfg.Fair, "чисел обработчику за круг")
+	default:
+		fmt.Fprintln(w, "Раздача: общий канал, ёмкость", cfg.Buffer)
+	}
+	if cfg.Coordinator == "" {
+		for i := range cfg.Workers {
+			fmt.Fprintf(w, "  обработчик %d: %s\n", i, planLatency(LatencyFor(cfg.Latency, i)))
+		}
+	}
+	if cfg.Ordered {
+		fmt.Fprintln(w, "Порядок: восстанавливается, буфер", cfg.ReorderBuffer, "на обработчик")
+	}
+
+	fmt.Fprintln(w, "Получатели:")
+	sinks := 0
+	sink := func(format string, args ...any) {
+		sinks++
+		fmt.Fprintf(w, "  "+format+"\n", args...)
+	}
+	if cfg.Stdin {
+		sink("stdout, сжатие %q, время рождения %v", cfg.Compress, cfg.Timed)
+	}
+	if cfg.Listen != "" {
+		check("TCP-сервер", planListen(cfg.Listen))
+		sink("TCP-клиенты на %s, буфер %d, сжатие %q", cfg.Listen, cfg.ListenBuffer, cfg.Compress)
+	}
+	if cfg.Socket != "" {
+		check("сокет", planSocket(cfg.Socket))
+		sink("Unix-сокет %s, сжатие %q", cfg.Socket, cfg.Compress)
+	}
+	if cfg.Parquet != "" {
+		check("файл Parquet", planDir(cfg.Parquet))
+		sink("файл Parquet %s", cfg.Parquet)
+	}
+	if cfg.Arrow != "" {
+		if sock, ok := strings.CutPrefix(cfg.Arrow, "unix:"); ok {
+			check("поток Arrow", planSocket(sock))
+		} else {
+			check("поток Arrow", planDir(cfg.Arrow))
+		}
+		sink("поток Arrow IPC в %s", cfg.Arrow)
+	}
+	if cfg.Postgres != "" {
+		u, err := url.Parse(cfg.Postgres)
+		if err == nil && u.Scheme != "postgres" && u.Scheme != "postgresql" {
+			err = errors.New("адрес должен начинаться с postgres://")
+		}
+		check("PostgreSQL", err)
+		if err == nil {
+			u.User = url.User(u.User.Username()) // пароль не выводим
+			sink("PostgreSQL %s, таблица %s, пакеты по %d строк", u, cfg.PostgresTable, cfg.PostgresBatch)
+		}
+	}
+	if cfg.S3 != "" {
+		bucket, _, err := parseS3Dest(cfg.S3)
+		check("S3", err)
+		if err == nil {
+			_, err = NewS3Client(cfg.S3Endpoint, bucket, cfg.S3Region)
+			check("S3", err)
+		}
+		sink("S3 %s на %s, сегменты по %d байт", cfg.S3, cfg.S3Endpoint, cfg.S3Segment)
+	}
+	if cfg.Webhook != "" {
+		check("webhook", planURL(cfg.Webhook))
+		sink("webhook %s, пакеты по %d, ожидание %v", cfg.Webhook, cfg.WebhookBatch, time.Duration(cfg.WebhookTimeout))
+	}
+	if sinks == 0 {
+		fmt.Fprintln(w, "  нет, только отчёт")
+	}
+	if cfg.BreakerRate > 0 {
+		fmt.Fprintf(w, "Цепи: размыкаются при доле ошибок %g из последних %d обращений, пробуют снова через %v\n",
+			cfg.BreakerRate, cfg.BreakerWindow, time.Duration(cfg.BreakerCooldown))
+		if cfg.DeadLetter != "" {
+			check("файл dead-letter", planDir(cfg.DeadLetter))
+			fmt.Fprintln(w, "Недоставленные числа: в файл", cfg.DeadLetter)
+		}
+	}
+	if cfg.BatchLatency > 0 && (cfg.Postgres != "" || cfg.Webhook != "") {
+		fmt.Fprintln(w, "Размер пакетов подбирается под задержку", time.Duration(cfg.BatchLatency))
+	}
+
+	if cfg.Metrics != "" {
+		check("сервер метрик", planListen(cfg.Metrics))
+		token, err := loadSecret(cfg.TokenFile, os.Getenv(EnvToken))
+		check("токен", err)
+		auth := "без токена"
+		if token != "" {
+			auth = "по токену"
+		}
+		fmt.Fprintf(w, "Метрики: %s, доступ %s\n", cfg.Metrics, auth)
+	}
+	if cfg.UDP != "" {
+		_, err := net.ResolveUDPAddr("udp", cfg.UDP)
+		check("сводки по UDP", err)
+		fmt.Fprintf(w, "Сводки: UDP %s, формат %s\n", cfg.UDP, cfg.UDPFormat)
+	}
+	if cfg.Alert != "" {
+		for name := range strings.SplitSeq(cfg.Alert, ",") {
+			if name = strings.TrimSpace(name); strings.Contains(name, "://") {
+				check("alert", planURL(name))
+			}
+		}
+		fmt.Fprintln(w, "Нарушения правил:", cfg.Alert)
+	}
+	if cfg.Soak {
+		fmt.Fprintf(w, "Прогон на выносливость: проверки каждые %v, рост кучи до %gx, горутин до +%d\n",
+			time.Duration(cfg.SoakInterval), cfg.SoakMemory, cfg.SoakGoroutines)
+	}
+	if cfg.Console {
+		fmt.Fprintln(w, "Консоль: команды из stdin")
+	}
+	if cfg.Watchdog > 0 {
+		fmt.Fprintln(w, "Сторож:", time.Duration(cfg.Watchdog))
+	}
+	if cfg.Label != "" {
+		fmt.Fprintln(w, "Метка запуска:", cfg.Label)
+	}
+	// именованные конвейеры проверяются так же, а их устройство выводится
+	// с отступом
+	for _, name := range slices.Sorted(maps.Keys(cfg.Pipelines)) {
+		var plan bytes.Buffer
+		check("конвейер "+name, Plan(&plan, cfg.Pipelines[name]))
+		fmt.Fprintf(w, "Конвейер %s:\n", name)
+		for line := range strings.Lines(plan.String()) {
+			fmt.Fprint(w, "  ", line)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// planLatency описывает задержку обработчика.
+func planLatency(p LatencyProfile) string {
+	if p.Jitter == 0 {
+		return fmt.Sprintf("задержка %v", time.Duration(p.Delay))
+	}
+	return fmt.Sprintf("задержка %v + до %v", time.Duration(p.Delay), time.Duration(p.Jitter))
+}
+
+// planShares округляет доли обработчиков до процентов.
+func planShares(shares []float64) []string {
+	res := make([]string, len(shares))
+	for i, s := range shares {
+		res[i] = fmt.Sprintf("%.1f%%", 100*s)
+	}
+	return res
+}
+
+// planListen проверяет, что адрес addr можно занять, и сразу освобождает его.
+func planListen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return l.Close()
+}
+
+// planSocket проверяет, что по пути path есть Unix-сокет.
+func planSocket(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s не сокет", path)
+	}
+	return nil
+}
+
+// planDir проверяет, что каталог, в котором будет создан файл path, есть.
+func planDir(path string) error {
+	info, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s не каталог", filepath.Dir(path))
+	}
+	return nil
+}
+
+// planURL проверяет, что raw — адрес http:// или https://.
+func planURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains([]string{"http", "https"}, u.Scheme) || u.Host == "" {
+		return fmt.Errorf("адрес %q должен начинаться с http:// или https://", raw)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime/pprof"
+	"time"
+)
+
+// DumpStats пишет в w снимок состояния конвейера: счётчики, сигналы
+// обработчиков, заполненность каналов и стеки всех горутин. Конвейер
+// при этом продолжает работать.
+func DumpStats(w io.Writer, mon *Monitor, depths []*ChannelDepth) {
+	fmt.Fprintf(w, "=== снимок состояния %s ===\n", time.Now().Format(time.RFC3339Nano))
+	fmt.Fprintln(w, "счётчики:", metrics.String())
+
+	fmt.Fprintln(w, "обработчики:")
+	for _, hb := range mon.Snapshot() {
+		fmt.Fprintf(w, "  %d: обработано %d, последнее число %s, обработка %v, ожидание отправки %v\n",
+			hb.Worker, hb.Processed, hb.LastItem.Format("15:04:05.000"),
+			hb.Busy.Round(time.Millisecond), hb.Blocked.Round(time.Millisecond))
+	}
+
+	fmt.Fprintln(w, "каналы:")
+	for _, d := range depths {
+		fmt.Fprintf(w, "  %s → %s: %d из %d\n", d.Name, d.Consumer, d.len(), d.cap)
+	}
+
+	fmt.Fprintln(w, "горутины:")
+	pprof.Lookup("goroutine").WriteTo(w, 2)
+	fmt.Fprintln(w, "=== конец снимка ===")
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "context"
+
+// notifyDump ничего не делает: на этой платформе нет SIGUSR1.
+func notifyDump(ctx context.Context, dump func()) {}
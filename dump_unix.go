@@ -0,0 +1,27 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyDump вызывает dump при каждом сигнале SIGUSR1, пока не отменён
+// контекст ctx.
+func notifyDump(ctx context.Context, dump func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	defer signal.Stop(ch)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+			dump()
+		}
+	}
+}
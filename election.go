@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// FileLock — эксклюзивная блокировка файла. Кто первым заблокировал файл,
+// тот становится координатором и записывает в файл свой адрес; остальные
+// процессы читают адрес и подключаются к координатору обработчиками.
+// Блокировка снимается, когда процесс завершается, даже аварийно, и тогда
+// координатором становится следующий.
+type FileLock struct {
+	f *os.File
+}
+
+// TryLock пытается заблокировать файл path, не дожидаясь блокировки.
+// Возвращает nil без ошибки, если файл уже заблокирован другим процессом.
+func TryLock(path string) (*FileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := tryLockFile(f)
+	if err != nil || !ok {
+		f.Close()
+		return nil, err
+	}
+	// адрес прежнего координатора больше не действителен
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FileLock{f: f}, nil
+}
+
+// Advertise записывает в заблокированный файл адрес координатора.
+func (l *FileLock) Advertise(addr string) error {
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.f.WriteAt([]byte(addr+"\n"), 0); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+// advertiseAddr возвращает адрес, который выбранный координатор сообщает
+// обработчикам: -advertise, а без него хост из -coordinator с портом, на
+// котором координатор на самом деле слушает (в -coordinator он может быть 0).
+func advertiseAddr(cfg Config, bound net.Addr) string {
+	if cfg.Advertise != "" {
+		return cfg.Advertise
+	}
+	host, _, _ := net.SplitHostPort(cfg.Coordinator)
+	_, port, _ := net.SplitHostPort(bound.String())
+	return net.JoinHostPort(host, port)
+}
+
+// Unlock стирает адрес и снимает блокировку.
+func (l *FileLock) Unlock() error {
+	l.f.Truncate(0)
+	return l.f.Close()
+}
+
+// ReadLeader ждёт, пока координатор запишет свой адрес в файл path,
+// но не дольше timeout.
+func ReadLeader(path string, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		if addr := strings.TrimSpace(string(data)); addr != "" {
+			return addr, nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("%s: координатор не сообщил адрес за %v", path, timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
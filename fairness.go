@@ -0,0 +1,76 @@
+package main
+
+import "math"
+
+const (
+	fairnessZ         = 3.09 // квантиль нормального распределения для p = 0.001
+	fairnessTolerance = 0.1  // допустимое отклонение от ожидаемой доли
+	fairnessMinCell   = 5    // меньше этого ожидаемых чисел на обработчик — не проверяем
+)
+
+// Fairness — насколько фактическая разбивка по обработчикам отличается
+// от ожидаемой.
+type Fairness struct {
+	ChiSquared float64 // статистика хи-квадрат
+	Critical   float64 // её критическое значение для p = 0.001
+	Worst      int     // обработчик с наибольшим отклонением
+	Deviation  float64 // его отклонение от ожидаемого, в долях; +Inf, если ему не положено ни одного числа
+}
+
+// Unfair сообщает, что разбивка неравномерна и статистически, и на деле:
+// на десятках тысяч чисел хи-квадрат замечает даже ничтожные отклонения,
+// поэтому дополнительно требуется отклонение больше fairnessTolerance.
+func (f Fairness) Unfair() bool {
+	return f.ChiSquared > f.Critical && math.Abs(f.Deviation) > fairnessTolerance
+}
+
+// CheckFairness сравнивает разбивку amounts с ожидаемыми долями shares.
+// Если чисел слишком мало для проверки, возвращает false. Обработчик,
+// которому не положено ни одного числа, но который их получил, делает
+// разбивку неравномерной при любом количестве чисел.
+func CheckFairness(amounts []int64, shares []float64) (Fairness, bool) {
+	var total int64
+	cells := 0 // сколько обработчиков должны получать числа
+	for i, n := range amounts {
+		total += n
+		if shares[i] > 0 {
+			cells++
+		}
+	}
+	df := float64(max(cells-1, 1))
+	for i, n := range amounts {
+		if shares[i] == 0 && n > 0 {
+			// обработчику не положено ни одного числа, например без
+			// единого ключа на кольце, а он их получил
+			return Fairness{ChiSquared: math.Inf(1), Critical: chiCritical(df), Worst: i, Deviation: math.Inf(1)}, true
+		}
+	}
+
+	var f Fairness
+	for i, n := range amounts {
+		if shares[i] == 0 {
+			continue
+		}
+		expected := shares[i] * float64(total)
+		if expected < fairnessMinCell {
+			return Fairness{}, false
+		}
+		diff := float64(n) - expected
+		f.ChiSquared += diff * diff / expected
+		if dev := diff / expected; math.Abs(dev) > math.Abs(f.Deviation) {
+			f.Worst, f.Deviation = i, dev
+		}
+	}
+	if cells < 2 {
+		return Fairness{}, false
+	}
+	f.Critical = chiCritical(df)
+	return f, true
+}
+
+// chiCritical возвращает критическое значение хи-квадрат с df степенями
+// свободы для p = 0.001 по приближению Уилсона — Хилферти.
+func chiCritical(df float64) float64 {
+	k := 2 / (9 * df)
+	return df * math.Pow(1-k+fairnessZ*math.Sqrt(k), 3)
+}
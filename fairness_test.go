@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// TestCheckFairness проверяет равномерную и перекошенную разбивки, а также
+// обработчика, получившего числа без положенной ему доли.
+func TestCheckFairness(t *testing.T) {
+	even := []float64{0.25, 0.25, 0.25, 0.25}
+	tests := []struct {
+		name    string
+		amounts []int64
+		shares  []float64
+		unfair  bool
+		worst   int
+	}{
+		{"равномерно", []int64{1000, 1010, 990, 1000}, even, false, 0},
+		{"перекос", []int64{1000, 1000, 1000, 500}, even, true, 3},
+		{"без доли", []int64{500, 500, 3}, []float64{0.5, 0.5, 0}, true, 2},
+		{"единственный с долей", []int64{1000, 1}, []float64{1, 0}, true, 1},
+	}
+	for _, tt := range tests {
+		f, ok := CheckFairness(tt.amounts, tt.shares)
+		if !ok {
+			t.Errorf("%s: разбивка %v не проверена", tt.name, tt.amounts)
+			continue
+		}
+		if f.Unfair() != tt.unfair || (tt.unfair && f.Worst != tt.worst) {
+			t.Errorf("%s: неравномерна %v, худший %d; ожидалось %v и %d", tt.name, f.Unfair(), f.Worst, tt.unfair, tt.worst)
+		}
+	}
+
+	if _, ok := CheckFairness([]int64{2, 1}, []float64{0.5, 0.5}); ok {
+		t.Error("разбивка из трёх чисел проверена")
+	}
+	if f, ok := CheckFairness([]int64{1000, 0}, []float64{1, 0}); ok {
+		t.Errorf("разбивка с одним обработчиком с долей проверена: %+v", f)
+	}
+	if f, _ := CheckFairness([]int64{10, 10, 1}, []float64{0.5, 0.5, 0}); !math.IsInf(f.Deviation, 1) {
+		t.Errorf("отклонение обработчика без доли %v, ожидалась бесконечность", f.Deviation)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+)
+
+var (
+	feedClients = newCounter("feed_clients")      // сколько клиентов подключено
+	feedDropped = newCounter("feed_clients_slow") // сколько клиентов отключено за медлительность
+)
+
+// FeedServer раздаёт результаты всем подключённым по TCP клиентам,
+// по одному числу в строке. У каждого клиента свой буфер: если клиент
+// не успевает читать и буфер переполнился, он отключается, а конвейер
+// не ждёт его. Если задан ключ, каждому клиенту идёт отдельный
+// зашифрованный поток. Последний кадр потока пишет только Close, поэтому
+// отключённый клиент видит оборванный поток, а не законченный.
+type FeedServer struct {
+	ln       net.Listener
+	buffer   int
+	aead     cipher.AEAD
+	compress string
+
+	mu      sync.Mutex
+	clients map[*feedClient]struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+type feedClient struct {
+	conn    net.Conn
+	ch      chan int64
+	dropped bool // клиент отключён до конца рассылки; пишется до закрытия ch
+}
+
+// ListenFeed начинает принимать клиентов по адресу addr. У каждого
+// клиента будет буфер на buffer чисел. Если aead не nil, клиентам
+// отправляется зашифрованный им поток, а если conf не nil — клиенты
+// подключаются по TLS. Поток каждого клиента сжимается по compress.
+func ListenFeed(addr string, buffer int, aead cipher.AEAD, conf *tls.Config, compress string) (*FeedServer, error) {
+	ln, err := listenTCP(addr, conf)
+	if err != nil {
+		return nil, err
+	}
+	s := &FeedServer{
+		ln:       ln,
+		buffer:   buffer,
+		aead:     aead,
+		compress: compress,
+		clients:  make(map[*feedClient]struct{}),
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// Addr возвращает адрес, на котором сервер принимает клиентов.
+func (s *FeedServer) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+func (s *FeedServer) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("feed: %v\n", err)
+			continue
+		}
+
+		c := &feedClient{conn: conn, ch: make(chan int64, s.buffer)}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.clients[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		feedClients.Add(1)
+		go s.serve(c)
+	}
+}
+
+// serve пишет клиенту числа из его буфера, пока буфер не закроют
+// или клиент не отключится.
+func (s *FeedServer) serve(c *feedClient) {
+	defer s.wg.Done()
+	defer feedClients.Add(-1)
+	defer c.conn.Close()
+
+	z := newCompressor(NewSealWriter(c.conn, s.aead), s.compress)
+	w := bufio.NewWriter(z)
+	for v := range c.ch {
+		n, _ := w.WriteString(strconv.FormatInt(v, 10))
+		w.WriteByte('\n')
+		sinkBytes.Add(int64(n + 1))
+		// сбрасываем буфер, только когда новых чисел пока нет
+		if len(c.ch) > 0 {
+			continue
+		}
+		err := w.Flush()
+		if err == nil {
+			err = z.Flush()
+		}
+		if err != nil {
+			// соединение отключённого за медлительность клиента уже закрыто
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("feed: клиент %v отключился: %v\n", c.conn.RemoteAddr(), err)
+			}
+			s.remove(c)
+			return
+		}
+	}
+	if !c.dropped && w.Flush() == nil {
+		z.Close()
+	}
+}
+
+// remove убирает клиента из рассылки, не дописывая ему поток.
+func (s *FeedServer) remove(c *feedClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[c]; ok {
+		s.drop(c)
+	}
+}
+
+// drop отключает клиента посреди рассылки: закрывает его буфер и
+// соединение, так что serve не допишет последний кадр. Вызывается под s.mu.
+func (s *FeedServer) drop(c *feedClient) {
+	delete(s.clients, c)
+	c.dropped = true
+	close(c.ch)
+	c.conn.Close()
+}
+
+// Put отправляет значение числа it всем клиентам.
+func (s *FeedServer) Put(it Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		select {
+		case c.ch <- it.Value:
+		default:
+			log.Printf("feed: клиент %v не успевает читать, отключаем\n", c.conn.RemoteAddr())
+			feedDropped.Add(1)
+			s.drop(c)
+		}
+	}
+	return nil
+}
+
+// Close перестаёт принимать клиентов, дописывает им оставшиеся числа
+// и закрывает соединения.
+func (s *FeedServer) Close() error {
+	err := s.ln.Close()
+
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.clients {
+		delete(s.clients, c)
+		close(c.ch)
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
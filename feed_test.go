@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialFeed подключается к s и ждёт, пока сервер начнёт рассылать клиенту.
+func dialFeed(t *testing.T, s *FeedServer) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		s.mu.Lock()
+		n := len(s.clients)
+		s.mu.Unlock()
+		if n > 0 {
+			return conn
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("сервер не принял клиента")
+		}
+	}
+}
+
+// TestFeedStream проверяет, что клиент, дождавшийся Close, получает
+// законченный зашифрованный поток, а клиент, отключённый за
+// медлительность, — оборванный, который читатель не примет за полный.
+func TestFeedStream(t *testing.T) {
+	aead := testAEAD(t)
+
+	t.Run("законченный", func(t *testing.T) {
+		s, err := ListenFeed("127.0.0.1:0", 100, aead, nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := dialFeed(t, s)
+		read := make(chan string, 1)
+		go func() {
+			data, _ := io.ReadAll(conn)
+			read <- string(data)
+		}()
+		for i := range 100 {
+			s.Put(Item{Value: int64(i)})
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		in, err := OpenStream(strings.NewReader(<-read), aead)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(in)
+		if err != nil {
+			t.Fatalf("законченный поток: %v", err)
+		}
+		if lines := strings.Fields(string(data)); len(lines) != 100 {
+			t.Errorf("получено %d чисел, ожидалось 100", len(lines))
+		}
+	})
+
+	t.Run("медленный клиент", func(t *testing.T) {
+		s, err := ListenFeed("127.0.0.1:0", 1, aead, nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		// клиент читает начало потока, а дальше не читает, пока его
+		// не отключат
+		conn := dialFeed(t, s)
+		s.Put(Item{Value: 1})
+		head := make([]byte, len(sealMagic))
+		if _, err := io.ReadFull(conn, head); err != nil {
+			t.Fatal(err)
+		}
+		before := feedDropped.Value()
+		for i := 0; feedDropped.Value() == before; i++ {
+			if i == 10_000_000 {
+				t.Fatal("медленного клиента так и не отключили")
+			}
+			s.Put(Item{Value: int64(i)})
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		in, err := OpenStream(io.MultiReader(bytes.NewReader(head), conn), aead)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, in); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("поток отключённого клиента: ошибка %v, ожидался обрыв", err)
+		}
+	})
+}
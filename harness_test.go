@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// harnessEnv — переменная окружения, с которой тестовый бинарник вместо
+// тестов запускает конвейер с аргументами из командной строки.
+const harnessEnv = "PIPELINE_HARNESS"
+
+// harnessTimeout — сколько ждать прогон, прежде чем считать, что он завис.
+// Внутренний -watchdog срабатывает раньше и сам называет место зависания;
+// этот предел ловит зависания до того, как источник остановился.
+const harnessTimeout = 30 * time.Second
+
+func TestMain(m *testing.M) {
+	if os.Getenv(harnessEnv) != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// TestPipelineHangs прогоняет конвейер во всех способах раздачи, с общим
+// порядком и без него, с разными получателями и проверяет, что каждый
+// прогон завершается. Зависший прогон получает SIGQUIT, и в ошибку теста
+// попадают стеки всех горутин.
+func TestPipelineHangs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("прогоны конвейера долгие")
+	}
+	dispatch := []struct {
+		name   string
+		config string // JSON для -config
+		args   []string
+	}{
+		{"общий канал", "", nil},
+		{"по весам", `{"weights": {"0": 3, "1": 1}}`, nil},
+		{"по ключам", "", []string{"-keys", "16"}},
+		{"кругами", "", []string{"-fair", "2"}},
+		{"с задержкой", `{"latency": {"0": {"delay": "1ms", "jitter": "1ms"}}}`, nil},
+	}
+	sinks := []struct {
+		name  string
+		args  func(dir string) []string
+		stdin bool
+	}{
+		{"без получателей", func(string) []string { return []string{"-max-items", "1000"} }, false},
+		{"stdin", func(string) []string { return []string{"-stdin"} }, true},
+		{"parquet", func(dir string) []string {
+			return []string{"-max-items", "1000", "-parquet", filepath.Join(dir, "results.parquet")}
+		}, false},
+		{"arrow", func(dir string) []string {
+			return []string{"-max-items", "1000", "-arrow", filepath.Join(dir, "results.arrow")}
+		}, false},
+	}
+
+	for _, d := range dispatch {
+		for _, ordered := range []bool{false, true} {
+			for _, s := range sinks {
+				name := fmt.Sprintf("%s/ordered=%v/%s", d.name, ordered, s.name)
+				t.Run(name, func(t *testing.T) {
+					t.Parallel()
+					dir := t.TempDir()
+					args := []string{"-duration", "0", "-watchdog", "5s"}
+					if d.config != "" {
+						path := filepath.Join(dir, "config.json")
+						if err := os.WriteFile(path, []byte(d.config), 0o644); err != nil {
+							t.Fatal(err)
+						}
+						args = append(args, "-config", path)
+					}
+					args = append(args, d.args...)
+					args = append(args, s.args(dir)...)
+					if ordered {
+						args = append(args, "-ordered")
+					}
+					var stdin string
+					if s.stdin {
+						stdin = harnessInput(1000)
+					}
+					runHarness(t, args, stdin)
+				})
+			}
+		}
+	}
+}
+
+// harness — запущенный прогон конвейера.
+type harness struct {
+	cmd            *exec.Cmd
+	args           []string
+	ctx            context.Context
+	cancel         context.CancelFunc
+	stdout, stderr bytes.Buffer
+}
+
+// startHarness запускает конвейер с аргументами args и входом stdin.
+func startHarness(t *testing.T, args []string, stdin string) *harness {
+	t.Helper()
+	h := &harness{args: args}
+	h.ctx, h.cancel = context.WithTimeout(context.Background(), harnessTimeout)
+	h.cmd = exec.CommandContext(h.ctx, os.Args[0], args...)
+	h.cmd.Env = append(os.Environ(), harnessEnv+"=1")
+	h.cmd.Stdin = strings.NewReader(stdin)
+	// SIGQUIT заставляет среду выполнения Go напечатать стеки всех горутин
+	h.cmd.Cancel = func() error { return h.cmd.Process.Signal(syscall.SIGQUIT) }
+	h.cmd.WaitDelay = 5 * time.Second
+	h.cmd.Stdout, h.cmd.Stderr = &h.stdout, &h.stderr
+	if err := h.cmd.Start(); err != nil {
+		h.cancel()
+		t.Fatal(err)
+	}
+	return h
+}
+
+// wait ждёт прогон не дольше harnessTimeout с его запуска и возвращает
+// то, что он записал в stdout.
+func (h *harness) wait(t *testing.T) []byte {
+	t.Helper()
+	defer h.cancel()
+	err := h.cmd.Wait()
+	if h.ctx.Err() != nil {
+		t.Fatalf("конвейер %v завис дольше %v:\n%s", h.args, harnessTimeout, h.stderr.String())
+	}
+	if err != nil {
+		t.Fatalf("конвейер %v: %v\n%s", h.args, err, h.stderr.String())
+	}
+	return h.stdout.Bytes()
+}
+
+// runHarness запускает конвейер с аргументами args и входом stdin,
+// ждёт его не дольше harnessTimeout и возвращает его stdout.
+func runHarness(t *testing.T, args []string, stdin string) []byte {
+	t.Helper()
+	return startHarness(t, args, stdin).wait(t)
+}
+
+// harnessInput возвращает n чисел по одному в строке для -stdin.
+func harnessInput(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintln(&b, i)
+	}
+	return b.String()
+}
+
+// harnessBase — аргументы, с которыми запускается каждый прогон.
+var harnessBase = []string{"-duration", "0", "-watchdog", "5s"}
+
+// harnessKey — ключ AES-128 для прогонов с шифрованием.
+const harnessKey = "00112233445566778899aabbccddeeff"
+
+// TestPipelineModes прогоняет конвейер в остальных режимах и с сетевыми
+// получателями и проверяет, что каждый прогон завершается, а результаты
+// доходят до получателей.
+func TestPipelineModes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("прогоны конвейера долгие")
+	}
+	const n = 1000
+	total := fmt.Sprintf("Количество чисел %d %d", n, n)
+
+	t.Run("координатор", func(t *testing.T) {
+		t.Parallel()
+		addr := freeAddr(t)
+		coord := startHarness(t, append(slices.Clone(harnessBase),
+			"-max-items", strconv.Itoa(n), "-workers", "2", "-coordinator", addr), "")
+		waitListening(t, addr)
+		join := startHarness(t, []string{"-join", addr}, "")
+		join.wait(t)
+		if out := coord.wait(t); !bytes.Contains(out, []byte(total)) {
+			t.Errorf("в отчёте координатора нет %q:\n%s", total, out)
+		}
+	})
+
+	t.Run("консоль", func(t *testing.T) {
+		t.Parallel()
+		out := runHarness(t, append(slices.Clone(harnessBase), "-max-items", strconv.Itoa(n), "-console"),
+			"pause\nresume\nscale 3\nstats\n")
+		if !bytes.Contains(out, []byte(total)) {
+			t.Errorf("в отчёте нет %q:\n%s", total, out)
+		}
+	})
+
+	t.Run("именованные конвейеры", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "config.json")
+		config := `{"pipelines": {"a": {"max_items": 500}, "b": {"max_items": 300, "workers": 2, "ordered": true}}}`
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		out := runHarness(t, append(slices.Clone(harnessBase), "-max-items", strconv.Itoa(n), "-config", path), "")
+		for _, want := range []string{"Конвейер a: done", "обработано 500,", "Конвейер b: done", "обработано 300,"} {
+			if !bytes.Contains(out, []byte(want)) {
+				t.Errorf("в отчёте нет %q:\n%s", want, out)
+			}
+		}
+	})
+
+	t.Run("TCP-раздача", func(t *testing.T) {
+		t.Parallel()
+		runHarness(t, append(slices.Clone(harnessBase),
+			"-max-items", strconv.Itoa(n), "-listen", "127.0.0.1:0", "-compress", "zstd"), "")
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		t.Parallel()
+		pg := newFakePg(t)
+		runHarness(t, append(slices.Clone(harnessBase), "-max-items", strconv.Itoa(n), "-postgres", pg.dsn()), "")
+		pg.mu.Lock()
+		defer pg.mu.Unlock()
+		if len(pg.rows) != n {
+			t.Errorf("в PostgreSQL записано %d строк, ожидалось %d", len(pg.rows), n)
+		}
+	})
+
+	t.Run("postgres с ошибкой", func(t *testing.T) {
+		t.Parallel()
+		pg := newFakePg(t)
+		pg.failCopy = 2
+		out := runHarness(t, append(slices.Clone(harnessBase),
+			"-max-items", strconv.Itoa(n), "-postgres", pg.dsn(), "-postgres-batch", "100"), "")
+		if want := "Не записано в PostgreSQL 100\n"; !bytes.Contains(out, []byte(want)) {
+			t.Errorf("в отчёте нет %q:\n%s", want, out)
+		}
+		pg.mu.Lock()
+		defer pg.mu.Unlock()
+		if len(pg.rows) != n-100 {
+			t.Errorf("в PostgreSQL записано %d строк, ожидалось %d", len(pg.rows), n-100)
+		}
+	})
+
+	t.Run("webhook", func(t *testing.T) {
+		t.Parallel()
+		var received atomic.Int64
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var batch []json.RawMessage
+			if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			received.Add(int64(len(batch)))
+		}))
+		defer srv.Close()
+		runHarness(t, append(slices.Clone(harnessBase), "-max-items", strconv.Itoa(n), "-webhook", srv.URL), "")
+		if got := received.Load(); got != n {
+			t.Errorf("на webhook пришло %d чисел, ожидалось %d", got, n)
+		}
+	})
+}
+
+// TestPipelineSealed прогоняет конвейер со всеми сочетаниями шифрования
+// и сжатия: зашифрованный и сжатый ввод из stdin воспроизводится,
+// а результаты в stdout и Unix-сокете расшифровываются и распаковываются.
+func TestPipelineSealed(t *testing.T) {
+	if testing.Short() {
+		t.Skip("прогоны конвейера долгие")
+	}
+	const n = 1000
+	for _, compress := range []string{"", "gzip", "zstd"} {
+		for _, sealed := range []bool{false, true} {
+			t.Run(fmt.Sprintf("compress=%q/sealed=%v", compress, sealed), func(t *testing.T) {
+				t.Parallel()
+				dir := t.TempDir()
+				args := slices.Clone(harnessBase)
+				if compress != "" {
+					args = append(args, "-compress", compress)
+				}
+				var aead cipher.AEAD
+				if sealed {
+					path := filepath.Join(dir, "key")
+					if err := os.WriteFile(path, []byte(harnessKey), 0o600); err != nil {
+						t.Fatal(err)
+					}
+					var err error
+					if aead, err = LoadKey(path, ""); err != nil {
+						t.Fatal(err)
+					}
+					args = append(args, "-key-file", path)
+				}
+
+				out := runHarness(t, append(slices.Clone(args), "-stdin"), sealInput(t, harnessInput(n), aead, compress))
+				if lines := openLines(t, bytes.NewReader(out), aead); len(lines) != n {
+					t.Errorf("stdout: %d строк, ожидалось %d", len(lines), n)
+				}
+
+				// путь к сокету ограничен сотней байт, поэтому не в t.TempDir
+				sockDir, err := os.MkdirTemp("", "harness")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(sockDir)
+				path := filepath.Join(sockDir, "results.sock")
+				ln, err := net.Listen("unix", path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer ln.Close()
+				got := make(chan []string, 1)
+				go func() {
+					conn, err := ln.Accept()
+					if err != nil {
+						got <- nil
+						return
+					}
+					defer conn.Close()
+					got <- openLines(t, conn, aead)
+				}()
+				runHarness(t, append(args, "-max-items", strconv.Itoa(n), "-socket", path), "")
+				if lines := <-got; len(lines) != n {
+					t.Errorf("сокет: %d строк, ожидалось %d", len(lines), n)
+				}
+			})
+		}
+	}
+}
+
+// sealInput сжимает text способом compress и, если aead не nil, шифрует,
+// как это делают получатели конвейера.
+func sealInput(t *testing.T, text string, aead cipher.AEAD, compress string) string {
+	t.Helper()
+	var b bytes.Buffer
+	var w io.Writer = &b
+	if aead != nil {
+		w = NewSealWriter(&b, aead)
+	}
+	z := newCompressor(w, compress)
+	if _, err := io.WriteString(z, text); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// openLines расшифровывает и распаковывает поток результатов из r
+// и возвращает его строки.
+func openLines(t *testing.T, r io.Reader, aead cipher.AEAD) []string {
+	in, err := OpenStream(r, aead)
+	if err == nil {
+		in, err = Decompress(in)
+	}
+	var data []byte
+	if err == nil {
+		data, err = io.ReadAll(in)
+	}
+	if err != nil {
+		t.Errorf("чтение результатов: %v", err)
+		return nil
+	}
+	return strings.Fields(string(data))
+}
+
+// freeAddr возвращает адрес свободного порта на 127.0.0.1.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// waitListening ждёт, пока по адресу addr начнут принимать подключения.
+func waitListening(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s не принимает подключения: %v", addr, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Health отвечает на проверки оркестратора. /readyz перестаёт отвечать
+// «готов», как только начинается остановка, чтобы на процесс больше
+// не направляли запросы. /livez сообщает о сбое, только если конвейер
+// завис: числа в пути есть, а на выход давно ничего не приходило.
+type Health struct {
+	StuckAfter time.Duration // сколько можно не продвигаться, имея числа в пути
+
+	draining atomic.Bool
+
+	mu           sync.Mutex
+	lastCount    int64
+	lastProgress time.Time
+}
+
+// NewHealth создаёт проверки, считающие конвейер зависшим, если он
+// не продвигался дольше stuckAfter.
+func NewHealth(stuckAfter time.Duration) *Health {
+	return &Health{StuckAfter: stuckAfter, lastProgress: time.Now()}
+}
+
+// Drain отмечает начало остановки.
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+// Ready — обработчик /readyz.
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// Live — обработчик /livez.
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	if stuck := h.stuck(time.Now()); stuck > 0 {
+		http.Error(w, fmt.Sprintf("нет продвижения %v", stuck.Round(time.Second)),
+			http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// stuck возвращает, сколько конвейер не продвигается, имея числа в пути,
+// если это дольше StuckAfter, и ноль в остальных случаях.
+func (h *Health) stuck(now time.Time) time.Duration {
+	done := processedItems.Value() + expiredItems.Value() + failedItems.Value()
+	inFlight := generatedItems.Value() - done
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if done != h.lastCount || inFlight <= 0 {
+		h.lastCount = done
+		h.lastProgress = now
+		return 0
+	}
+	if d := now.Sub(h.lastProgress); d > h.StuckAfter {
+		return d
+	}
+	return 0
+}
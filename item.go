@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Item — число вместе со сведениями о том, как оно обрабатывалось.
+type Item struct {
+	Seq      int64     // порядковый номер числа в источнике, начиная с 1
+	Value    int64     // само число
+	Trace    TraceID   // идентификатор для журналов и трассировки
+	Worker   int       // номер обработчика, через который прошло число
+	Late     bool      // число пришло после следующих за ним при восстановлении порядка
+	Retries  int       // сколько раз число выдавалось заново после сбоя обработчика
+	Born     time.Time // когда число было сгенерировано
+	Deadline time.Time // после этого момента число устаревает; нулевое значение — без срока
+	Run      RunID     // запуск, в котором число появилось
+}
+
+// Expired сообщает, устарело ли число к моменту now.
+func (it Item) Expired(now time.Time) bool {
+	return !it.Deadline.IsZero() && now.After(it.Deadline)
+}
+
+// Context возвращает контекст для обработки числа: с его идентификатором
+// и, если задан срок, с дедлайном.
+func (it Item) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx := WithTrace(parent, it.Trace)
+	if it.Deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, it.Deadline)
+}
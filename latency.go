@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// DefaultLatency — пауза обработчика после каждого числа, если для него
+// не задан свой профиль задержки.
+var DefaultLatency = LatencyProfile{Delay: Duration(time.Millisecond)}
+
+// LatencyProfile — сколько времени обработчик тратит на одно число.
+type LatencyProfile struct {
+	Delay  Duration `json:"delay"`  // постоянная задержка
+	Jitter Duration `json:"jitter"` // случайная добавка от 0 до Jitter
+}
+
+// Validate проверяет, что задержки не отрицательные.
+func (p LatencyProfile) Validate() error {
+	if p.Delay < 0 || p.Jitter < 0 {
+		return fmt.Errorf("задержки не могут быть отрицательными")
+	}
+	return nil
+}
+
+// Next возвращает задержку для очередного числа.
+func (p LatencyProfile) Next() time.Duration {
+	d := time.Duration(p.Delay)
+	if p.Jitter > 0 {
+		d += rand.N(time.Duration(p.Jitter))
+	}
+	return d
+}
+
+// mean возвращает среднюю задержку.
+func (p LatencyProfile) mean() time.Duration {
+	return time.Duration(p.Delay + p.Jitter/2)
+}
+
+// LatencyFor возвращает профиль задержки обработчика id из profiles
+// или DefaultLatency.
+func LatencyFor(profiles map[int]LatencyProfile, id int) LatencyProfile {
+	if p, ok := profiles[id]; ok {
+		return p
+	}
+	return DefaultLatency
+}
+
+// ExpectedShares возвращает, какую долю чисел должен получить каждый
+// из workers обработчиков: обработчики забирают числа из общего канала,
+// как только освобождаются, поэтому доля обратно пропорциональна задержке.
+func ExpectedShares(profiles map[int]LatencyProfile, workers int) []float64 {
+	shares := make([]float64, workers)
+	var total float64
+	for i := range shares {
+		// время отправки результата тоже не нулевое, поэтому обработчик
+		// без задержки не забирает себе все числа
+		mean := LatencyFor(profiles, i).mean() + 10*time.Microsecond
+		shares[i] = 1 / mean.Seconds()
+		total += shares[i]
+	}
+	for i := range shares {
+		shares[i] /= total
+	}
+	return shares
+}
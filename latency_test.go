@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestExpectedShares проверяет, что доля обработчика обратно
+// пропорциональна его задержке, а без своего профиля он получает
+// DefaultLatency.
+func TestExpectedShares(t *testing.T) {
+	profiles := map[int]LatencyProfile{
+		0: {Delay: Duration(10 * time.Millisecond)},
+		1: {Delay: Duration(time.Millisecond)},
+		2: {Delay: Duration(500 * time.Microsecond), Jitter: Duration(time.Millisecond)},
+	}
+	shares := ExpectedShares(profiles, 4)
+	var total float64
+	for _, s := range shares {
+		total += s
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Errorf("сумма долей %v, ожидалась 1", total)
+	}
+	// у обработчиков 1 и 2 одна и та же средняя задержка, у 3 — задержка
+	// по умолчанию, такая же
+	if shares[1] != shares[2] || shares[1] != shares[3] {
+		t.Errorf("доли %v: у обработчиков 1–3 должны совпадать", shares)
+	}
+	if ratio := shares[1] / shares[0]; ratio < 9.5 || ratio > 10 {
+		t.Errorf("обработчик 1 получает в %.2f раза больше обработчика 0, ожидалось почти 10", ratio)
+	}
+}
+
+// TestLatencySkew запускает обработчиков с разными профилями задержки на
+// общем канале и проверяет, что медленный получает во столько же раз
+// меньше чисел, во сколько он медленнее.
+func TestLatencySkew(t *testing.T) {
+	if testing.Short() {
+		t.Skip("зависит от времени")
+	}
+	const workers = 4
+	profiles := map[int]LatencyProfile{0: {Delay: Duration(20 * time.Millisecond)}}
+	for i := 1; i < workers; i++ {
+		profiles[i] = LatencyProfile{Delay: Duration(2 * time.Millisecond)}
+	}
+
+	in := make(chan Item)
+	mon := NewMonitor(time.Second)
+	amounts := make([]int64, workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		w := NewWorker(i, in, mon, WithDelay(LatencyFor(profiles, i)))
+		go w.Run(context.Background())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range w.Out() {
+				amounts[i]++
+			}
+		}()
+	}
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for seq := int64(1); time.Now().Before(deadline); seq++ {
+		in <- Item{Seq: seq, Value: seq}
+	}
+	close(in)
+	wg.Wait()
+
+	var fast int64
+	for _, n := range amounts[1:] {
+		fast += n
+	}
+	// накладные расходы на паузу заметнее у быстрых обработчиков, поэтому
+	// допуск широкий: важно, что перекос есть и он того же порядка
+	ratio := float64(fast) / float64(workers-1) / float64(max(amounts[0], 1))
+	if ratio < 4 || ratio > 15 {
+		t.Errorf("разбивка %v: быстрый обработчик получает в %.1f раза больше медленного, ожидалось около 10", amounts, ratio)
+	}
+	shares := ExpectedShares(profiles, workers)
+	if f, ok := CheckFairness(amounts, shares); !ok || math.Abs(f.Deviation) > 0.6 {
+		t.Errorf("разбивка %v далека от ожидаемых долей %v: %+v", amounts, shares, f)
+	}
+}
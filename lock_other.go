@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// tryLockFile на этой платформе не поддерживается.
+func tryLockFile(f *os.File) (bool, error) {
+	return false, errors.New("выборы координатора через блокировку файла поддерживаются только в Unix")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"math"
+	"runtime"
+	"runtime/debug"
+	rtmetrics "runtime/metrics"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	memoryHigh     = 0.8 // при такой доле от GOMEMLIMIT число чисел в пути урезается
+	memoryLow      = 0.6 // а при такой — снова растёт
+	memoryMinLimit = 16  // меньше этого число чисел в пути не урезается
+)
+
+var (
+	memoryUsage  = new(expvar.Float) // доля занятой памяти от GOMEMLIMIT
+	memoryFlight = newCounter("memory_inflight_limit")
+)
+
+func init() {
+	metrics.Set("memory_usage", memoryUsage)
+}
+
+// MemoryGuard следит за расходом памяти относительно GOMEMLIMIT. Размер
+// каналов после создания не изменить, поэтому при приближении к пределу
+// MemoryGuard урезает число чисел в пути — сгенерированных, но ещё не
+// дошедших до выхода, — и генератор ждёт, пока конвейер разгрузится.
+// Когда памяти снова достаточно, ограничение постепенно снимается.
+// Если GOMEMLIMIT не задан, MemoryGuard ничего не ограничивает.
+type MemoryGuard struct {
+	limit   int64        // GOMEMLIMIT в байтах
+	allowed atomic.Int64 // сколько чисел может быть в пути
+}
+
+// NewMemoryGuard создаёт MemoryGuard для текущего GOMEMLIMIT.
+func NewMemoryGuard() *MemoryGuard {
+	g := &MemoryGuard{limit: debug.SetMemoryLimit(-1)}
+	g.allowed.Store(math.MaxInt64)
+	return g
+}
+
+// Wait ждёт, пока чисел в пути станет меньше разрешённого. Возвращает
+// false, если контекст ctx отменён раньше. Вызов для nil ничего не делает.
+func (g *MemoryGuard) Wait(ctx context.Context) bool {
+	if g == nil {
+		return true
+	}
+	for inFlight() >= g.allowed.Load() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Millisecond):
+		}
+	}
+	return true
+}
+
+// Run каждые interval замеряет память и подстраивает ограничение,
+// пока не отменён контекст ctx.
+func (g *MemoryGuard) Run(ctx context.Context, interval time.Duration) {
+	if g.limit == math.MaxInt64 {
+		return
+	}
+	samples := []rtmetrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			rtmetrics.Read(samples)
+			used := samples[0].Value.Uint64() - samples[1].Value.Uint64()
+			g.adjust(float64(used) / float64(g.limit))
+		}
+	}
+}
+
+// adjust подстраивает ограничение под долю занятой памяти usage.
+func (g *MemoryGuard) adjust(usage float64) {
+	memoryUsage.Set(usage)
+	allowed := g.allowed.Load()
+	switch {
+	case usage > memoryHigh:
+		next := max(min(allowed, inFlight())/2, memoryMinLimit)
+		if next < allowed {
+			slog.Warn("память близка к GOMEMLIMIT, урезаем числа в пути",
+				"usage", math.Round(usage*100)/100, "inflight_limit", next)
+			g.allowed.Store(next)
+		}
+	case usage < memoryLow && allowed != math.MaxInt64:
+		next := allowed * 2
+		if next > 1<<20 {
+			next = math.MaxInt64
+		}
+		g.allowed.Store(next)
+	}
+	memoryFlight.Set(min(g.allowed.Load(), math.MaxInt32))
+}
+
+// inFlight возвращает, сколько чисел сгенерировано, но ещё не дошло
+// до выхода.
+func inFlight() int64 {
+	return generatedItems.Value() - processedItems.Value() - expiredItems.Value() - failedItems.Value()
+}
+
+// heapPeak — наибольший объём живых объектов в куче, замеренный SampleHeap.
+var heapPeak = newCounter("heap_peak")
+
+// SampleHeap каждые interval замеряет объём живых объектов в куче
+// и запоминает наибольший, пока не отменён контекст ctx. Замер через
+// runtime/metrics не останавливает программу.
+func SampleHeap(ctx context.Context, interval time.Duration) {
+	sample := []rtmetrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		rtmetrics.Read(sample)
+		if v := int64(sample[0].Value.Uint64()); v > heapPeak.Value() {
+			heapPeak.Set(v)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// MemorySummary — расход памяти и сборки мусора с запуска процесса.
+type MemorySummary struct {
+	HeapPeak   int64         // наибольшая куча по замерам SampleHeap
+	TotalAlloc uint64        // сколько байт выделено всего
+	Mallocs    uint64        // сколько объектов выделено всего
+	NumGC      uint32        // сколько было сборок мусора
+	PauseTotal time.Duration // сколько длились все паузы на сборку
+	PauseMax   time.Duration // самая долгая из последних 256 пауз
+}
+
+// ReadMemorySummary возвращает расход памяти к этому моменту.
+func ReadMemorySummary() MemorySummary {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s := MemorySummary{
+		HeapPeak:   max(heapPeak.Value(), int64(ms.HeapAlloc)),
+		TotalAlloc: ms.TotalAlloc,
+		Mallocs:    ms.Mallocs,
+		NumGC:      ms.NumGC,
+		PauseTotal: time.Duration(ms.PauseTotalNs),
+	}
+	for _, p := range ms.PauseNs[:min(ms.NumGC, uint32(len(ms.PauseNs)))] {
+		s.PauseMax = max(s.PauseMax, time.Duration(p))
+	}
+	return s
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"time"
+)
+
+// metrics — счётчики и датчики конвейера. Они доступны по HTTP
+// в /debug/vars, если запущен сервер метрик.
+var metrics = expvar.NewMap("pipeline")
+
+// PipelineStats — счётчики чисел одного конвейера. У основного конвейера
+// они лежат в metrics, у именованных — в своих разделах pipelines.
+type PipelineStats struct {
+	Generated *expvar.Int // сколько чисел сгенерировано
+	Processed *expvar.Int // сколько чисел получено на выходе
+	Expired   *expvar.Int // сколько чисел устарело
+	Failed    *expvar.Int // сколько чисел обработать не удалось
+
+	GeneratedSum *expvar.Int // сумма сгенерированных чисел
+	ProcessedSum *expvar.Int // сумма чисел, полученных на выходе
+	ExpiredSum   *expvar.Int // сумма устаревших чисел
+	FailedSum    *expvar.Int // сумма чисел, обработать которые не удалось
+}
+
+// newPipelineStats регистрирует счётчики конвейера в m.
+func newPipelineStats(m *expvar.Map) *PipelineStats {
+	counter := func(name string) *expvar.Int {
+		v := new(expvar.Int)
+		m.Set(name, v)
+		return v
+	}
+	return &PipelineStats{
+		Generated:    counter("generated"),
+		Processed:    counter("processed"),
+		Expired:      counter("expired"),
+		Failed:       counter("failed"),
+		GeneratedSum: counter("generated_sum"),
+		ProcessedSum: counter("processed_sum"),
+		ExpiredSum:   counter("expired_sum"),
+		FailedSum:    counter("failed_sum"),
+	}
+}
+
+// mainStats — счётчики основного конвейера.
+var mainStats = newPipelineStats(metrics)
+
+var (
+	generatedItems = mainStats.Generated
+	processedItems = mainStats.Processed
+	expiredItems   = mainStats.Expired
+	failedItems    = mainStats.Failed
+
+	sumGenerated = mainStats.GeneratedSum
+	sumProcessed = mainStats.ProcessedSum
+	sumExpired   = mainStats.ExpiredSum
+	sumFailed    = mainStats.FailedSum
+)
+
+// newCounter регистрирует в metrics счётчик с именем name.
+func newCounter(name string) *expvar.Int {
+	v := new(expvar.Int)
+	metrics.Set(name, v)
+	return v
+}
+
+// ChannelDepth — датчик заполненности канала между этапами.
+type ChannelDepth struct {
+	Name     string // название канала
+	Producer string // этап, который пишет в канал
+	Consumer string // этап, который читает из канала
+	len      func() int
+	cap      int
+	cur      *expvar.Int // заполненность при последнем замере
+	max      *expvar.Int // наибольшая замеренная заполненность
+}
+
+// WatchChannel создаёт датчик заполненности канала ch, в который пишет
+// этап producer, а читает этап consumer.
+func WatchChannel[T any](name, producer, consumer string, ch <-chan T) *ChannelDepth {
+	return &ChannelDepth{
+		Name:     name,
+		Producer: producer,
+		Consumer: consumer,
+		len:      func() int { return len(ch) },
+		cap:      cap(ch),
+		cur:      newCounter("depth_" + name),
+		max:      newCounter("depth_max_" + name),
+	}
+}
+
+// Depth возвращает заполненность канала при последнем замере и его ёмкость.
+func (d *ChannelDepth) Depth() (cur, capacity int) {
+	return int(d.cur.Value()), d.cap
+}
+
+func (d *ChannelDepth) sample() {
+	n := int64(d.len())
+	d.cur.Set(n)
+	if n > d.max.Value() {
+		d.max.Set(n)
+	}
+}
+
+// SampleDepths каждые interval замеряет заполненность каналов depths,
+// пока не отменён контекст ctx.
+func SampleDepths(ctx context.Context, interval time.Duration, depths []*ChannelDepth) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			for _, d := range depths {
+				d.sample()
+			}
+		}
+	}
+}
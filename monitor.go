@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// staleWorkers — сколько раз обработчики были отмечены как зависшие.
+var staleWorkers = newCounter("worker_stale")
+
+// Heartbeat — сигнал жизни обработчика.
+type Heartbeat struct {
+	Worker    int           // номер обработчика
+	LastItem  time.Time     // время обработки последнего числа
+	Processed int64         // количество обработанных чисел
+	Busy      time.Duration // сколько времени ушло на обработку
+	Blocked   time.Duration // сколько времени обработчик ждал отправки результата
+	seen      time.Time     // когда монитор получил сигнал
+}
+
+// Monitor собирает сигналы жизни от обработчиков и отмечает тех,
+// от кого сигналы не поступали дольше Timeout.
+type Monitor struct {
+	Timeout time.Duration
+	// OnStale вызывается, когда обработчик перестал присылать сигналы.
+	// Через него супервизор может перезапустить обработчик.
+	OnStale func(hb Heartbeat)
+
+	mu     sync.Mutex
+	beats  map[int]Heartbeat
+	stale  map[int]bool
+	final  map[int]Heartbeat // итоги каждого завершившегося обработчика
+	missed int64             // сколько раз обработчики были отмечены как зависшие
+}
+
+// NewMonitor создаёт монитор с заданным временем ожидания сигнала.
+func NewMonitor(timeout time.Duration) *Monitor {
+	return &Monitor{
+		Timeout: timeout,
+		beats:   make(map[int]Heartbeat),
+		stale:   make(map[int]bool),
+		final:   make(map[int]Heartbeat),
+	}
+}
+
+// Beat принимает очередной сигнал жизни обработчика.
+func (m *Monitor) Beat(hb Heartbeat) {
+	hb.seen = time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stale[hb.Worker] {
+		log.Printf("обработчик %d снова отвечает\n", hb.Worker)
+		delete(m.stale, hb.Worker)
+	}
+	m.beats[hb.Worker] = hb
+}
+
+// Done снимает обработчик с наблюдения после его штатного завершения
+// и запоминает его последний сигнал.
+func (m *Monitor) Done(hb Heartbeat) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.beats, hb.Worker)
+	delete(m.stale, hb.Worker)
+
+	f := m.final[hb.Worker]
+	f.Worker = hb.Worker
+	f.LastItem = hb.LastItem
+	f.Processed += hb.Processed
+	f.Busy += hb.Busy
+	f.Blocked += hb.Blocked
+	m.final[hb.Worker] = f
+}
+
+// Processed возвращает, сколько чисел по собственному счёту обработал
+// завершившийся обработчик worker.
+func (m *Monitor) Processed(worker int) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.final[worker].Processed
+}
+
+// Slowest возвращает до n завершившихся обработчиков, которые дольше всех
+// ждали отправки результата, то есть упирались в медленного получателя.
+func (m *Monitor) Slowest(n int) []Heartbeat {
+	m.mu.Lock()
+	res := make([]Heartbeat, 0, len(m.final))
+	for _, hb := range m.final {
+		res = append(res, hb)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Blocked > res[j].Blocked })
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// Snapshot возвращает последние сигналы работающих обработчиков
+// и итоги завершившихся, упорядоченные по номеру обработчика.
+func (m *Monitor) Snapshot() []Heartbeat {
+	m.mu.Lock()
+	res := make([]Heartbeat, 0, len(m.beats)+len(m.final))
+	for _, hb := range m.beats {
+		res = append(res, hb)
+	}
+	for id, hb := range m.final {
+		if _, ok := m.beats[id]; !ok {
+			res = append(res, hb)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Worker < res[j].Worker })
+	return res
+}
+
+// Run периодически проверяет сигналы жизни, пока не отменён контекст ctx.
+func (m *Monitor) Run(ctx context.Context) {
+	tick := time.NewTicker(m.Timeout / 2)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C:
+			for _, hb := range m.check(now) {
+				log.Printf("обработчик %d не отвечает %v: обработано %d, последнее число в %v\n",
+					hb.Worker, now.Sub(hb.seen).Round(time.Millisecond), hb.Processed,
+					hb.LastItem.Format("15:04:05.000"))
+				if m.OnStale != nil {
+					m.OnStale(hb)
+				}
+			}
+		}
+	}
+}
+
+// check возвращает обработчиков, которые только что перестали отвечать.
+func (m *Monitor) check(now time.Time) []Heartbeat {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var res []Heartbeat
+	for id, hb := range m.beats {
+		if m.stale[id] || now.Sub(hb.seen) <= m.Timeout {
+			continue
+		}
+		m.stale[id] = true
+		atomic.AddInt64(&m.missed, 1)
+		staleWorkers.Add(1)
+		res = append(res, hb)
+	}
+	return res
+}
+
+// Missed возвращает, сколько раз обработчики были отмечены как зависшие.
+func (m *Monitor) Missed() int64 {
+	return atomic.LoadInt64(&m.missed)
+}
+
+// Supervisor перезапускает обработчиков, которых монитор отметил как
+// зависших.
+type Supervisor struct {
+	mu      sync.Mutex
+	workers map[int]*Worker
+}
+
+// NewSupervisor создаёт супервизор без обработчиков.
+func NewSupervisor() *Supervisor {
+	return &Supervisor{workers: make(map[int]*Worker)}
+}
+
+// Watch ставит обработчик w под присмотр супервизора.
+func (s *Supervisor) Watch(w *Worker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.workers[w.id] = w
+}
+
+// Restart перезапускает обработчик по сигналу hb, если тот завис на
+// обработке числа. Возвращает, перезапущен ли обработчик.
+func (s *Supervisor) Restart(hb Heartbeat) bool {
+	s.mu.Lock()
+	w := s.workers[hb.Worker]
+	s.mu.Unlock()
+	if w == nil || !w.Restart() {
+		return false
+	}
+	log.Printf("обработчик %d перезапущен\n", hb.Worker)
+	return true
+}
@@ -0,0 +1,6 @@
+//go:build !race
+
+package main
+
+// raceEnabled — тесты собраны с детектором гонок.
+const raceEnabled = false
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ChannelOwner — канал между этапами вместе с единственным этапом, который
+// вправе его закрыть. Писать в канал и читать из него может кто угодно,
+// а закрывает его только владелец и только один раз: иначе Close паникует
+// и называет, кто закрыл канал и кто должен был. Так нарушение видно сразу,
+// а не по панике «close of closed channel» где-то в другом этапе.
+type ChannelOwner[T any] struct {
+	C     chan T // сам канал
+	Name  string // название канала
+	Owner string // этап, который закрывает канал
+
+	mu       sync.Mutex
+	closedBy string // этап, который уже закрыл канал
+}
+
+// NewChannelOwner создаёт канал name ёмкостью size, которым владеет
+// этап owner.
+func NewChannelOwner[T any](name, owner string, size int) *ChannelOwner[T] {
+	return &ChannelOwner[T]{C: make(chan T, size), Name: name, Owner: owner}
+}
+
+// Close закрывает канал от имени этапа stage. Паникует, если stage не
+// владелец канала или канал уже закрыт.
+func (c *ChannelOwner[T]) Close(stage string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stage != c.Owner {
+		panic(fmt.Sprintf("канал %s закрывает этап %q, а владеет им %q", c.Name, stage, c.Owner))
+	}
+	if c.closedBy != "" {
+		panic(fmt.Sprintf("канал %s закрыт повторно этапом %q", c.Name, stage))
+	}
+	c.closedBy = stage
+	close(c.C)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+	"time"
+)
+
+// parquetRowGroup — сколько строк накапливается перед записью группы строк.
+const parquetRowGroup = 1 << 16
+
+// типы, кодировки и прочие константы формата Parquet (parquet.thrift)
+const (
+	parquetInt32           = 1
+	parquetInt64           = 2
+	parquetRequired        = 0
+	parquetTimestampMicros = 10
+	parquetPlain           = 0
+	parquetRLE             = 3
+	parquetUncompressed    = 0
+	parquetDataPage        = 0
+)
+
+// parquetType возвращает физический тип Parquet для столбца вида kind
+// и логический тип (0 — без него).
+func parquetType(kind columnKind) (typ, converted int32) {
+	switch kind {
+	case columnInt32:
+		return parquetInt32, 0
+	case columnTimestamp:
+		return parquetInt64, parquetTimestampMicros
+	}
+	return parquetInt64, 0
+}
+
+// ParquetSink пишет числа в файл Parquet: номер, значение, обработчик,
+// время рождения и получения и задержку в пути. Строки копятся
+// в памяти и записываются группами по parquetRowGroup, а описание файла
+// дописывается при закрытии, поэтому незакрытый файл прочитать нельзя.
+type ParquetSink struct {
+	f      *os.File
+	w      *bufio.Writer
+	offset int64
+	rows   [][]int64 // значения строк текущей группы по столбцам
+	groups []parquetRowGroupMeta
+	total  int64
+}
+
+// parquetRowGroupMeta — где в файле лежат столбцы записанной группы строк.
+type parquetRowGroupMeta struct {
+	rows    int64
+	offsets []int64 // начало страницы каждого столбца
+	sizes   []int64 // размер столбца вместе с заголовком страницы
+}
+
+// CreateParquet создаёт файл Parquet path.
+func CreateParquet(path string) (*ParquetSink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &ParquetSink{f: f, w: bufio.NewWriter(f), rows: make([][]int64, len(itemColumns))}
+	s.write([]byte("PAR1"))
+	return s, nil
+}
+
+func (s *ParquetSink) write(b []byte) {
+	n, _ := s.w.Write(b)
+	s.offset += int64(n)
+	sinkBytes.Add(int64(n))
+}
+
+// Put добавляет число it в текущую группу строк.
+func (s *ParquetSink) Put(it Item) error {
+	now := time.Now()
+	for i, c := range itemColumns {
+		s.rows[i] = append(s.rows[i], c.value(it, now))
+	}
+	if len(s.rows[0]) < parquetRowGroup {
+		return nil
+	}
+	return s.flushGroup()
+}
+
+// flushGroup записывает накопленные строки отдельной группой.
+func (s *ParquetSink) flushGroup() error {
+	n := len(s.rows[0])
+	if n == 0 {
+		return nil
+	}
+	g := parquetRowGroupMeta{rows: int64(n)}
+	for i, c := range itemColumns {
+		data := c.appendValues(nil, s.rows[i])
+		var h thriftWriter
+		h.i32(1, parquetDataPage)
+		h.i32(2, int32(len(data)))
+		h.i32(3, int32(len(data)))
+		h.beginStruct(5)
+		h.i32(1, int32(n))
+		h.i32(2, parquetPlain)
+		h.i32(3, parquetRLE)
+		h.i32(4, parquetRLE)
+		h.endStruct()
+		h.stop()
+
+		g.offsets = append(g.offsets, s.offset)
+		g.sizes = append(g.sizes, int64(len(h.buf)+len(data)))
+		s.write(h.buf)
+		s.write(data)
+		s.rows[i] = s.rows[i][:0]
+	}
+	s.groups = append(s.groups, g)
+	s.total += int64(n)
+	return s.w.Flush()
+}
+
+// Close записывает оставшиеся строки и описание файла и закрывает его.
+func (s *ParquetSink) Close() error {
+	if err := s.flushGroup(); err != nil {
+		s.f.Close()
+		return err
+	}
+
+	var m thriftWriter
+	m.i32(1, 1)
+	m.beginList(2, thriftStruct, len(itemColumns)+1)
+	m.beginElem()
+	m.binary(4, "schema")
+	m.i32(5, int32(len(itemColumns)))
+	m.endStruct()
+	for _, c := range itemColumns {
+		typ, converted := parquetType(c.kind)
+		m.beginElem()
+		m.i32(1, typ)
+		m.i32(3, parquetRequired)
+		m.binary(4, c.name)
+		if converted != 0 {
+			m.i32(6, converted)
+		}
+		m.endStruct()
+	}
+	m.i64(3, s.total)
+	m.beginList(4, thriftStruct, len(s.groups))
+	for _, g := range s.groups {
+		m.beginElem()
+		m.beginList(1, thriftStruct, len(itemColumns))
+		var total int64
+		for i, c := range itemColumns {
+			typ, _ := parquetType(c.kind)
+			m.beginElem()
+			m.i64(2, g.offsets[i])
+			m.beginStruct(3)
+			m.i32(1, typ)
+			m.beginList(2, thriftI32, 2)
+			m.elemI32(parquetPlain)
+			m.elemI32(parquetRLE)
+			m.beginList(3, thriftBinary, 1)
+			m.elemBinary(c.name)
+			m.i32(4, parquetUncompressed)
+			m.i64(5, g.rows)
+			m.i64(6, g.sizes[i])
+			m.i64(7, g.sizes[i])
+			m.i64(9, g.offsets[i])
+			m.endStruct()
+			m.endStruct()
+			total += g.sizes[i]
+		}
+		m.i64(2, total)
+		m.i64(3, g.rows)
+		m.endStruct()
+	}
+	m.binary(6, "sprint9 version 1.0")
+	m.stop()
+
+	s.write(m.buf)
+	s.write(binary.LittleEndian.AppendUint32(nil, uint32(len(m.buf))))
+	s.write([]byte("PAR1"))
+	err := s.w.Flush()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// типы полей компактного протокола Thrift
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter кодирует структуры компактным протоколом Thrift — им
+// записаны заголовки страниц и описание файла Parquet.
+type thriftWriter struct {
+	buf  []byte
+	last []int16 // номер последнего поля в каждой открытой структуре
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if len(t.last) == 0 {
+		t.last = append(t.last, 0)
+	}
+	last := &t.last[len(t.last)-1]
+	if d := id - *last; d > 0 && d <= 15 {
+		t.buf = append(t.buf, byte(d)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.buf = binary.AppendVarint(t.buf, int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.elemI32(v)
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.buf = binary.AppendVarint(t.buf, v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.elemBinary(s)
+}
+
+// beginStruct открывает вложенную структуру в поле id.
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.last = append(t.last, 0)
+}
+
+// beginList открывает в поле id список из n элементов типа typ.
+func (t *thriftWriter) beginList(id int16, typ byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|typ)
+	} else {
+		t.buf = append(t.buf, 0xf0|typ)
+		t.buf = binary.AppendUvarint(t.buf, uint64(n))
+	}
+}
+
+// beginElem открывает структуру — элемент списка.
+func (t *thriftWriter) beginElem() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) elemI32(v int32) {
+	t.buf = binary.AppendVarint(t.buf, int64(v))
+}
+
+func (t *thriftWriter) elemBinary(s string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+// endStruct закрывает вложенную структуру.
+func (t *thriftWriter) endStruct() {
+	t.stop()
+	t.last = t.last[:len(t.last)-1]
+}
+
+// stop закрывает структуру верхнего уровня.
+func (t *thriftWriter) stop() {
+	t.buf = append(t.buf, 0)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// thriftReader разбирает структуры компактного протокола Thrift. Поля
+// структуры возвращаются по номерам: целые — как int64, строки — как
+// string, списки — как []any, вложенные структуры — как map[int16]any.
+type thriftReader struct {
+	buf []byte
+	pos int
+	t   *testing.T
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.buf) {
+		r.t.Fatalf("структура Thrift обрывается на байте %d", r.pos)
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) varint() int64 {
+	v, n := binary.Varint(r.buf[r.pos:])
+	if n <= 0 {
+		r.t.Fatalf("неверное число на байте %d", r.pos)
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		r.t.Fatalf("неверное число на байте %d", r.pos)
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) structure() map[int16]any {
+	fields := make(map[int16]any)
+	var last int16
+	for {
+		b := r.byte()
+		if b == 0 {
+			return fields
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			id = int16(r.varint())
+		}
+		last = id
+		fields[id] = r.value(b & 0xf)
+	}
+}
+
+func (r *thriftReader) value(typ byte) any {
+	switch typ {
+	case thriftI32, thriftI64:
+		return r.varint()
+	case thriftBinary:
+		n := int(r.uvarint())
+		s := string(r.buf[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case thriftList:
+		h := r.byte()
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = r.value(h & 0xf)
+		}
+		return list
+	case thriftStruct:
+		return r.structure()
+	}
+	r.t.Fatalf("неизвестный тип поля Thrift %d на байте %d", typ, r.pos)
+	return nil
+}
+
+// TestParquetFile записывает несколько чисел в файл Parquet и читает его
+// обратно: подписи, описание файла, заголовки страниц и значения.
+func TestParquetFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.parquet")
+	s, err := CreateParquet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	born := time.Now().Add(-time.Second).Truncate(time.Microsecond)
+	items := []Item{
+		{Seq: 1, Value: 10, Worker: 0, Born: born, Run: 7},
+		{Seq: 2, Value: -20, Worker: 3, Born: born.Add(time.Millisecond), Run: 7},
+		{Seq: 3, Value: 30, Worker: 1, Born: born.Add(2 * time.Millisecond), Run: 7},
+	}
+	for _, it := range items {
+		if err := s.Put(it); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Fatalf("файл не начинается и не заканчивается на PAR1: % x", data)
+	}
+	footer := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footer <= 0 || footer > len(data)-12 {
+		t.Fatalf("длина описания %d, а размер файла %d", footer, len(data))
+	}
+	r := &thriftReader{buf: data[len(data)-8-footer : len(data)-8], t: t}
+	meta := r.structure()
+	if r.pos != footer {
+		t.Errorf("описание заняло %d байт из %d", r.pos, footer)
+	}
+
+	if v := meta[1]; v != int64(1) {
+		t.Errorf("версия %v, ожидалась 1", v)
+	}
+	if n := meta[3]; n != int64(len(items)) {
+		t.Errorf("num_rows = %v, ожидалось %d", n, len(items))
+	}
+	schema := meta[2].([]any)
+	if len(schema) != len(itemColumns)+1 {
+		t.Fatalf("в схеме %d элементов, ожидалось %d", len(schema), len(itemColumns)+1)
+	}
+	if root := schema[0].(map[int16]any); root[4] != "schema" || root[5] != int64(len(itemColumns)) {
+		t.Errorf("корень схемы %v, ожидалось schema с %d полями", root, len(itemColumns))
+	}
+	for i, c := range itemColumns {
+		f := schema[i+1].(map[int16]any)
+		typ, converted := parquetType(c.kind)
+		if f[4] != c.name || f[1] != int64(typ) || f[3] != int64(parquetRequired) {
+			t.Errorf("поле %d: %v, ожидалось обязательное %s типа %d", i, f, c.name, typ)
+		}
+		if converted != 0 && f[6] != int64(converted) {
+			t.Errorf("поле %s: логический тип %v, ожидался %d", c.name, f[6], converted)
+		}
+	}
+
+	groups := meta[4].([]any)
+	if len(groups) != 1 {
+		t.Fatalf("групп строк %d, ожидалась одна", len(groups))
+	}
+	group := groups[0].(map[int16]any)
+	if group[3] != int64(len(items)) {
+		t.Errorf("в группе %v строк, ожидалось %d", group[3], len(items))
+	}
+	chunks := group[1].([]any)
+	if len(chunks) != len(itemColumns) {
+		t.Fatalf("в группе %d столбцов, ожидалось %d", len(chunks), len(itemColumns))
+	}
+	offset, total := int64(4), int64(0) // первый столбец идёт сразу после PAR1
+	for i, c := range itemColumns {
+		chunk := chunks[i].(map[int16]any)
+		cm := chunk[3].(map[int16]any)
+		typ, _ := parquetType(c.kind)
+		if chunk[2] != offset || cm[9] != offset {
+			t.Fatalf("столбец %s начинается с %v (страница %v), ожидалось %d", c.name, chunk[2], cm[9], offset)
+		}
+		if path := cm[3].([]any); cm[1] != int64(typ) || len(path) != 1 || path[0] != c.name || cm[5] != int64(len(items)) {
+			t.Errorf("столбец %s: описание %v", c.name, cm)
+		}
+		size := cm[7].(int64)
+
+		page := &thriftReader{buf: data[offset : offset+size], t: t}
+		header := page.structure()
+		values := page.buf[page.pos:]
+		if header[1] != int64(parquetDataPage) || header[2] != int64(len(values)) || header[3] != int64(len(values)) {
+			t.Errorf("столбец %s: заголовок страницы %v при %d байтах данных", c.name, header, len(values))
+		}
+		if dp := header[5].(map[int16]any); dp[1] != int64(len(items)) || dp[2] != int64(parquetPlain) {
+			t.Errorf("столбец %s: заголовок данных %v", c.name, dp)
+		}
+
+		width := 8
+		if c.kind == columnInt32 {
+			width = 4
+		}
+		if len(values) != width*len(items) {
+			t.Fatalf("столбец %s: %d байт значений, ожидалось %d", c.name, len(values), width*len(items))
+		}
+		if c.name != "received" && c.name != "latency_us" { // зависят от времени записи
+			for j, it := range items {
+				var got int64
+				if width == 4 {
+					got = int64(int32(binary.LittleEndian.Uint32(values[4*j:])))
+				} else {
+					got = int64(binary.LittleEndian.Uint64(values[8*j:]))
+				}
+				if want := c.value(it, time.Time{}); got != want {
+					t.Errorf("столбец %s, строка %d: %d, ожидалось %d", c.name, j, got, want)
+				}
+			}
+		}
+		offset += size
+		total += size
+	}
+	if group[2] != total {
+		t.Errorf("размер группы %v, ожидалось %d", group[2], total)
+	}
+	if end := int64(len(data) - 8 - footer); offset != end {
+		t.Errorf("столбцы кончаются на %d, а описание начинается с %d", offset, end)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"iter"
+)
+
+// ToChan запускает горутину, которая проходит по seq и отправляет значения
+// в возвращаемый канал. Канал закрывается, когда seq закончилась или
+// отменён контекст ctx; после отмены горутина завершается, даже если
+// канал никто не читает.
+func ToChan[T any](ctx context.Context, seq iter.Seq[T]) <-chan T {
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+		for v := range seq {
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- v:
+			}
+		}
+	}()
+	return ch
+}
+
+// FromChan возвращает итератор по значениям из канала ch. Итератор
+// заканчивается, когда канал закрыт.
+func FromChan[T any](ch <-chan T) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for v := range ch {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"iter"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestToChan проверяет, что ToChan отдаёт значения по порядку и закрывает
+// канал, когда последовательность закончилась или отменён контекст.
+func TestToChan(t *testing.T) {
+	tests := []struct {
+		name   string
+		seq    iter.Seq[int64]
+		cancel int // после скольких значений отменить контекст; 0 — не отменять
+		want   []int64
+	}{
+		{"пустая", slices.Values([]int64(nil)), 0, nil},
+		{"до конца", slices.Values([]int64{1, 2, 3}), 0, []int64{1, 2, 3}},
+		// бесконечная последовательность: канал закроется только по отмене
+		{"отмена", Sequence(context.Background()), 2, []int64{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var got []int64
+			for v := range ToChan(ctx, tt.seq) {
+				got = append(got, v)
+				if len(got) == tt.cancel {
+					cancel()
+				}
+			}
+			// отправка и отмена могут быть готовы одновременно, поэтому после
+			// отмены в канал могут успеть уйти ещё значения
+			if len(got) < len(tt.want) || !slices.Equal(got[:len(tt.want)], tt.want) ||
+				(tt.cancel == 0 && len(got) != len(tt.want)) {
+				t.Errorf("получено %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestToChanNoLeak проверяет, что после отмены контекста горутина ToChan
+// завершается, даже если канал больше никто не читает.
+func TestToChanNoLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := ToChan(ctx, Sequence(context.Background()))
+	<-ch
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("горутин стало %d, было %d", n, before)
+	}
+}
+
+// TestFromChan проверяет, что FromChan проходит по каналу до закрытия,
+// а при выходе из цикла по break оставляет непрочитанное в канале.
+func TestFromChan(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    []int
+		limit int // после скольких значений выйти из цикла; 0 — читать до конца
+		want  []int
+		rest  []int
+	}{
+		{"пустой", nil, 0, nil, nil},
+		{"до закрытия", []int{1, 2, 3}, 0, []int{1, 2, 3}, nil},
+		{"break", []int{1, 2, 3, 4}, 2, []int{1, 2}, []int{3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := make(chan int, len(tt.in))
+			for _, v := range tt.in {
+				ch <- v
+			}
+			close(ch)
+			var got []int
+			for v := range FromChan(ch) {
+				got = append(got, v)
+				if len(got) == tt.limit {
+					break
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("получено %v, ожидалось %v", got, tt.want)
+			}
+			if rest := slices.Collect(FromChan(ch)); !slices.Equal(rest, tt.rest) {
+				t.Errorf("в канале осталось %v, ожидалось %v", rest, tt.rest)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrPoolClosed — пул закрыт и новых задач не принимает.
+var ErrPoolClosed = errors.New("пул закрыт")
+
+// Pool — пул из фиксированного числа обработчиков с ограниченной очередью.
+// Задачи передаются через Submit, результаты читаются из Results в порядке
+// готовности. Results нужно вычитывать до конца: пока результаты никто не
+// забирает, обработчики стоят, а Submit ждёт места в очереди.
+type Pool[T, R any] struct {
+	tasks   chan T
+	results chan R
+	quit    chan struct{} // закрывается при закрытии пула и будит ждущие Submit
+	done    chan struct{} // закрывается, когда обработаны все принятые задачи
+
+	mu     sync.RWMutex
+	closed bool
+	once   sync.Once
+}
+
+// NewPool запускает workers обработчиков, каждый из которых вызывает fn
+// для очередной задачи. В очереди ждут не больше queue задач.
+func NewPool[T, R any](workers, queue int, fn func(worker int, task T) R) *Pool[T, R] {
+	p := &Pool[T, R]{
+		tasks:   make(chan T, queue),
+		results: make(chan R, queue),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for task := range p.tasks {
+				p.results <- fn(id, task)
+			}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(p.results)
+		close(p.done)
+	}()
+	return p
+}
+
+// Submit ставит задачу в очередь, при необходимости дожидаясь места.
+// Возвращает ErrPoolClosed, если пул закрыт, или ошибку контекста ctx,
+// если он отменён раньше, чем задача принята. Если место в очереди есть,
+// задача принимается и при отменённом ctx.
+func (p *Pool[T, R]) Submit(ctx context.Context, task T) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+	select {
+	case p.tasks <- task:
+		return nil
+	default:
+	}
+	select {
+	case p.tasks <- task:
+		return nil
+	case <-p.quit:
+		return ErrPoolClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Results возвращает канал результатов. Он закрывается, когда после
+// закрытия пула обработаны все принятые задачи.
+func (p *Pool[T, R]) Results() <-chan R {
+	return p.results
+}
+
+// Close перестаёт принимать задачи. Уже принятые задачи будут обработаны.
+// Повторный вызов ничего не делает.
+func (p *Pool[T, R]) Close() {
+	p.once.Do(func() {
+		close(p.quit)
+		p.mu.Lock()
+		p.closed = true
+		close(p.tasks)
+		p.mu.Unlock()
+	})
+}
+
+// Shutdown закрывает пул и ждёт, пока обработчики доделают принятые задачи.
+// Если контекст ctx отменён раньше, возвращает его ошибку; обработчики
+// при этом продолжают работу.
+func (p *Pool[T, R]) Shutdown(ctx context.Context) error {
+	p.Close()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestPoolQueueBounded проверяет, что пул принимает задачи, пока есть
+// свободные обработчики и место в очереди, а дальше Submit ждёт.
+func TestPoolQueueBounded(t *testing.T) {
+	release := make(chan struct{})
+	p := NewPool(2, 3, func(_ int, task int) int {
+		<-release
+		return task
+	})
+	// два обработчика заняты, три задачи в очереди
+	for i := range 5 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := p.Submit(ctx, i)
+		cancel()
+		if err != nil {
+			t.Fatalf("задача %d: %v", i, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Submit(ctx, 5); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("шестая задача: ошибка %v, ожидалось истечение срока", err)
+	}
+	const accepted = 5
+
+	close(release)
+	p.Close()
+	var got []int
+	for r := range p.Results() {
+		got = append(got, r)
+	}
+	slices.Sort(got)
+	if len(got) != accepted || got[0] != 0 || got[len(got)-1] != accepted-1 {
+		t.Errorf("результаты %v, ожидались 0..%d", got, accepted-1)
+	}
+}
+
+// TestPoolShutdownDrains проверяет, что Shutdown дожидается обработки
+// всех задач, принятых до закрытия, в том числе ещё стоявших в очереди.
+func TestPoolShutdownDrains(t *testing.T) {
+	p := NewPool(1, 10, func(_ int, task int) int {
+		time.Sleep(time.Millisecond)
+		return task * task
+	})
+	for i := range 10 {
+		if err := p.Submit(context.Background(), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := make(chan []int)
+	go func() {
+		var got []int
+		for r := range p.Results() {
+			got = append(got, r)
+		}
+		done <- got
+	}()
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := <-done
+	if len(got) != 10 || got[9] != 81 {
+		t.Errorf("результаты %v, ожидались квадраты 0..9", got)
+	}
+}
+
+// TestPoolShutdownTimeout проверяет, что Shutdown возвращает ошибку
+// контекста, если задачи не успели обработаться, а обработчики при этом
+// доделывают их.
+func TestPoolShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	p := NewPool(1, 1, func(_ int, task int) int {
+		<-release
+		return task
+	})
+	if err := p.Submit(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown: ошибка %v, ожидалось истечение срока", err)
+	}
+	close(release)
+	if r, ok := <-p.Results(); !ok || r != 1 {
+		t.Errorf("результат %d, %v; ожидалась обработанная задача 1", r, ok)
+	}
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestPoolSubmitAfterShutdown проверяет, что закрытый пул не принимает
+// задачи, а Submit, ждавший места в очереди, узнаёт о закрытии.
+func TestPoolSubmitAfterShutdown(t *testing.T) {
+	release := make(chan struct{})
+	p := NewPool(1, 0, func(_ int, task int) int {
+		<-release
+		return task
+	})
+	if err := p.Submit(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	// обработчик занят, очереди нет: этот Submit ждёт
+	waiting := make(chan error)
+	go func() { waiting <- p.Submit(context.Background(), 2) }()
+	time.Sleep(10 * time.Millisecond)
+	p.Close()
+	if err := <-waiting; !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("ждавший Submit: ошибка %v, ожидалась ErrPoolClosed", err)
+	}
+	if err := p.Submit(context.Background(), 3); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Submit после закрытия: ошибка %v, ожидалась ErrPoolClosed", err)
+	}
+	close(release)
+	// без очереди нет и буфера результатов: читаем их, пока пул дорабатывает
+	results := make(chan []int)
+	go func() {
+		var got []int
+		for r := range p.Results() {
+			got = append(got, r)
+		}
+		results <- got
+	}()
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-results; len(got) != 1 || got[0] != 1 {
+		t.Errorf("результаты %v, ожидалась только задача 1", got)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"expvar"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const fakePgPassword = "secret"
+
+// fakePg — сервер PostgreSQL, которого хватает для PostgresSink:
+// проверка пароля SCRAM-SHA-256, простые запросы и COPY FROM STDIN.
+type fakePg struct {
+	ln       net.Listener
+	copyErr  bool // отвечать на COPY ошибкой
+	failCopy int  // ответить ошибкой только на COPY с этим номером, считая с 1
+
+	mu      sync.Mutex
+	queries []string
+	rows    [][]string
+	copies  int // сколько пришло команд COPY
+}
+
+func newFakePg(t *testing.T) *fakePg {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakePg{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakePg) dsn() string {
+	return "postgres://sprint9:" + fakePgPassword + "@" + f.ln.Addr().String() + "/db"
+}
+
+func (f *fakePg) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return
+	}
+	if _, err := r.Discard(int(binary.BigEndian.Uint32(head[:])) - 4); err != nil {
+		return
+	}
+	if !f.auth(conn, r) {
+		fakePgSend(conn, 'E', fakePgError("28P01", "password authentication failed"))
+		return
+	}
+	fakePgSend(conn, 'Z', []byte("I"))
+	for {
+		typ, body, err := fakePgReceive(r)
+		if err != nil || typ == 'X' {
+			return
+		}
+		if typ != 'Q' {
+			continue
+		}
+		query := strings.TrimSuffix(string(body), "\x00")
+		f.mu.Lock()
+		f.queries = append(f.queries, query)
+		if strings.HasPrefix(query, "COPY") {
+			f.copies++
+		}
+		failCopy := f.copies == f.failCopy
+		f.mu.Unlock()
+		switch {
+		case strings.HasPrefix(query, "COPY") && (f.copyErr || failCopy):
+			fakePgSend(conn, 'E', fakePgError("23502", "null value violates not-null constraint"))
+		case strings.HasPrefix(query, "COPY"):
+			if !f.copyIn(conn, r) {
+				return
+			}
+			fakePgSend(conn, 'C', []byte("COPY\x00"))
+		default:
+			fakePgSend(conn, 'C', []byte("OK\x00"))
+		}
+		fakePgSend(conn, 'Z', []byte("I"))
+	}
+}
+
+// auth проводит проверку пароля SCRAM-SHA-256 со стороны сервера.
+func (f *fakePg) auth(conn net.Conn, r *bufio.Reader) bool {
+	fakePgSend(conn, 'R', append(binary.BigEndian.AppendUint32(nil, 10), "SCRAM-SHA-256\x00\x00"...))
+	_, body, err := fakePgReceive(r)
+	mech, rest, _ := strings.Cut(string(body), "\x00")
+	if err != nil || mech != "SCRAM-SHA-256" || len(rest) < 4 {
+		return false
+	}
+	clientFirst := rest[4:]
+	bare := strings.TrimPrefix(clientFirst, "n,,")
+	_, clientNonce, _ := strings.Cut(bare, "r=")
+
+	salt := []byte("sprint9-salt")
+	serverFirst := "r=" + clientNonce + "fake,s=" + base64.StdEncoding.EncodeToString(salt) + ",i=4096"
+	fakePgSend(conn, 'R', append(binary.BigEndian.AppendUint32(nil, 11), serverFirst...))
+	_, body, err = fakePgReceive(r)
+	if err != nil {
+		return false
+	}
+	withoutProof, proof64, _ := strings.Cut(string(body), ",p=")
+	proof, err := base64.StdEncoding.DecodeString(proof64)
+	if err != nil || len(proof) != sha256.Size {
+		return false
+	}
+
+	salted, _ := pbkdf2.Key(sha256.New, fakePgPassword, salt, 4096, sha256.Size)
+	storedKey := sha256.Sum256(pgHMAC(salted, "Client Key"))
+	authMessage := bare + "," + serverFirst + "," + withoutProof
+	clientKey := pgHMAC(storedKey[:], authMessage)
+	for i := range clientKey {
+		clientKey[i] ^= proof[i]
+	}
+	if sum := sha256.Sum256(clientKey); !hmac.Equal(sum[:], storedKey[:]) {
+		return false
+	}
+	serverSig := pgHMAC(pgHMAC(salted, "Server Key"), authMessage)
+	fakePgSend(conn, 'R', append(binary.BigEndian.AppendUint32(nil, 12), "v="+base64.StdEncoding.EncodeToString(serverSig)...))
+	fakePgSend(conn, 'R', binary.BigEndian.AppendUint32(nil, 0))
+	return true
+}
+
+// copyIn принимает строки COPY до сообщения о конце данных.
+func (f *fakePg) copyIn(conn net.Conn, r *bufio.Reader) bool {
+	g := []byte{0}
+	g = binary.BigEndian.AppendUint16(g, uint16(len(itemColumns)))
+	g = append(g, make([]byte, 2*len(itemColumns))...)
+	fakePgSend(conn, 'G', g)
+	var data []byte
+	for {
+		typ, body, err := fakePgReceive(r)
+		if err != nil {
+			return false
+		}
+		switch typ {
+		case 'd':
+			data = append(data, body...)
+		case 'c':
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			for line := range strings.Lines(string(data)) {
+				f.rows = append(f.rows, strings.Split(strings.TrimSuffix(line, "\n"), "\t"))
+			}
+			return true
+		}
+	}
+}
+
+func fakePgSend(w io.Writer, typ byte, body []byte) {
+	msg := append([]byte{typ}, binary.BigEndian.AppendUint32(nil, uint32(len(body)+4))...)
+	w.Write(append(msg, body...))
+}
+
+func fakePgReceive(r *bufio.Reader) (byte, []byte, error) {
+	var head [5]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint32(head[1:])-4)
+	_, err := io.ReadFull(r, body)
+	return head[0], body, err
+}
+
+func fakePgError(code, msg string) []byte {
+	return []byte("SERROR\x00C" + code + "\x00M" + msg + "\x00\x00")
+}
+
+// testStats возвращает счётчики конвейера, не связанные с основным.
+func testStats() *PipelineStats {
+	return newPipelineStats(new(expvar.Map).Init())
+}
+
+// TestPostgresSink проверяет запись пакетами: таблица создаётся
+// и дополняется столбцами, а каждое число приходит одной строкой со
+// всеми столбцами.
+func TestPostgresSink(t *testing.T) {
+	srv := newFakePg(t)
+	s, err := DialPostgres(srv.dsn(), "results", 2, testStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		if err := s.Put(Item{Seq: int64(i + 1), Value: int64(i + 1), Born: time.Now()}); err != nil {
+			t.Fatalf("число %d: %v", i+1, err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.queries) < 2 || !strings.HasPrefix(srv.queries[0], `CREATE TABLE IF NOT EXISTS "results"`) ||
+		!strings.HasPrefix(srv.queries[1], `ALTER TABLE "results" ADD COLUMN IF NOT EXISTS`) {
+		t.Fatalf("запросы %q, ожидались CREATE TABLE и ALTER TABLE", srv.queries)
+	}
+	if len(srv.rows) != 5 {
+		t.Fatalf("записано %d строк, ожидалось 5", len(srv.rows))
+	}
+	for i, row := range srv.rows {
+		if len(row) != len(itemColumns) {
+			t.Fatalf("строка %d: %d столбцов, ожидалось %d: %q", i, len(row), len(itemColumns), row)
+		}
+	}
+}
+
+// TestPostgresSinkError проверяет, что пакет, который сервер отверг,
+// не останавливает запись: его числа переходят из полученных
+// в необработанные, а следующие пакеты записываются. С цепью неудачный
+// пакет ещё и уходит к ней.
+func TestPostgresSinkError(t *testing.T) {
+	srv := newFakePg(t)
+	srv.failCopy = 2
+
+	stats := testStats()
+	s, err := DialPostgres(srv.dsn(), "results", 2, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		// числа до попадания к получателю учитывает сборщик
+		stats.Processed.Add(1)
+		stats.ProcessedSum.Add(int64(i + 1))
+		if err := s.Put(Item{Seq: int64(i + 1), Value: int64(i + 1), Born: time.Now()}); err != nil {
+			t.Errorf("число %d: %v", i+1, err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	// отвергнут второй пакет: числа 3 и 4
+	if n := s.Failed(); n != 2 {
+		t.Errorf("не записано %d чисел, ожидалось 2", n)
+	}
+	if n, sum := stats.Failed.Value(), stats.FailedSum.Value(); n != 2 || sum != 7 {
+		t.Errorf("необработанных %d на сумму %d, ожидалось 2 на сумму 7", n, sum)
+	}
+	if n, sum := stats.Processed.Value(), stats.ProcessedSum.Value(); n != 3 || sum != 8 {
+		t.Errorf("полученных %d на сумму %d, ожидалось 3 на сумму 8", n, sum)
+	}
+	srv.mu.Lock()
+	if len(srv.rows) != 3 {
+		t.Errorf("записано %d строк, ожидалось 3", len(srv.rows))
+	}
+	srv.mu.Unlock()
+
+	srv = newFakePg(t)
+	srv.copyErr = true
+	s, err = DialPostgres(srv.dsn(), "results", 2, testStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed []Item
+	s.Guard(NewBreaker(t.Name(), 1, 10, time.Minute, nil), func(items []Item) {
+		failed = append(failed, items...)
+	})
+	for i := range 3 {
+		if err := s.Put(Item{Seq: int64(i + 1), Born: time.Now()}); err != nil {
+			t.Errorf("Put через цепь: %v", err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close через цепь: %v", err)
+	}
+	if len(failed) != 3 {
+		t.Errorf("цепь получила %d чисел, ожидалось 3", len(failed))
+	}
+	if n := s.Failed(); n != 3 {
+		t.Errorf("не записано %d чисел, ожидалось 3", n)
+	}
+}
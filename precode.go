@@ -2,89 +2,775 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+// HeartbeatInterval — как часто обработчик сообщает монитору, что он жив.
+const HeartbeatInterval = 50 * time.Millisecond
+
+// GeneratorOptions — необязательные настройки генератора.
+type GeneratorOptions struct {
+	// TTL — если больше нуля, число устаревает, пролежав в очереди дольше TTL.
+	TTL time.Duration
+	// Backpressure — через него генератор сообщает, сколько ждёт отправки.
+	Backpressure *Backpressure
+	// Schedule — профиль нагрузки; по умолчанию без ограничения частоты.
+	Schedule Schedule
+	// Speed — скорость воспроизведения записанного ввода; 0 — без пауз.
+	Speed Speed
+	// Memory — если задан, генератор ждёт, пока в пути слишком много чисел
+	// для оставшейся памяти.
+	Memory *MemoryGuard
+	// Control — если задан, генератор стоит, пока он на паузе.
+	Control *Control
+	// Budget — если задан, генератор останавливается, исчерпав его.
+	Budget *Budget
+
+	run RunID // запуск из контекста источника
+}
+
 // Generator генерирует последовательность чисел 1,2,3 и т.д. и
 // отправляет их в канал ch. При этом после записи в канал для каждого числа
 // вызывается функция fn. Она служит для подсчёта количества и суммы
-// сгенерированных чисел.
-func Generator(ctx context.Context, ch chan<- int64, fn func(int64)) {
-	// 1. Функция Generator
-	// ...
+// сгенерированных чисел. Канал ch закрывается в конце; владеть им должен
+// этап генератор.
+func Generator(ctx context.Context, out *ChannelOwner[Item], opts GeneratorOptions, fn func(int64)) {
+	defer out.Close("генератор")
+	ch := out.C
+	run, _ := RunFrom(ctx)
+	opts.run = run.ID
+
+	pace := newPacer(opts.Schedule)
+	for n := range Sequence(ctx) {
+		if !pace.Wait(ctx) || !opts.send(ctx, ch, n, n) {
+			return
+		}
+		fn(n)
+	}
 }
 
-// Worker читает число из канала in и пишет его в канал out.
-func Worker(in <-chan int64, out chan<- int64) {
-	// 2. Функция Worker
-	// ...
+// send отправляет в ch число value с порядковым номером seq.
+// Возвращает false, если контекст ctx отменён раньше.
+func (opts GeneratorOptions) send(ctx context.Context, ch chan<- Item, seq, value int64) bool {
+	if ctx.Err() != nil || !opts.Budget.Allow(value) {
+		return false
+	}
+	it := Item{Seq: seq, Value: value, Trace: NewTraceID(), Born: time.Now(), Run: opts.run}
+	if opts.TTL > 0 {
+		it.Deadline = it.Born.Add(opts.TTL)
+	}
+	if !opts.Control.Wait(ctx) || !opts.Memory.Wait(ctx) {
+		return false
+	}
+	bp := opts.Backpressure
+	bp.Block()
+	select {
+	case <-ctx.Done():
+		return false
+	case ch <- it:
+		bp.Unblock()
+		opts.Budget.Sent(value)
+		tracef(it, "сгенерировано")
+		return true
+	}
 }
 
 func main() {
-	chIn := make(chan int64)
+	args := os.Args[1:]
+	// подкоманда bench сравнивает способы передачи чисел, не запуская конвейер
+	if len(args) > 0 && args[0] == "bench" {
+		if err := RunBench(args[1:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+			log.Fatalf("Ошибка: %v\n", err)
+		}
+		return
+	}
+	// подкоманду soak — тот же конвейер, но с проверками на выносливость —
+	// разбирает ParseConfig
+	cfg, err := ParseConfig(args, os.Environ())
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Ошибка в настройках: %v\n", err)
+	}
+	traceItems = cfg.Trace
+	// сведения о запуске идут через контекст во все этапы, которым
+	// они нужны, а в журнал и метрики попадают сразу
+	run := RunInfo{ID: RunID(cfg.RunID), Label: cfg.Label}
+	if run.ID == 0 {
+		run.ID = NewRunID()
+	}
+	run.Publish()
+	runCtx := WithRun(context.Background(), run)
+	if cfg.DryRun {
+		if err := Plan(os.Stdout, cfg); err != nil {
+			log.Fatalf("Ошибка в настройках:\n%v\n", err)
+		}
+		fmt.Println("Настройки в порядке")
+		return
+	}
+
+	// join работает удалённым обработчиком координатора по адресу addr
+	join := func(addr string) error {
+		conf, err := cfg.TLS().ClientConfig(addr)
+		if err != nil {
+			return err
+		}
+		return JoinCoordinator(addr, LatencyFor(cfg.Latency, 0), conf)
+	}
+	if cfg.Join != "" {
+		// этот процесс — удалённый обработчик: числа генерирует координатор
+		if err := join(cfg.Join); err != nil {
+			log.Fatalf("Ошибка связи с координатором: %v\n", err)
+		}
+		return
+	}
+
+	// при выборах координатором становится тот, кто первым заблокирует файл;
+	// остальные работают обработчиками и переизбираются, если координатор пропал
+	var elected *FileLock
+	for cfg.Elect != "" {
+		elected, err = TryLock(cfg.Elect)
+		if err != nil {
+			log.Fatalf("Ошибка выборов координатора: %v\n", err)
+		}
+		if elected != nil {
+			log.Println("этот процесс выбран координатором")
+			defer elected.Unlock()
+			break
+		}
+		addr, err := ReadLeader(cfg.Elect, 5*time.Second)
+		if err != nil {
+			log.Fatalf("Ошибка выборов координатора: %v\n", err)
+		}
+		log.Printf("координатор работает на %s, подключаемся обработчиком\n", addr)
+		err = join(addr)
+		if err == nil {
+			return
+		}
+		log.Printf("связь с координатором потеряна: %v, переизбираемся\n", err)
+		time.Sleep(rand.N(200 * time.Millisecond))
+	}
+
+	// serverTLS — настройки TLS для всех принимающих подключения серверов
+	serverTLS, err := cfg.TLS().ServerConfig()
+	if err != nil {
+		log.Fatalf("Ошибка загрузки сертификатов: %v\n", err)
+	}
+
+	health := NewHealth(10 * time.Second)
+	http.HandleFunc("/readyz", health.Ready)
+	http.HandleFunc("/livez", health.Live)
+	if cfg.Metrics != "" {
+		token, err := loadSecret(cfg.TokenFile, os.Getenv(EnvToken))
+		if err != nil {
+			log.Fatalf("Ошибка загрузки токена: %v\n", err)
+		}
+		srv := &http.Server{
+			Addr: cfg.Metrics,
+			// expvar сам регистрирует /debug/vars в http.DefaultServeMux
+			Handler:   RequireToken(token, http.DefaultServeMux),
+			TLSConfig: serverTLS,
+		}
+		go func() {
+			if serverTLS != nil {
+				log.Fatal(srv.ListenAndServeTLS("", ""))
+			}
+			log.Fatal(srv.ListenAndServe())
+		}()
+	}
+
+	// в режиме -stdin числа читаются из стандартного ввода, результаты
+	// пишутся в стандартный вывод, а отчёт — в стандартный поток ошибок
+	report := os.Stdout
+	if cfg.Stdin {
+		report = os.Stderr
+	}
 
-	// 3. Создание контекста
-	// ...
+	// ключ шифрования результатов и ввода; без ключа всё идёт открытым текстом
+	aead, err := LoadKey(cfg.KeyFile, os.Getenv(EnvKey))
+	if err != nil {
+		log.Fatalf("Ошибка загрузки ключа: %v\n", err)
+	}
+
+	// именованные конвейеры работают рядом с основным, каждый со своими
+	// счётчиками в pipelines/<имя>; по сигналу их источники тоже
+	// останавливаются, а запускать и останавливать их можно через
+	// /pipelines на сервере метрик
+	namedCtx, namedStop := signal.NotifyContext(runCtx, os.Interrupt, syscall.SIGTERM)
+	defer namedStop()
+	registry := NewRegistry(namedCtx, func(name string, c Config, stats *PipelineStats) (*sinkSet, error) {
+		return openSinks(c, aead, serverTLS, name+"_", stats)
+	})
+	registry.Register(http.DefaultServeMux)
+	for _, name := range slices.Sorted(maps.Keys(cfg.Pipelines)) {
+		if _, err := registry.Start(name, cfg.Pipelines[name]); err != nil {
+			log.Fatalf("Ошибка запуска конвейера: %v\n", err)
+		}
+	}
+
+	// source — этап-источник, единственный, кто закрывает chIn
+	source := "генератор"
+	if cfg.Stdin {
+		source = "stdin"
+	}
+	chIn := NewChannelOwner[Item]("in", source, cfg.Buffer)
+
+	// 3. Создание контекста: источник работает, пока не достигнут любой
+	// из пределов
+	budget := &Budget{
+		Items: cfg.MaxItems,
+		Sum:   cfg.MaxSum,
+		Bytes: cfg.MaxBytes,
+		Time:  time.Duration(cfg.Duration),
+	}
+	if cfg.Stdin {
+		// читаем ввод до конца, сколько бы это ни заняло
+		budget.Time = 0
+	}
+	ctx, cancel := budget.Start(runCtx)
+	defer cancel()
+	// по сигналу останавливаем генератор и дорабатываем то, что уже в пути
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		health.Drain()
+	}()
 
 	// для проверки будем считать количество и сумму отправленных чисел
 	var inputSum int64   // сумма сгенерированных чисел
 	var inputCount int64 // количество сгенерированных чисел
 
 	// генерируем числа, считая параллельно их количество и сумму
-	go Generator(ctx, chIn, func(i int64) {
-		inputSum += i
-		inputCount++
-	})
+	genOpts := GeneratorOptions{
+		TTL:          time.Duration(cfg.TTL),
+		Backpressure: &Backpressure{Threshold: time.Duration(cfg.Stall)},
+		Schedule:     cfg.Schedule,
+		Speed:        cfg.Speed,
+		Memory:       NewMemoryGuard(),
+		Budget:       budget,
+	}
+	// ctl — ручное управление из консоли; им же прогон на выносливость
+	// останавливает источник для проверок
+	var ctl *Control
+	if cfg.Console || cfg.Soak {
+		ctl = NewControl(cfg.Workers)
+		genOpts.Control = ctl
+	}
+	countInput := func(i int64) {
+		atomic.AddInt64(&inputSum, i)
+		atomic.AddInt64(&inputCount, 1)
+		generatedItems.Add(1)
+		sumGenerated.Add(i)
+	}
+	// сторож следит, чтобы конвейер доработал после остановки источника
+	var watchdog *Watchdog
+	if cfg.Watchdog > 0 {
+		watchdog = &Watchdog{Timeout: time.Duration(cfg.Watchdog)}
+	}
+	// sourceDone закрывается, когда источник закончился и новых
+	// обработчиков из консоли уже не запустят
+	sourceDone := make(chan struct{})
+	if cfg.Stdin {
+		go func() {
+			defer close(sourceDone)
+			defer watchdog.GeneratorDone()
+			defer ctl.Release()
+			in, err := OpenStream(os.Stdin, aead)
+			if err == nil {
+				in, err = Decompress(in)
+			}
+			if err == nil {
+				err = ReadSource(ctx, in, chIn, genOpts, countInput)
+			}
+			if err != nil {
+				log.Fatalf("Ошибка чтения ввода: %v\n", err)
+			}
+		}()
+	} else {
+		go func() {
+			defer close(sourceDone)
+			defer watchdog.GeneratorDone()
+			defer ctl.Release()
+			Generator(ctx, chIn, genOpts, countInput)
+		}()
+	}
 
-	const NumOut = 5 // количество обрабатывающих горутин и каналов
-	// outs — слайс каналов, куда будут записываться числа из chIn
-	outs := make([]chan int64, NumOut)
-	for i := 0; i < NumOut; i++ {
-		// создаём каналы и для каждого из них вызываем горутину Worker
-		outs[i] = make(chan int64)
-		go Worker(chIn, outs[i])
+	// монитор следит за сигналами жизни обработчиков, пока не будут
+	// прочитаны все результаты
+	mon := NewMonitor(10 * HeartbeatInterval)
+	// alert сообщает о нарушениях правил сразу, не дожидаясь итоговых проверок
+	alert, err := ParseAlert(cfg.Alert, time.Duration(cfg.WebhookTimeout))
+	if err != nil {
+		log.Fatalf("Ошибка в настройках: %v\n", err)
+	}
+	// супервизор перезапускает обработчиков, зависших на обработке числа
+	supervisor := NewSupervisor()
+	mon.OnStale = func(hb Heartbeat) {
+		alert(Violation{Kind: ViolationStall, Worker: hb.Worker,
+			Detail: fmt.Sprintf("обработчик %d не отвечает, обработано %d", hb.Worker, hb.Processed)})
+		supervisor.Restart(hb)
+	}
+	monCtx, monCancel := context.WithCancel(runCtx)
+	defer monCancel()
+	go mon.Run(monCtx)
+
+	// chExpired — канал для чисел, которые устарели, не дождавшись обработки
+	chExpired := make(chan Item)
+	var expiredCount int64 // количество устаревших чисел
+	var expiredSum int64   // сумма устаревших чисел
+	expiredDone := make(chan struct{})
+	go func() {
+		defer close(expiredDone)
+		for v := range chExpired {
+			expiredCount++
+			expiredSum += v.Value
+			expiredItems.Add(1)
+			sumExpired.Add(v.Value)
+		}
+	}()
+	// числа, которые не удалось обработать или записать, пишутся в файл
+	// dead-letter, если он задан
+	var dead *os.File
+	var deadSink *DeadLetterSink
+	var deadLetter Sink // nil, если файла нет: тогда недоставленные числа только учитываются
+	if cfg.DeadLetter != "" {
+		dead, err = os.Create(cfg.DeadLetter)
+		if err != nil {
+			log.Fatalf("Ошибка создания файла dead-letter: %v\n", err)
+		}
+		deadSink = NewDeadLetterSink(dead)
+		deadLetter = deadSink
+	}
+
+	// chFailed — канал для чисел, обработать которые не удалось, в том
+	// числе из-за разомкнутой цепи
+	chFailed := make(chan Item)
+	var failedCount int64 // количество необработанных чисел
+	var failedSum int64   // сумма необработанных чисел
+	failedDone := make(chan struct{})
+	go func() {
+		defer close(failedDone)
+		for v := range chFailed {
+			failedCount++
+			failedSum += v.Value
+			failedItems.Add(1)
+			sumFailed.Add(v.Value)
+			if deadLetter == nil {
+				continue
+			}
+			if err := deadLetter.Put(v); err != nil {
+				log.Fatalf("Ошибка записи в файл dead-letter: %v\n", err)
+			}
+		}
+	}()
+
+	// NumOut — сколько может быть обрабатывающих горутин и каналов;
+	// сначала запускаются cfg.Workers из них, остальные — командой scale
+	NumOut := cfg.WorkerLimit()
+	// outs — слайс каналов, куда будут записываться числа из chIn;
+	// в распределённом режиме их нет, числа уходят удалённым обработчикам
+	var outs []<-chan Result[Item]
+	// ins — собственные входы обработчиков при раздаче по весам, ключам
+	// или кругами; иначе все обработчики читают из общего chIn
+	var ins []chan Item
+	// workers — местные обработчики, в том числе ещё не запущенные
+	var workers []*Worker
+	// process — обработка числа; при ошибках она может идти через цепь,
+	// общую для всех обработчиков
+	process := Stage(passThrough)
+	var processBreaker *Breaker
+	if cfg.BreakerRate > 0 {
+		processBreaker = NewBreaker("process", cfg.BreakerRate, cfg.BreakerWindow, time.Duration(cfg.BreakerCooldown), alert)
+		process = processBreaker.Stage(process)
+	}
+	if cfg.Coordinator == "" {
+		ins = StartDispatch(cfg, chIn.C, NumOut, ctl)
+		outs = make([]<-chan Result[Item], NumOut)
+		for i := 0; i < NumOut; i++ {
+			in := (<-chan Item)(chIn.C)
+			opts := []WorkerOption{
+				WithDelay(LatencyFor(cfg.Latency, i)),
+				WithProcess(Instrument("process", process)),
+				WithBuffer(cfg.Buffer),
+			}
+			if ins != nil {
+				// свой вход обработчика читать нужно всегда: кто берёт
+				// числа, решает раздатчик
+				in = ins[i]
+			} else {
+				opts = append(opts, WithControl(ctl))
+			}
+			// каждый обработчик работает в своей горутине, каналом outs[i]
+			// служит его выход
+			w := NewWorker(i, in, mon, opts...)
+			supervisor.Watch(w)
+			outs[i] = w.Out()
+			workers = append(workers, w)
+		}
 	}
 
 	// amounts — слайс, в который собирается статистика по горутинам
 	amounts := make([]int64, NumOut)
-	// chOut — канал, в который будут отправляться числа из горутин `outs[i]`
-	chOut := make(chan int64, NumOut)
+	// chOut — канал, в который будут отправляться числа из горутин `outs[i]`;
+	// закрывает его сборщик, а в распределённом режиме — координатор
+	collector := "сборщик"
+	if cfg.Coordinator != "" {
+		collector = "координатор"
+	}
+	chOut := NewChannelOwner[Item]("result", collector, NumOut)
+
+	if cfg.Console {
+		// число удалённых обработчиков координатора отсюда не меняется
+		go RunConsole(os.Stdin, os.Stdout, ctl, cfg.Coordinator == "", func(w io.Writer) {
+			paused, active := ctl.State()
+			state := "работает"
+			if paused {
+				state = "на паузе"
+			}
+			fmt.Fprintf(w, "источник %s: сгенерировано %d, обработано %d, устарело %d, не обработано %d; числа берут обработчиков: %d из %d\n",
+				state, generatedItems.Value(), processedItems.Value(), expiredItems.Value(), failedItems.Value(), active, ctl.Workers(NumOut))
+		}, cancel)
+	}
+
+	// замеряем заполненность каналов между этапами
+	consumer, routing := "обработчики", "shared"
+	switch {
+	case cfg.Coordinator != "":
+		consumer, routing = "координатор", "coordinator"
+	case cfg.Keys > 0:
+		consumer, routing = "раздатчик", "keyed"
+	case cfg.Fair > 0:
+		consumer, routing = "раздатчик", "fair"
+	case ins != nil:
+		consumer, routing = "раздатчик", "weighted"
+	}
+	depths := []*ChannelDepth{WatchChannel("in", source, consumer, chIn.C)}
+	for i, in := range ins {
+		depths = append(depths, WatchChannel("in"+strconv.Itoa(i), "раздатчик", "обработчик "+strconv.Itoa(i), in))
+	}
+	for i, out := range outs {
+		depths = append(depths, WatchChannel("out"+strconv.Itoa(i), "обработчик "+strconv.Itoa(i), "сборщик", out))
+	}
+	depths = append(depths, WatchChannel("result", collector, "получатель", chOut.C))
+	go SampleDepths(monCtx, 10*time.Millisecond, depths)
+	statsDone := make(chan struct{})
+	if cfg.UDP != "" {
+		stats, err := DialStats(cfg.UDP, cfg.UDPFormat)
+		if err != nil {
+			log.Fatalf("Ошибка отправки сводок: %v\n", err)
+		}
+		go func() {
+			defer close(statsDone)
+			stats.Run(monCtx, time.Second)
+		}()
+	} else {
+		close(statsDone)
+	}
+	// drained закрывается, когда все результаты прочитаны
+	drained := make(chan struct{})
+	if watchdog != nil {
+		go watchdog.Run(ctx, drained, mon, depths)
+	}
+	go genOpts.Backpressure.Run(monCtx, depths)
+	if cfg.Soak {
+		soak := &Soak{
+			Interval:     time.Duration(cfg.SoakInterval),
+			MemoryGrowth: cfg.SoakMemory,
+			Goroutines:   cfg.SoakGoroutines,
+		}
+		// выход должен молчать дольше, чем самый медленный обработчик
+		// держит число
+		for i := range NumOut {
+			p := LatencyFor(cfg.Latency, i)
+			soak.Settle = max(soak.Settle, 2*time.Duration(p.Delay+p.Jitter))
+		}
+		go soak.Run(ctx, ctl, mon, depths, alert)
+	}
+	go genOpts.Memory.Run(monCtx, 100*time.Millisecond)
+	go SampleHeap(monCtx, 10*time.Millisecond)
+	// по SIGUSR1 пишем снимок состояния, не прерывая работу
+	go notifyDump(monCtx, func() {
+		w := io.Writer(os.Stderr)
+		if cfg.DumpFile != "" {
+			f, err := os.OpenFile(cfg.DumpFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+			if err != nil {
+				log.Printf("снимок состояния: %v\n", err)
+				return
+			}
+			defer f.Close()
+			w = f
+		}
+		DumpStats(w, mon, depths)
+	})
 
 	var wg sync.WaitGroup
 
-	// 4. Собираем числа из каналов outs
-	// ...
+	// 4. Собираем числа из каналов outs, отделяя устаревшие и необработанные
+	// числа от успехов. Обработчики работают в пуле: задача i — это
+	// обработчик i вместе со сборщиком его результатов, и она заканчивается,
+	// когда собраны все его результаты
+	pool := NewPool(NumOut, NumOut, func(_ int, i int) int {
+		collected := make(chan struct{})
+		go func() {
+			defer close(collected)
+			fanIn(outs[i], chOut.C, chExpired, chFailed, &amounts[i])
+		}()
+		workers[i].Run(runCtx)
+		<-collected
+		return i
+	})
+	// startWorker отдаёт обработчик i пулу; в очереди пула место есть
+	// для каждого обработчика, поэтому Submit не ждёт
+	startWorker := func(i int) {
+		if err := pool.Submit(runCtx, i); err != nil {
+			log.Printf("обработчик %d не запущен: %v\n", i, err)
+		}
+	}
+	for i := 0; i < len(workers) && i < cfg.Workers; i++ {
+		startWorker(i)
+	}
+	if ctl != nil {
+		ctl.Grow(len(workers), startWorker)
+	}
+
+	if cfg.Coordinator != "" {
+		coord, err := ListenCoordinator(cfg.Coordinator, NumOut, cfg.LeaseSize, time.Duration(cfg.LeaseTTL), serverTLS)
+		if err != nil {
+			log.Fatalf("Ошибка запуска координатора: %v\n", err)
+		}
+		log.Printf("координатор ждёт обработчиков на %v\n", coord.Addr())
+		if elected != nil {
+			if err := elected.Advertise(advertiseAddr(cfg, coord.Addr())); err != nil {
+				log.Fatalf("Ошибка выборов координатора: %v\n", err)
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			coord.Run(chIn.C, chOut, chExpired, amounts, mon)
+		}()
+	}
 
 	go func() {
-		// ждём завершения работы всех горутин для outs
+		// ждём завершения работы всех горутин для outs; пока источник
+		// работает, из консоли могут запустить новые
+		<-sourceDone
+		pool.Close()
+		for range pool.Results() {
+		}
 		wg.Wait()
-		// закрываем результирующий канал
-		close(chOut)
+		// закрываем результирующий канал; обработчики завершились,
+		// значит, устаревших и необработанных чисел больше не будет
+		if cfg.Coordinator == "" {
+			chOut.Close("сборщик")
+		}
+		watchdog.FanInClosed()
+		close(chExpired)
+		close(chFailed)
 	}()
 
-	var count int64 // количество чисел результирующего канала
-	var sum int64   // сумма чисел результирующего канала
+	// sinks — получатели результатов
+	set, err := openSinks(cfg, aead, serverTLS, "", mainStats)
+	if err != nil {
+		log.Fatalf("Ошибка получателя результатов: %v\n", err)
+	}
+	sinks, tuners, s3, webhook, pg := set.sinks, set.tuners, set.s3, set.webhook, set.pg
+
+	// с цепями ошибки получателей не останавливают конвейер: числа,
+	// которые не удалось записать, уходят в файл dead-letter
+	var breakerSinks []*BreakerSink
+	if cfg.BreakerRate > 0 {
+		for i, s := range sinks {
+			b := NewBreaker(sinkName(s), cfg.BreakerRate, cfg.BreakerWindow, time.Duration(cfg.BreakerCooldown), alert)
+			bs := NewBreakerSink(s, b, deadLetter)
+			sinks[i] = bs
+			breakerSinks = append(breakerSinks, bs)
+		}
+	}
+
+	// устройство конвейера строится по его работающим частям при каждом
+	// запросе, поэтому видно и то, что поменяли из консоли
+	topology := func() Topology {
+		return BuildTopology(source, routing, cfg.Ordered, workers[:ctl.Workers(len(workers))], depths, sinks, ctl)
+	}
+	http.HandleFunc("/topology", TopologyHandler(topology))
+	if cfg.Topology != "" {
+		if err := WriteTopology(cfg.Topology, topology()); err != nil {
+			log.Fatalf("Ошибка записи устройства конвейера: %v\n", err)
+		}
+	}
+
+	results := (<-chan Item)(chOut.C)
+	if cfg.Ordered {
+		results = Reorder(chOut.C, NumOut, cfg.ReorderBuffer)
+	}
 
 	// 5. Читаем числа из результирующего канала
-	// ...
+	collect := NewCollector(NumOut)
+	collect.Ordered, collect.Sinks, collect.Budget = cfg.Ordered, sinks, budget
+	collect.Alert, collect.Generated = alert, &inputCount
+	if err := collect.Collect(FromChan(results)); err != nil {
+		log.Fatalf("Ошибка записи результатов: %v\n", err)
+	}
+	count, sum := collect.Count, collect.Sum
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			log.Fatalf("Ошибка записи результатов: %v\n", err)
+		}
+	}
+	<-expiredDone
+	<-failedDone
+	if dead != nil {
+		if err := errors.Join(deadSink.Close(), dead.Close()); err != nil {
+			log.Fatalf("Ошибка записи в файл dead-letter: %v\n", err)
+		}
+	}
+	close(drained)
+	monCancel()
+	<-statsDone
+	// процесс заканчивается, когда доработают и именованные конвейеры
+	registry.Close()
 
-	fmt.Println("Количество чисел", inputCount, count)
-	fmt.Println("Сумма чисел", inputSum, sum)
-	fmt.Println("Разбивка по каналам", amounts)
+	fmt.Fprintln(report, "Запуск", run)
+	fmt.Fprintln(report, "Остановка:", StopReason(ctx))
+	fmt.Fprintln(report, "Количество чисел", inputCount, count+expiredCount+failedCount)
+	fmt.Fprintln(report, "Сумма чисел", inputSum, sum+expiredSum+failedSum)
+	// ещё не запущенные обработчики в разбивку не входят
+	started := ctl.Workers(NumOut)
+	fmt.Fprintln(report, "Разбивка по каналам", amounts[:started])
+	if cfg.Schedule.Shape != "" {
+		fmt.Fprintln(report, "Профиль нагрузки", cfg.Schedule)
+	}
+	// при раздаче по ключам доли задаёт кольцо, по весам — веса,
+	// а иначе — скорость обработчиков
+	shares := ExpectedShares(cfg.Latency, started)
+	switch {
+	case cfg.Keys > 0:
+		shares = KeyShares(cfg.Keys, cfg.Weights, started)
+	case len(cfg.Weights) > 0:
+		shares = WeightedShares(cfg.Weights, started)
+	case cfg.Fair > 0:
+		// круги выравнивают доли с точностью до одного круга
+		shares = WeightedShares(nil, started)
+	}
+	if len(cfg.Latency) > 0 || len(cfg.Weights) > 0 || cfg.Keys > 0 || cfg.Fair > 0 {
+		expected := make([]int64, started)
+		for i, share := range shares {
+			expected[i] = int64(math.Round(share * float64(count)))
+		}
+		fmt.Fprintln(report, "Ожидаемая разбивка", expected)
+	}
+	// удалённые обработчики получают числа арендами, и их задержки
+	// координатору неизвестны, поэтому проверяем только местных; из консоли
+	// число обработчиков могли менять на ходу, и тогда доли не те
+	if f, ok := CheckFairness(amounts[:started], shares); ok && cfg.Coordinator == "" && !cfg.Console && f.Unfair() {
+		slog.Warn("разбивка по обработчикам неравномерна",
+			"chi2", math.Round(f.ChiSquared*10)/10, "critical", math.Round(f.Critical*10)/10,
+			"worker", f.Worst, "deviation", math.Round(f.Deviation*1000)/1000)
+	}
+	if expiredCount > 0 {
+		fmt.Fprintln(report, "Устаревшие числа", expiredCount)
+	}
+	if failedCount > 0 {
+		fmt.Fprintln(report, "Необработанные числа", failedCount)
+	}
+	if processBreaker != nil && processBreaker.Skipped() > 0 {
+		fmt.Fprintln(report, "Из них не обработано из-за разомкнутой цепи", processBreaker.Skipped())
+	}
+	if collect.Late > 0 {
+		fmt.Fprintln(report, "Опоздавшие числа", collect.Late)
+	}
+	if collect.Retried > 0 {
+		fmt.Fprintln(report, "Выданные заново числа", collect.Retried)
+	}
+	if webhook != nil && webhook.Failed() > 0 {
+		fmt.Fprintln(report, "Не отправлено на webhook", webhook.Failed())
+	}
+	if pg != nil && pg.Failed() > 0 {
+		fmt.Fprintln(report, "Не записано в PostgreSQL", pg.Failed())
+	}
+	if s3 != nil && len(s3.Failed()) > 0 {
+		fmt.Fprintln(report, "Не загружено в S3", s3.Failed())
+	}
+	for _, s := range breakerSinks {
+		if s.Failed() > 0 {
+			fmt.Fprintf(report, "Не записано в %s из-за ошибок и разомкнутой цепи %d\n", sinkName(s), s.Failed())
+		}
+	}
+	for _, t := range tuners {
+		last, smallest, largest := t.Range()
+		fmt.Fprintf(report, "Размер пакета %s: последний %d, от %d до %d\n", t.name, last, smallest, largest)
+	}
+	if missed := mon.Missed(); missed > 0 {
+		fmt.Fprintln(report, "Пропущенные сигналы жизни", missed)
+	}
+	for _, hb := range mon.Slowest(cfg.Slow) {
+		fmt.Fprintf(report, "Обработчик %d: ожидание отправки %v, обработка %v\n",
+			hb.Worker, hb.Blocked.Round(time.Millisecond), hb.Busy.Round(time.Millisecond))
+	}
+	for _, p := range registry.Pipelines() {
+		st := p.Status()
+		fmt.Fprintf(report, "Конвейер %s: %s, %s; сгенерировано %d, обработано %d, устарело %d, не обработано %d\n",
+			st.Name, st.State, st.Stop, st.Generated, st.Processed, st.Expired, st.Failed)
+	}
+	// расход памяти показывает, как на скорость влияет устройство чисел
+	mem := ReadMemorySummary()
+	fmt.Fprintln(report, "Пиковая куча", mem.HeapPeak, "байт")
+	fmt.Fprintln(report, "Выделено памяти", mem.TotalAlloc, "байт в", mem.Mallocs, "объектах")
+	fmt.Fprintf(report, "Сборки мусора %d, паузы %v, наибольшая %v\n",
+		mem.NumGC, mem.PauseTotal.Round(time.Microsecond), mem.PauseMax.Round(time.Microsecond))
 
-	// проверка результатов
-	if inputSum != sum {
-		log.Fatalf("Ошибка: суммы чисел не равны: %d != %d\n", inputSum, sum)
+	// проверка результатов: устаревшие и необработанные числа не потеряны,
+	// а учтены отдельно
+	receivedCount, receivedSum := count+expiredCount+failedCount, sum+expiredSum+failedSum
+	if inputSum != receivedSum || inputCount != receivedCount {
+		alert(Violation{Kind: ViolationChecksum, Worker: -1,
+			Detail: fmt.Sprintf("сгенерировано %d чисел на сумму %d, получено %d на сумму %d",
+				inputCount, inputSum, receivedCount, receivedSum)})
+	}
+	if inputSum != receivedSum {
+		log.Fatalf("Ошибка: суммы чисел не равны: %d != %d\n", inputSum, receivedSum)
 	}
-	if inputCount != count {
-		log.Fatalf("Ошибка: количество чисел не равно: %d != %d\n", inputCount, count)
+	if inputCount != receivedCount {
+		log.Fatalf("Ошибка: количество чисел не равно: %d != %d\n", inputCount, receivedCount)
+	}
+	if collect.Disorder != 0 {
+		log.Fatalf("Ошибка: обработчики нарушили порядок чисел %d раз\n", collect.Disorder)
 	}
 	for _, v := range amounts {
 		inputCount -= v
 	}
-	if inputCount != 0 {
+	if inputCount != expiredCount+failedCount {
 		log.Fatalf("Ошибка: разделение чисел по каналам неверное\n")
 	}
+	for i := range amounts {
+		if amounts[i] != collect.ByWorker[i] || amounts[i] != mon.Processed(i) {
+			log.Fatalf("Ошибка: обработчик %d: по каналу прошло %d чисел, помечено %d, обработано %d\n",
+				i, amounts[i], collect.ByWorker[i], mon.Processed(i))
+		}
+	}
+	for _, p := range registry.Pipelines() {
+		if err := p.Wait(); err != nil {
+			log.Fatalf("Ошибка: конвейер %s: %v\n", p.Name(), err)
+		}
+	}
 }
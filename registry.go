@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// namedMetrics — счётчики именованных конвейеров, каждый в своём разделе
+// под его именем.
+var namedMetrics = expvar.NewMap("pipelines")
+
+// Состояния именованного конвейера.
+const (
+	PipelineStarting = "starting" // подключаются получатели
+	PipelineRunning  = "running"  // источник выдаёт числа
+	PipelinePaused   = "paused"   // источник стоит на паузе
+	PipelineStopping = "stopping" // источник остановлен, конвейер дорабатывает
+	PipelineDone     = "done"     // конвейер доработал, суммы сошлись
+	PipelineFailed   = "failed"   // конвейер доработал с ошибкой
+)
+
+// NamedPipeline — конвейер, запущенный в реестре под своим именем. У него
+// свои источник, обработчики, получатели и счётчики; основной конвейер
+// и другие именованные на него не влияют.
+type NamedPipeline struct {
+	name  string
+	cfg   Config
+	stats *PipelineStats
+	ctl   *Control
+	done  chan struct{} // закрывается, когда конвейер доработал
+
+	mu      sync.Mutex
+	state   string
+	started time.Time
+	stop    context.CancelFunc // останавливает источник
+	reason  string             // почему закончился источник
+	err     error              // итог работы; задан, когда done закрыт
+}
+
+// PipelineStatus — состояние именованного конвейера в API управления.
+type PipelineStatus struct {
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Workers   int       `json:"workers"`
+	Started   time.Time `json:"started"`
+	Generated int64     `json:"generated"`
+	Processed int64     `json:"processed"`
+	Expired   int64     `json:"expired"`
+	Failed    int64     `json:"failed"`
+	Stop      string    `json:"stop,omitempty"`  // почему закончился источник
+	Error     string    `json:"error,omitempty"` // с какой ошибкой доработал конвейер
+}
+
+// Name возвращает имя конвейера.
+func (p *NamedPipeline) Name() string {
+	return p.name
+}
+
+// Status возвращает текущее состояние конвейера и его счётчики.
+func (p *NamedPipeline) Status() PipelineStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := PipelineStatus{
+		Name:      p.name,
+		State:     p.state,
+		Workers:   p.cfg.Workers,
+		Started:   p.started,
+		Generated: p.stats.Generated.Value(),
+		Processed: p.stats.Processed.Value(),
+		Expired:   p.stats.Expired.Value(),
+		Failed:    p.stats.Failed.Value(),
+		Stop:      p.reason,
+	}
+	if p.err != nil {
+		st.Error = p.err.Error()
+	}
+	return st
+}
+
+// Pause ставит источник конвейера на паузу.
+func (p *NamedPipeline) Pause() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state != PipelineRunning && p.state != PipelinePaused {
+		return fmt.Errorf("конвейер %s не работает: %s", p.name, p.state)
+	}
+	p.ctl.Pause()
+	p.state = PipelinePaused
+	return nil
+}
+
+// Resume снимает источник конвейера с паузы.
+func (p *NamedPipeline) Resume() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state != PipelineRunning && p.state != PipelinePaused {
+		return fmt.Errorf("конвейер %s не работает: %s", p.name, p.state)
+	}
+	p.ctl.Resume()
+	p.state = PipelineRunning
+	return nil
+}
+
+// Stop останавливает источник конвейера; то, что уже в пути,
+// дорабатывается. Дождаться конца работы можно через Wait.
+func (p *NamedPipeline) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop != nil {
+		p.stop()
+	}
+	if p.state != PipelineDone && p.state != PipelineFailed {
+		p.state = PipelineStopping
+	}
+}
+
+// Wait ждёт, пока конвейер доработает, и возвращает его ошибку.
+func (p *NamedPipeline) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// finished сообщает, доработал ли конвейер.
+func (p *NamedPipeline) finished() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// setState переводит конвейер в состояние state, если его ещё не
+// остановили.
+func (p *NamedPipeline) setState(state string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state != PipelineStopping {
+		p.state = state
+	}
+}
+
+// run запускает конвейер с получателями sinks и ждёт, пока он
+// доработает. Источник работает, пока не достигнут предел, не отменён
+// ctx или не вызван Stop.
+func (p *NamedPipeline) run(ctx context.Context, sinks *sinkSet) (err error) {
+	cfg := p.cfg
+	defer func() {
+		for _, s := range sinks.sinks {
+			if cerr := s.Close(); cerr != nil && err == nil {
+				err = fmt.Errorf("запись результатов: %w", cerr)
+			}
+		}
+	}()
+
+	// обработчики, сборщик и монитор дорабатывают то, что уже в пути,
+	// и после остановки источника
+	base := context.WithoutCancel(ctx)
+	budget := &Budget{Items: cfg.MaxItems, Sum: cfg.MaxSum, Time: time.Duration(cfg.Duration)}
+	ctx, cancel := budget.Start(ctx)
+	defer cancel()
+	p.mu.Lock()
+	p.stop = cancel
+	if p.state == PipelineStopping {
+		cancel()
+	} else {
+		p.state = PipelineRunning
+	}
+	p.mu.Unlock()
+
+	alert, err := ParseAlert(cfg.Alert, time.Duration(cfg.WebhookTimeout))
+	if err != nil {
+		return err
+	}
+	chIn := NewChannelOwner[Item]("in", "генератор", cfg.Buffer)
+	var inputCount, inputSum int64
+	genOpts := GeneratorOptions{
+		TTL:      time.Duration(cfg.TTL),
+		Schedule: cfg.Schedule,
+		Control:  p.ctl,
+		Budget:   budget,
+	}
+	go func() {
+		defer p.ctl.Release()
+		Generator(ctx, chIn, genOpts, func(v int64) {
+			atomic.AddInt64(&inputSum, v)
+			atomic.AddInt64(&inputCount, 1)
+			p.stats.Generated.Add(1)
+			p.stats.GeneratedSum.Add(v)
+		})
+		p.mu.Lock()
+		p.reason = StopReason(ctx)
+		p.mu.Unlock()
+		p.setState(PipelineStopping)
+	}()
+
+	mon := NewMonitor(10 * HeartbeatInterval)
+	supervisor := NewSupervisor()
+	mon.OnStale = func(hb Heartbeat) {
+		alert(Violation{Kind: ViolationStall, Worker: hb.Worker,
+			Detail: fmt.Sprintf("конвейер %s: обработчик %d не отвечает, обработано %d", p.name, hb.Worker, hb.Processed)})
+		supervisor.Restart(hb)
+	}
+	monCtx, monCancel := context.WithCancel(base)
+	defer monCancel()
+	go mon.Run(monCtx)
+
+	n := cfg.Workers
+	ins := StartDispatch(cfg, chIn.C, n, p.ctl)
+	chOut := NewChannelOwner[Item]("result", "сборщик", n)
+	chExpired, chFailed := make(chan Item), make(chan Item)
+	amounts := make([]int64, n)
+	// как и в основном конвейере, обработчик вместе со сборщиком его
+	// результатов — задача пула
+	pool := NewPool(n, n, func(_ int, w *Worker) int {
+		collected := make(chan struct{})
+		go func() {
+			defer close(collected)
+			fanIn(w.Out(), chOut.C, chExpired, chFailed, &amounts[w.id])
+		}()
+		w.Run(base)
+		<-collected
+		return w.id
+	})
+	for i := range n {
+		in := (<-chan Item)(chIn.C)
+		if ins != nil {
+			in = ins[i]
+		}
+		w := NewWorker(i, in, mon, WithDelay(LatencyFor(cfg.Latency, i)), WithBuffer(cfg.Buffer))
+		supervisor.Watch(w)
+		if err := pool.Submit(base, w); err != nil {
+			return err
+		}
+	}
+	pool.Close()
+	go func() {
+		for range pool.Results() {
+		}
+		chOut.Close("сборщик")
+		close(chExpired)
+		close(chFailed)
+	}()
+
+	var expiredCount, expiredSum, failedCount, failedSum int64
+	var lost sync.WaitGroup
+	// tally считает устаревшие или необработанные числа из ch
+	tally := func(ch <-chan Item, num, sum *int64, items, sums *expvar.Int) {
+		defer lost.Done()
+		for v := range ch {
+			*num++
+			*sum += v.Value
+			items.Add(1)
+			sums.Add(v.Value)
+		}
+	}
+	lost.Add(2)
+	go tally(chExpired, &expiredCount, &expiredSum, p.stats.Expired, p.stats.ExpiredSum)
+	go tally(chFailed, &failedCount, &failedSum, p.stats.Failed, p.stats.FailedSum)
+
+	results := (<-chan Item)(chOut.C)
+	if cfg.Ordered {
+		results = Reorder(chOut.C, n, cfg.ReorderBuffer)
+	}
+	collect := NewCollector(n)
+	collect.Ordered, collect.Sinks, collect.Budget = cfg.Ordered, sinks.sinks, budget
+	collect.Alert, collect.Generated, collect.Stats = alert, &inputCount, p.stats
+	if err := collect.Collect(FromChan(results)); err != nil {
+		// получатель отказал: останавливаем источник и дочитываем то,
+		// что уже в пути, чтобы горутины конвейера завершились
+		cancel()
+		for range results {
+		}
+		lost.Wait()
+		return fmt.Errorf("запись результатов: %w", err)
+	}
+	lost.Wait()
+
+	received, receivedSum := collect.Count+expiredCount+failedCount, collect.Sum+expiredSum+failedSum
+	switch {
+	case received != inputCount || receivedSum != inputSum:
+		err = fmt.Errorf("сгенерировано %d чисел на сумму %d, получено %d на сумму %d",
+			inputCount, inputSum, received, receivedSum)
+		alert(Violation{Kind: ViolationChecksum, Worker: -1, Detail: fmt.Sprintf("конвейер %s: %v", p.name, err)})
+		return err
+	case collect.Disorder != 0:
+		return fmt.Errorf("обработчики нарушили порядок чисел %d раз", collect.Disorder)
+	}
+	return nil
+}
+
+// Registry — именованные конвейеры процесса. Их запускают из настроек
+// и через API управления, а обращаются к ним по именам.
+type Registry struct {
+	ctx  context.Context
+	open func(name string, cfg Config, stats *PipelineStats) (*sinkSet, error)
+
+	mu        sync.Mutex
+	pipelines map[string]*NamedPipeline
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewRegistry создаёт реестр, конвейеры которого работают, пока не
+// отменён ctx. Получателей конвейера подключает open; неудачи записи они
+// учитывают в stats конвейера.
+func NewRegistry(ctx context.Context, open func(name string, cfg Config, stats *PipelineStats) (*sinkSet, error)) *Registry {
+	return &Registry{ctx: ctx, open: open, pipelines: make(map[string]*NamedPipeline)}
+}
+
+// Start запускает конвейер name с настройками cfg. Конвейер с тем же
+// именем можно запустить снова, только когда прежний доработал; его
+// счётчики при этом начинаются с нуля.
+func (r *Registry) Start(name string, cfg Config) (*NamedPipeline, error) {
+	if err := ValidatePipelineName(name); err != nil {
+		return nil, err
+	}
+	if err := cfg.ValidateNamed(); err != nil {
+		return nil, fmt.Errorf("конвейер %s: %w", name, err)
+	}
+	m := new(expvar.Map).Init()
+	p := &NamedPipeline{
+		name:    name,
+		cfg:     cfg,
+		stats:   newPipelineStats(m),
+		ctl:     NewControl(cfg.Workers),
+		done:    make(chan struct{}),
+		state:   PipelineStarting,
+		started: time.Now(),
+	}
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil, errors.New("процесс завершается, новые конвейеры не запускаются")
+	}
+	prev := r.pipelines[name]
+	if prev != nil && !prev.finished() {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("конвейер %s уже работает", name)
+	}
+	r.pipelines[name] = p
+	r.wg.Add(1)
+	r.mu.Unlock()
+
+	// получатели подключаются вне блокировки: это может занять время,
+	// а реестр тем временем должен отвечать
+	sinks, err := r.open(name, cfg, p.stats)
+	if err != nil {
+		r.mu.Lock()
+		if prev != nil {
+			r.pipelines[name] = prev
+		} else {
+			delete(r.pipelines, name)
+		}
+		r.mu.Unlock()
+		r.wg.Done()
+		return nil, fmt.Errorf("конвейер %s: %w", name, err)
+	}
+
+	m.Set("state", expvar.Func(func() any { return p.Status().State }))
+	namedMetrics.Set(name, m)
+
+	go func() {
+		defer r.wg.Done()
+		err := p.run(r.ctx, sinks)
+		p.mu.Lock()
+		p.err = err
+		p.state = PipelineDone
+		if err != nil {
+			p.state = PipelineFailed
+		}
+		p.mu.Unlock()
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// Pipeline возвращает конвейер name или nil, если такого нет.
+func (r *Registry) Pipeline(name string) *NamedPipeline {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pipelines[name]
+}
+
+// Pipelines возвращает все конвейеры в порядке имён.
+func (r *Registry) Pipelines() []*NamedPipeline {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ps := make([]*NamedPipeline, 0, len(r.pipelines))
+	for _, p := range r.pipelines {
+		ps = append(ps, p)
+	}
+	slices.SortFunc(ps, func(a, b *NamedPipeline) int { return strings.Compare(a.name, b.name) })
+	return ps
+}
+
+// Close запрещает запускать новые конвейеры и ждёт, пока доработают
+// уже запущенные.
+func (r *Registry) Close() {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+// Register добавляет в mux API управления конвейерами:
+//
+//	GET  /pipelines                 — состояние всех конвейеров
+//	GET  /pipelines/{name}          — состояние конвейера name
+//	PUT  /pipelines/{name}          — запустить конвейер name с настройками в JSON из тела запроса
+//	POST /pipelines/{name}/{action} — pause, resume или stop
+func (r *Registry) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /pipelines", func(w http.ResponseWriter, req *http.Request) {
+		ps := r.Pipelines()
+		sts := make([]PipelineStatus, len(ps))
+		for i, p := range ps {
+			sts[i] = p.Status()
+		}
+		writeJSON(w, http.StatusOK, sts)
+	})
+	mux.HandleFunc("GET /pipelines/{name}", func(w http.ResponseWriter, req *http.Request) {
+		p := r.Pipeline(req.PathValue("name"))
+		if p == nil {
+			http.NotFound(w, req)
+			return
+		}
+		writeJSON(w, http.StatusOK, p.Status())
+	})
+	mux.HandleFunc("PUT /pipelines/{name}", func(w http.ResponseWriter, req *http.Request) {
+		data, err := io.ReadAll(http.MaxBytesReader(w, req.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cfg, err := DecodeNamedConfig(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name := req.PathValue("name")
+		if p := r.Pipeline(name); p != nil && !p.finished() {
+			http.Error(w, fmt.Sprintf("конвейер %s уже работает", name), http.StatusConflict)
+			return
+		}
+		p, err := r.Start(name, cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, p.Status())
+	})
+	mux.HandleFunc("POST /pipelines/{name}/{action}", func(w http.ResponseWriter, req *http.Request) {
+		p := r.Pipeline(req.PathValue("name"))
+		if p == nil {
+			http.NotFound(w, req)
+			return
+		}
+		var err error
+		switch action := req.PathValue("action"); action {
+		case "pause":
+			err = p.Pause()
+		case "resume":
+			err = p.Resume()
+		case "stop":
+			p.Stop()
+		default:
+			http.Error(w, fmt.Sprintf("неизвестное действие %q: нужно pause, resume или stop", action), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusOK, p.Status())
+	})
+}
+
+// writeJSON отвечает кодом code и значением v в JSON.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
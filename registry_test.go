@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// namedConfig возвращает настройки небольшого именованного конвейера.
+func namedConfig(workers int, items int64) Config {
+	cfg := DefaultConfig()
+	cfg.Workers, cfg.Duration, cfg.MaxItems = workers, 0, items
+	cfg.Latency = map[int]LatencyProfile{}
+	for i := range workers {
+		cfg.Latency[i] = LatencyProfile{}
+	}
+	return cfg
+}
+
+func newTestRegistry(t *testing.T) *Registry {
+	r := NewRegistry(t.Context(), func(name string, cfg Config, stats *PipelineStats) (*sinkSet, error) {
+		return openSinks(cfg, nil, nil, name+"_", stats)
+	})
+	t.Cleanup(r.Close)
+	return r
+}
+
+// TestRegistryIsolated запускает два конвейера с разными настройками
+// и проверяет, что каждый считает только свои числа, а счётчики
+// основного конвейера не меняются.
+func TestRegistryIsolated(t *testing.T) {
+	r := newTestRegistry(t)
+	before := processedItems.Value()
+
+	a := namedConfig(2, 300)
+	b := namedConfig(3, 500)
+	b.Ordered, b.Keys = true, 8
+	b.Parquet = filepath.Join(t.TempDir(), "b.parquet")
+	for name, cfg := range map[string]Config{"a": a, "b": b} {
+		if _, err := r.Start(name, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]int64{"a": 300, "b": 500} {
+		p := r.Pipeline(name)
+		if err := p.Wait(); err != nil {
+			t.Fatalf("конвейер %s: %v", name, err)
+		}
+		st := p.Status()
+		if st.State != PipelineDone || st.Generated != want || st.Processed != want {
+			t.Errorf("конвейер %s: %s, сгенерировано %d, обработано %d; ожидалось done и %d", name, st.State, st.Generated, st.Processed, want)
+		}
+	}
+	if got := processedItems.Value(); got != before {
+		t.Errorf("счётчик основного конвейера изменился с %d на %d", before, got)
+	}
+	if info, err := os.Stat(b.Parquet); err != nil || info.Size() == 0 {
+		t.Errorf("получатель конвейера b ничего не записал: %v", err)
+	}
+
+	// доработавший конвейер запускается снова со счётчиками с нуля
+	p, err := r.Start("a", namedConfig(1, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Wait(); err != nil || p.Status().Processed != 10 {
+		t.Errorf("перезапуск: обработано %d, ошибка %v; ожидалось 10", p.Status().Processed, err)
+	}
+}
+
+// TestRegistryLifecycle проверяет паузу, остановку и то, что работающий
+// конвейер нельзя запустить второй раз.
+func TestRegistryLifecycle(t *testing.T) {
+	r := newTestRegistry(t)
+	cfg := namedConfig(2, 0)
+	cfg.Duration = Duration(time.Minute)
+	p, err := r.Start("long", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Start("long", cfg); err == nil {
+		t.Error("работающий конвейер запущен второй раз")
+	}
+	for p.Status().State == PipelineStarting {
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	// число, которое источник уже готовил, может успеть уйти
+	time.Sleep(20 * time.Millisecond)
+	paused := p.Status().Generated
+	time.Sleep(50 * time.Millisecond)
+	if got := p.Status().Generated; got != paused {
+		t.Errorf("на паузе источник выдал ещё %d чисел", got-paused)
+	}
+	if err := p.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	p.Stop()
+	done := make(chan error)
+	go func() { done <- p.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("конвейер не доработал после остановки")
+	}
+	if st := p.Status(); st.State != PipelineDone || st.Generated != st.Processed {
+		t.Errorf("после остановки: %s, сгенерировано %d, обработано %d", st.State, st.Generated, st.Processed)
+	}
+}
+
+// TestRegistryAPI проверяет запуск конвейера, управление им и его
+// состояние через HTTP.
+func TestRegistryAPI(t *testing.T) {
+	r := newTestRegistry(t)
+	mux := http.NewServeMux()
+	r.Register(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	do := func(method, path, body string) (int, PipelineStatus) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var st PipelineStatus
+		json.NewDecoder(resp.Body).Decode(&st)
+		return resp.StatusCode, st
+	}
+
+	if code, _ := do("PUT", "/pipelines/api", `{"workers": 2, "duration": "1m"}`); code != http.StatusCreated {
+		t.Fatalf("запуск: код %d", code)
+	}
+	if code, _ := do("PUT", "/pipelines/api", `{"workers": 2, "duration": "1m"}`); code != http.StatusConflict {
+		t.Errorf("повторный запуск: код %d, ожидался %d", code, http.StatusConflict)
+	}
+	for _, body := range []string{`{"workers": 2, "stdin": true}`, `{"workers": 2, "nope": 1}`, `{"workers": 0}`} {
+		if code, _ := do("PUT", "/pipelines/bad", body); code != http.StatusBadRequest {
+			t.Errorf("настройки %s: код %d, ожидался %d", body, code, http.StatusBadRequest)
+		}
+	}
+	if code, _ := do("GET", "/pipelines/bad", ""); code != http.StatusNotFound {
+		t.Errorf("неизвестный конвейер: код %d, ожидался %d", code, http.StatusNotFound)
+	}
+	for r.Pipeline("api").Status().State == PipelineStarting {
+		time.Sleep(time.Millisecond)
+	}
+	if code, st := do("POST", "/pipelines/api/pause", ""); code != http.StatusOK || st.State != PipelinePaused {
+		t.Errorf("пауза: код %d, состояние %s", code, st.State)
+	}
+	if code, st := do("POST", "/pipelines/api/stop", ""); code != http.StatusOK || st.State != PipelineStopping {
+		t.Errorf("остановка: код %d, состояние %s", code, st.State)
+	}
+	if err := r.Pipeline("api").Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if code, st := do("GET", "/pipelines/api", ""); code != http.StatusOK || st.State != PipelineDone {
+		t.Errorf("после остановки: код %d, состояние %s", code, st.State)
+	}
+}
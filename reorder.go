@@ -0,0 +1,125 @@
+package main
+
+// Reorder восстанавливает общий порядок чисел, прошедших через workers
+// обработчиков, по их порядковым номерам Seq. Числа придерживаются в буфере,
+// пока не придут все предыдущие.
+// Буфер ограничен workers*size числами: если один обработчик сильно отстаёт,
+// меньшие числа из буфера отправляются дальше, не дожидаясь его, а числа
+// отстающего обработчика потом идут вне очереди с пометкой Late.
+func Reorder(in <-chan Item, workers, size int) <-chan Item {
+	out := make(chan Item)
+	go func() {
+		defer close(out)
+
+		r := newReorderBuffer(workers, size)
+		emit := func(it Item) { out <- it }
+		for it := range in {
+			r.Push(it, emit)
+		}
+		r.Flush(emit)
+	}()
+	return out
+}
+
+// reorderBuffer — буфер, который выдаёт числа по возрастанию.
+type reorderBuffer struct {
+	next  int64   // следующий ожидаемый порядковый номер
+	last  []int64 // последний порядковый номер от каждого обработчика
+	limit int     // сколько чисел можно держать в буфере
+	items itemHeap
+}
+
+func newReorderBuffer(workers, size int) *reorderBuffer {
+	return &reorderBuffer{
+		next:  1,
+		last:  make([]int64, workers),
+		limit: workers * size,
+		// числа кладутся в буфер до того, как лишние уходят дальше,
+		// поэтому в нём бывает на одно больше limit
+		items: make(itemHeap, 0, workers*size+1),
+	}
+}
+
+// Push кладёт число в буфер и передаёт в emit все числа, которые уже
+// можно отправить дальше.
+func (r *reorderBuffer) Push(it Item, emit func(Item)) {
+	r.last[it.Worker] = it.Seq
+	if it.Seq < r.next {
+		// меньшие числа уже ушли дальше, ждать бесполезно
+		it.Late = true
+		emit(it)
+		return
+	}
+	r.items.push(it)
+
+	// каждый обработчик выдаёт числа по порядку, поэтому числа с номерами
+	// не больше watermark уже не могут прийти ни от кого из них
+	watermark := r.last[0]
+	for _, v := range r.last[1:] {
+		watermark = min(watermark, v)
+	}
+	for len(r.items) > 0 {
+		top := r.items[0]
+		if top.Seq != r.next && top.Seq > watermark && len(r.items) <= r.limit {
+			break
+		}
+		r.pop(emit)
+	}
+}
+
+// Flush отправляет в emit все оставшиеся в буфере числа.
+func (r *reorderBuffer) Flush(emit func(Item)) {
+	for len(r.items) > 0 {
+		r.pop(emit)
+	}
+}
+
+func (r *reorderBuffer) pop(emit func(Item)) {
+	it := r.items.pop()
+	r.next = it.Seq + 1
+	emit(it)
+}
+
+// itemHeap — минимальная куча чисел по Seq. Она написана без
+// container/heap: там числа передаются через any, и каждое выделяло бы
+// память.
+type itemHeap []Item
+
+// push кладёт число в кучу.
+func (h *itemHeap) push(it Item) {
+	*h = append(*h, it)
+	s := *h
+	for i := len(s) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if s[parent].Seq <= s[i].Seq {
+			break
+		}
+		s[i], s[parent] = s[parent], s[i]
+		i = parent
+	}
+}
+
+// pop забирает из кучи число с наименьшим Seq.
+func (h *itemHeap) pop() Item {
+	s := *h
+	top := s[0]
+	n := len(s) - 1
+	s[0] = s[n]
+	s = s[:n]
+	for i := 0; ; {
+		least := i
+		if l := 2*i + 1; l < n && s[l].Seq < s[least].Seq {
+			least = l
+		}
+		if r := 2*i + 2; r < n && s[r].Seq < s[least].Seq {
+			least = r
+		}
+		if least == i {
+			break
+		}
+		s[i], s[least] = s[least], s[i]
+		i = least
+	}
+	*h = s
+	return top
+}
@@ -0,0 +1,15 @@
+package main
+
+import "errors"
+
+// ErrExpired — число устарело, не дождавшись обработки.
+var ErrExpired = errors.New("число устарело")
+
+// Result — итог обработки: значение либо ошибка вместе с номером
+// обработчика. Успехи и неудачи идут по одним и тем же каналам, а
+// сборщик разделяет их по Err.
+type Result[T any] struct {
+	Value  T     // обработанное значение; при ошибке — исходное
+	Err    error // почему обработка не удалась; nil — успех
+	Worker int   // номер обработчика
+}
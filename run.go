@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+)
+
+// RunID — идентификатор запуска. Он всегда положительный, чтобы без
+// изменений ложиться в столбцы int64 табличных выгрузок.
+type RunID int64
+
+// NewRunID возвращает новый случайный идентификатор запуска.
+func NewRunID() RunID {
+	return RunID(rand.Int64N(math.MaxInt64) + 1)
+}
+
+// RunInfo — сведения о запуске, по которым его результаты, журналы
+// и метрики можно отличить от результатов других запусков, одновременных
+// или повторных.
+type RunInfo struct {
+	ID    RunID  `json:"id"`
+	Label string `json:"label,omitempty"` // метка эксперимента
+}
+
+func (r RunInfo) String() string {
+	if r.Label == "" {
+		return fmt.Sprintf("run=%d", r.ID)
+	}
+	return fmt.Sprintf("run=%d label=%s", r.ID, r.Label)
+}
+
+type runKey struct{}
+
+// WithRun возвращает контекст со сведениями о запуске r.
+func WithRun(ctx context.Context, r RunInfo) context.Context {
+	return context.WithValue(ctx, runKey{}, r)
+}
+
+// RunFrom возвращает сведения о запуске из контекста ctx, если они там есть.
+func RunFrom(ctx context.Context) (RunInfo, bool) {
+	r, ok := ctx.Value(runKey{}).(RunInfo)
+	return r, ok
+}
+
+var (
+	runID    = newCounter("run_id")
+	runLabel = new(expvar.String)
+)
+
+func init() {
+	metrics.Set("run_label", runLabel)
+}
+
+// Publish выставляет сведения о запуске в metrics и в начало каждой
+// записи журнала, в том числе записей slog.
+func (r RunInfo) Publish() {
+	runID.Set(int64(r.ID))
+	runLabel.Set(r.Label)
+	log.SetPrefix(r.String() + " ")
+	log.SetFlags(log.Flags() | log.Lmsgprefix)
+}
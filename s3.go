@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3PartSize   = 8 << 20         // размер части при загрузке по частям, не меньше 5 МиБ
+	s3Attempts   = 5               // сколько раз пытаться выполнить запрос
+	s3MaxBackoff = 5 * time.Second // наибольшая пауза между попытками
+	s3Timeout    = 5 * time.Minute // сколько ждать ответа на запрос
+	s3Queue      = 4               // сколько сегментов может ждать загрузки
+	s3TimeLayout = "20060102T150405Z"
+)
+
+var (
+	s3Uploaded = newCounter("s3_uploaded") // сколько сегментов загружено
+	s3Failed   = newCounter("s3_failed")   // сколько сегментов загрузить не удалось
+)
+
+// S3Sink пишет значения чисел по одному в строке в локальные сегменты.
+// Когда сегмент дорастает до заданного размера, а также при закрытии
+// он загружается в бакет S3-совместимого хранилища и удаляется с диска;
+// сегменты больше s3PartSize загружаются по частям. Загрузка идёт в фоне,
+// но если в очереди уже s3Queue сегментов, запись ждёт её. Сегмент,
+// который так и не удалось загрузить, остаётся на диске и учитывается
+// в Failed.
+type S3Sink struct {
+	client   *S3Client
+	prefix   string
+	segment  int64
+	aead     cipher.AEAD
+	compress string
+	started  string // время запуска в именах объектов
+
+	f       *os.File
+	line    *LineSink
+	written int64 // сколько байт записано в текущий сегмент
+	n       int   // номер текущего сегмента
+
+	queue  chan s3Segment
+	done   chan struct{}
+	failed []string // пути незагруженных сегментов
+}
+
+// s3Segment — записанный сегмент, который ждёт загрузки.
+type s3Segment struct {
+	key  string
+	path string
+}
+
+// NewS3Sink создаёт получателя, который загружает сегменты размером около
+// segment байт в хранилище по адресу dest вида s3://bucket/prefix.
+// Если aead не nil, сегменты шифруются; сжимаются они по compress.
+func NewS3Sink(dest, endpoint, region string, segment int64, aead cipher.AEAD, compress string) (*S3Sink, error) {
+	bucket, prefix, err := parseS3Dest(dest)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewS3Client(endpoint, bucket, region)
+	if err != nil {
+		return nil, err
+	}
+	s := &S3Sink{
+		client:   client,
+		prefix:   prefix,
+		segment:  segment,
+		aead:     aead,
+		compress: compress,
+		started:  time.Now().UTC().Format(s3TimeLayout),
+		queue:    make(chan s3Segment, s3Queue),
+		done:     make(chan struct{}),
+	}
+	go s.upload()
+	return s, nil
+}
+
+// parseS3Dest разбирает адрес вида s3://bucket/prefix. Непустой префикс
+// возвращается с «/» на конце.
+func parseS3Dest(dest string) (bucket, prefix string, err error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf("адрес хранилища должен иметь вид s3://bucket/prefix")
+	}
+	prefix = strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return u.Host, prefix, nil
+}
+
+// Write записывает в текущий сегмент, считая записанное.
+func (s *S3Sink) Write(p []byte) (int, error) {
+	n, err := s.f.Write(p)
+	s.written += int64(n)
+	return n, err
+}
+
+// Put записывает значение числа it и при необходимости начинает
+// новый сегмент.
+func (s *S3Sink) Put(it Item) error {
+	if s.f == nil {
+		f, err := os.CreateTemp("", "sprint9-*.seg")
+		if err != nil {
+			return err
+		}
+		s.f, s.written, s.n = f, 0, s.n+1
+		s.line = NewLineSink(NewSealWriter(s, s.aead), s.compress)
+	}
+	if err := s.line.Put(it); err != nil {
+		return err
+	}
+	if s.written < s.segment {
+		return nil
+	}
+	return s.rotate()
+}
+
+// rotate закрывает текущий сегмент и ставит его в очередь на загрузку.
+func (s *S3Sink) rotate() error {
+	err := s.line.Close()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	path := s.f.Name()
+	s.f, s.line = nil, nil
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s%s-%06d.txt", s.prefix, s.started, s.n)
+	switch s.compress {
+	case "gzip":
+		key += ".gz"
+	case "zstd":
+		key += ".zst"
+	}
+	s.queue <- s3Segment{key: key, path: path}
+	return nil
+}
+
+// upload загружает сегменты из очереди, пока она не закрыта.
+func (s *S3Sink) upload() {
+	defer close(s.done)
+	for seg := range s.queue {
+		if err := s.client.Upload(seg.key, seg.path); err != nil {
+			log.Printf("s3: сегмент %s не загружен и остался в %s: %v\n", seg.key, seg.path, err)
+			s.failed = append(s.failed, seg.path)
+			s3Failed.Add(1)
+			continue
+		}
+		os.Remove(seg.path)
+		s3Uploaded.Add(1)
+	}
+}
+
+// Failed возвращает пути сегментов, которые загрузить не удалось.
+// Вызывать его можно только после Close.
+func (s *S3Sink) Failed() []string {
+	return s.failed
+}
+
+// Close загружает последний сегмент и ждёт окончания загрузки остальных.
+func (s *S3Sink) Close() error {
+	var err error
+	if s.f != nil {
+		err = s.rotate()
+	}
+	close(s.queue)
+	<-s.done
+	return err
+}
+
+// S3Client загружает объекты в бакет S3-совместимого хранилища,
+// подписывая запросы по AWS Signature Version 4. Ключи доступа берутся
+// из переменных окружения AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+// и, если она задана, AWS_SESSION_TOKEN.
+type S3Client struct {
+	endpoint *url.URL
+	bucket   string
+	region   string
+	key      string
+	secret   string
+	token    string
+	http     *http.Client
+}
+
+// NewS3Client создаёт клиента бакета bucket в хранилище endpoint.
+// Объекты адресуются путём: endpoint/bucket/key.
+func NewS3Client(endpoint, bucket, region string) (*S3Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("адрес хранилища %q должен начинаться с http:// или https://", endpoint)
+	}
+	c := &S3Client{
+		endpoint: u,
+		bucket:   bucket,
+		region:   region,
+		key:      os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret:   os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:    os.Getenv("AWS_SESSION_TOKEN"),
+		http:     &http.Client{Timeout: s3Timeout},
+	}
+	if c.key == "" || c.secret == "" {
+		return nil, errors.New("не заданы AWS_ACCESS_KEY_ID и AWS_SECRET_ACCESS_KEY")
+	}
+	return c, nil
+}
+
+// Upload загружает файл path объектом key: небольшой — одним запросом,
+// больше s3PartSize — по частям.
+func (c *S3Client) Upload(key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() <= s3PartSize {
+		body, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		_, _, err = c.do(http.MethodPut, key, nil, body)
+		return err
+	}
+	return c.uploadParts(key, f)
+}
+
+// s3Part — часть объекта в запросе на завершение загрузки.
+type s3Part struct {
+	Number int    `xml:"PartNumber"`
+	ETag   string `xml:"ETag"`
+}
+
+// uploadParts загружает r объектом key по частям. Если загрузить
+// не удалось, начатая загрузка отменяется, чтобы хранилище не держало
+// уже загруженные части.
+func (c *S3Client) uploadParts(key string, r io.Reader) error {
+	_, resp, err := c.do(http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(resp, &initiated); err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("s3: не удалось начать загрузку по частям: %v", err)
+	}
+	id := initiated.UploadID
+
+	err = func() error {
+		var parts []s3Part
+		buf := make([]byte, s3PartSize)
+		for n := 1; ; n++ {
+			size, err := io.ReadFull(r, buf)
+			if err == io.EOF {
+				break
+			}
+			if err != nil && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			q := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {id}}
+			h, _, err := c.do(http.MethodPut, key, q, buf[:size])
+			if err != nil {
+				return err
+			}
+			parts = append(parts, s3Part{Number: n, ETag: h.Get("ETag")})
+		}
+		body, err := xml.Marshal(struct {
+			XMLName xml.Name `xml:"CompleteMultipartUpload"`
+			Parts   []s3Part `xml:"Part"`
+		}{Parts: parts})
+		if err != nil {
+			return err
+		}
+		_, _, err = c.do(http.MethodPost, key, url.Values{"uploadId": {id}}, body)
+		return err
+	}()
+	if err != nil {
+		if _, _, aerr := c.do(http.MethodDelete, key, url.Values{"uploadId": {id}}, nil); aerr != nil {
+			log.Printf("s3: не удалось отменить загрузку %s: %v\n", key, aerr)
+		}
+	}
+	return err
+}
+
+// do выполняет подписанный запрос к объекту key и возвращает заголовки
+// и тело ответа. Сетевые ошибки и ответы 5xx повторяются с нарастающими
+// паузами, остальные ошибки возвращаются сразу.
+func (c *S3Client) do(method, key string, query url.Values, body []byte) (http.Header, []byte, error) {
+	sum := sha256.Sum256(body)
+	payload := hex.EncodeToString(sum[:])
+	u := *c.endpoint
+	u.Path = "/" + c.bucket + "/" + key
+	u.RawPath = "/" + s3Escape(c.bucket, false) + "/" + s3Escape(key, true)
+	u.RawQuery = s3Query(query)
+
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		c.sign(req, payload, time.Now())
+		resp, err := c.http.Do(req)
+		var data []byte
+		if err == nil {
+			data, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		switch {
+		case err != nil:
+		case resp.StatusCode >= 500:
+			err = fmt.Errorf("s3: %s %s: %s", method, key, resp.Status)
+		case resp.StatusCode >= 300:
+			return nil, nil, fmt.Errorf("s3: %s %s: %s: %s", method, key, resp.Status, bytes.TrimSpace(data))
+		case bytes.Contains(data, []byte("<Error>")):
+			// завершение загрузки по частям может сообщить об ошибке
+			// в теле ответа 200 OK
+			err = fmt.Errorf("s3: %s %s: %s", method, key, bytes.TrimSpace(data))
+		default:
+			return resp.Header, data, nil
+		}
+		if attempt == s3Attempts {
+			return nil, nil, err
+		}
+		log.Printf("s3: %v, повторяем\n", err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, s3MaxBackoff)
+	}
+}
+
+// sign подписывает запрос req с телом, хеш которого payload,
+// по AWS Signature Version 4 на момент now.
+func (c *S3Client) sign(req *http.Request, payload string, now time.Time) {
+	stamp := now.UTC().Format(s3TimeLayout)
+	day := stamp[:8]
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if c.token != "" {
+		req.Header.Set("X-Amz-Security-Token", c.token)
+	}
+
+	names := []string{"host"}
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		value := req.Host
+		if name != "host" {
+			value = strings.TrimSpace(req.Header.Get(name))
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers.String(),
+		signed,
+		payload,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+	scope := day + "/" + c.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := s3HMAC([]byte("AWS4"+c.secret), day)
+	key = s3HMAC(key, c.region)
+	key = s3HMAC(key, "s3")
+	key = s3HMAC(key, "aws4_request")
+	signature := hex.EncodeToString(s3HMAC(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.key, scope, signed, signature))
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Query возвращает параметры q, упорядоченные и экранированные так,
+// как того требует подпись.
+func s3Query(q url.Values) string {
+	names := make([]string, 0, len(q))
+	for name := range q {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		for _, v := range q[name] {
+			parts = append(parts, s3Escape(name, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape экранирует в s всё, кроме латинских букв, цифр и «-_.~»,
+// а если keepSlash, то и кроме «/».
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', keepSlash && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule — профиль нагрузки: с какой частотой генератор выдаёт числа.
+// Пустой Shape означает, что генератор работает без ограничения частоты.
+type Schedule struct {
+	Shape  string   `json:"shape"`  // constant, ramp, square или sine
+	Rate   float64  `json:"rate"`   // базовая частота, чисел в секунду
+	Peak   float64  `json:"peak"`   // наибольшая частота для ramp, square и sine
+	Period Duration `json:"period"` // период для square и sine, время разгона для ramp
+}
+
+// Validate проверяет, что профиль нагрузки задан корректно.
+func (s Schedule) Validate() error {
+	switch s.Shape {
+	case "":
+		return nil
+	case "constant":
+		if s.Rate <= 0 {
+			return fmt.Errorf("rate должна быть больше нуля")
+		}
+		return nil
+	case "ramp", "square", "sine":
+	default:
+		return fmt.Errorf("неизвестный профиль %q", s.Shape)
+	}
+	if s.Rate < 0 || s.Peak <= 0 {
+		return fmt.Errorf("rate не может быть отрицательной, а peak должна быть больше нуля")
+	}
+	if s.Period <= 0 {
+		return fmt.Errorf("period должен быть больше нуля")
+	}
+	return nil
+}
+
+// RateAt возвращает частоту через elapsed после начала работы.
+func (s Schedule) RateAt(elapsed time.Duration) float64 {
+	period := time.Duration(s.Period)
+	switch s.Shape {
+	case "constant":
+		return s.Rate
+	case "ramp":
+		k := min(1, float64(elapsed)/float64(period))
+		return s.Rate + (s.Peak-s.Rate)*k
+	case "square":
+		if elapsed%period < period/2 {
+			return s.Peak
+		}
+		return s.Rate
+	case "sine":
+		phase := 2 * math.Pi * float64(elapsed%period) / float64(period)
+		return s.Rate + (s.Peak-s.Rate)*(1+math.Sin(phase))/2
+	}
+	return 0
+}
+
+func (s Schedule) String() string {
+	switch s.Shape {
+	case "":
+		return "без ограничения"
+	case "constant":
+		return fmt.Sprintf("constant %g/с", s.Rate)
+	case "ramp":
+		return fmt.Sprintf("ramp %g→%g/с за %v", s.Rate, s.Peak, time.Duration(s.Period))
+	}
+	return fmt.Sprintf("%s %g…%g/с, период %v", s.Shape, s.Rate, s.Peak, time.Duration(s.Period))
+}
+
+// Speed — во сколько раз быстрее записи воспроизводить ввод: промежутки
+// между числами по их записанному времени рождения делятся на Speed.
+// 0 означает без пауз, как можно быстрее.
+type Speed float64
+
+// ParseSpeed читает скорость вида 2x, 0.5x, 2 или max.
+func ParseSpeed(s string) (Speed, error) {
+	if s == "max" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || v <= 0 || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("скорость %q: ожидалось число больше нуля с x, например 2x или 0.5x, или max", s)
+	}
+	return Speed(v), nil
+}
+
+func (s Speed) String() string {
+	if s == 0 {
+		return "max"
+	}
+	return strconv.FormatFloat(float64(s), 'g', -1, 64) + "x"
+}
+
+// Set разбирает скорость из флага.
+func (s *Speed) Set(v string) error {
+	sp, err := ParseSpeed(v)
+	if err != nil {
+		return err
+	}
+	*s = sp
+	return nil
+}
+
+// MarshalJSON записывает скорость строкой вида "2x" или "max".
+func (s Speed) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON читает скорость из строки вида "2x" или "max".
+func (s *Speed) UnmarshalJSON(b []byte) error {
+	var v string
+	if err := json.Unmarshal(b, &v); err != nil {
+		return fmt.Errorf("скорость должна быть строкой: %w", err)
+	}
+	return s.Set(v)
+}
+
+// maxPaceLag — насколько генератор может отставать от профиля нагрузки,
+// чтобы потом нагнать его.
+const maxPaceLag = 50 * time.Millisecond
+
+// pacer выдерживает паузы между числами согласно профилю нагрузки или,
+// при воспроизведении записи, согласно записанному времени рождения чисел.
+type pacer struct {
+	schedule Schedule
+	start    time.Time
+	next     time.Time // когда можно выдать следующее число
+	speed    Speed     // скорость воспроизведения записи; 0 — без пауз
+	first    time.Time // записанное время рождения первого числа
+}
+
+func newPacer(s Schedule) *pacer {
+	now := time.Now()
+	return &pacer{schedule: s, start: now, next: now}
+}
+
+// Wait ждёт, пока профиль нагрузки позволит выдать следующее число.
+// Возвращает false, если контекст ctx отменён раньше.
+func (p *pacer) Wait(ctx context.Context) bool {
+	if p.schedule.Shape == "" {
+		return true
+	}
+	now := time.Now()
+	rate := p.schedule.RateAt(now.Sub(p.start))
+	if rate <= 0 {
+		// при нулевой частоте ждём, пока профиль снова её поднимет
+		rate = 1
+	}
+	// небольшое отставание наверстываем, но не копим «долг», если генератор
+	// надолго отстал от профиля
+	if p.next.Before(now.Add(-maxPaceLag)) {
+		p.next = now
+	}
+	p.next = p.next.Add(time.Duration(float64(time.Second) / rate))
+	return sleepCtx(ctx, p.next.Sub(now))
+}
+
+// Replay ждёт, пока с начала воспроизведения пройдёт столько же времени,
+// сколько прошло от рождения первого записанного числа до born, делённого
+// на скорость. Без скорости или без записанного времени не ждёт. Возвращает
+// false, если контекст ctx отменён раньше.
+func (p *pacer) Replay(ctx context.Context, born time.Time) bool {
+	if p.speed == 0 || born.IsZero() {
+		return true
+	}
+	if p.first.IsZero() {
+		p.first, p.start = born, time.Now()
+		return true
+	}
+	at := p.start.Add(time.Duration(float64(born.Sub(p.first)) / float64(p.speed)))
+	return sleepCtx(ctx, time.Until(at))
+}
+
+// sleepCtx ждёт d. Возвращает false, если контекст ctx отменён раньше.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"iter"
+)
+
+// Sequence возвращает ту же последовательность 1,2,3 и т.д., что и Generator,
+// но в виде итератора: по ней можно пройти обычным for ... range без
+// горутин и каналов. Последовательность заканчивается, когда отменён
+// контекст ctx.
+func Sequence(ctx context.Context) iter.Seq[int64] {
+	return func(yield func(int64) bool) {
+		for n := int64(1); ctx.Err() == nil; n++ {
+			if !yield(n) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// TestSequence проверяет, что итератор выдаёт 1, 2, 3 и т.д., позволяет
+// прервать цикл и каждый новый проход начинает с единицы.
+func TestSequence(t *testing.T) {
+	seq := Sequence(context.Background())
+	for range 2 {
+		var got []int64
+		for n := range seq {
+			got = append(got, n)
+			if len(got) == 5 {
+				break
+			}
+		}
+		for i, n := range got {
+			if n != int64(i+1) {
+				t.Fatalf("последовательность %v, ожидалось 1..5", got)
+			}
+		}
+	}
+}
+
+// TestSequenceCancel проверяет, что последовательность заканчивается,
+// как только отменён контекст, и после отмены пуста.
+func TestSequenceCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var last int64
+	for n := range Sequence(ctx) {
+		last = n
+		if n == 100 {
+			cancel()
+		}
+	}
+	if last != 100 {
+		t.Errorf("последнее число %d, ожидалось 100", last)
+	}
+	for n := range Sequence(ctx) {
+		t.Fatalf("после отмены выдано число %d", n)
+	}
+}
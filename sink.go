@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Sink — получатель результатов конвейера.
+type Sink interface {
+	// Put передаёт получателю очередное число.
+	Put(it Item) error
+	// Close дописывает всё, что осталось в буферах, и освобождает ресурсы.
+	Close() error
+}
+
+// LineSink пишет значения чисел по одному в строке.
+type LineSink struct {
+	w       *bufio.Writer
+	z       compressor
+	buf     []byte // строка с очередным числом, переиспользуется
+	counted bool   // учитывать записанное в sink_bytes
+	timed   bool   // писать через пробел время рождения числа в микросекундах
+}
+
+// NewLineSink создаёт получателя, который пишет в w со сжатием compress
+// (пустое — без сжатия).
+func NewLineSink(w io.Writer, compress string) *LineSink {
+	z := newCompressor(w, compress)
+	return &LineSink{w: bufio.NewWriter(z), z: z, counted: true}
+}
+
+// Put записывает значение числа it отдельной строкой.
+func (s *LineSink) Put(it Item) error {
+	s.buf = strconv.AppendInt(s.buf[:0], it.Value, 10)
+	if s.timed {
+		s.buf = append(s.buf, ' ')
+		s.buf = strconv.AppendInt(s.buf, it.Born.UnixMicro(), 10)
+	}
+	s.buf = append(s.buf, '\n')
+	n, err := s.w.Write(s.buf)
+	if s.counted {
+		sinkBytes.Add(int64(n))
+	}
+	return err
+}
+
+// Close дописывает буферизованные строки и завершает сжатый поток.
+// Сам w не закрывается.
+func (s *LineSink) Close() error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	return s.z.Close()
+}
+
+// sinkSet — получатели результатов, подключённые по настройкам.
+type sinkSet struct {
+	sinks   []Sink
+	tuners  []*BatchTuner // подбор размера пакетов у получателей, которые пишут пакетами
+	s3      *S3Sink
+	webhook *WebhookSink
+	pg      *PostgresSink
+}
+
+// openSinks подключает получателей результатов, заданных в cfg. Результаты
+// шифруются ключом aead, если он есть, а TCP-сервер работает с serverTLS.
+// prefix дописывается к именам подборщиков размера пакетов, чтобы их
+// счётчики у разных конвейеров не смешивались; в stats получатели
+// учитывают числа, которые не смогли записать. Если какого-то получателя
+// подключить не удалось, уже подключённые закрываются.
+func openSinks(cfg Config, aead cipher.AEAD, serverTLS *tls.Config, prefix string, stats *PipelineStats) (_ *sinkSet, err error) {
+	set := &sinkSet{}
+	defer func() {
+		if err != nil {
+			for _, s := range set.sinks {
+				s.Close()
+			}
+		}
+	}()
+	if cfg.Stdin {
+		line := NewLineSink(NewSealWriter(os.Stdout, aead), cfg.Compress)
+		line.timed = cfg.Timed
+		set.sinks = append(set.sinks, line)
+	}
+	if cfg.Listen != "" {
+		feed, err := ListenFeed(cfg.Listen, cfg.ListenBuffer, aead, serverTLS, cfg.Compress)
+		if err != nil {
+			return nil, fmt.Errorf("запуск TCP-сервера: %w", err)
+		}
+		log.Printf("результаты раздаются на %v\n", feed.Addr())
+		set.sinks = append(set.sinks, feed)
+	}
+	if cfg.Socket != "" {
+		sock, err := DialSocket(cfg.Socket, aead, cfg.Compress)
+		if err != nil {
+			return nil, fmt.Errorf("подключение к сокету: %w", err)
+		}
+		set.sinks = append(set.sinks, sock)
+	}
+	if cfg.Parquet != "" {
+		pq, err := CreateParquet(cfg.Parquet)
+		if err != nil {
+			return nil, fmt.Errorf("создание файла Parquet: %w", err)
+		}
+		set.sinks = append(set.sinks, pq)
+	}
+	if cfg.Arrow != "" {
+		arrow, err := CreateArrow(cfg.Arrow)
+		if err != nil {
+			return nil, fmt.Errorf("открытие потока Arrow: %w", err)
+		}
+		set.sinks = append(set.sinks, arrow)
+	}
+	if cfg.Postgres != "" {
+		pg, err := DialPostgres(cfg.Postgres, cfg.PostgresTable, cfg.PostgresBatch, stats)
+		if err != nil {
+			return nil, fmt.Errorf("подключение к PostgreSQL: %w", err)
+		}
+		if cfg.BatchLatency > 0 {
+			t := NewBatchTuner(prefix+"postgres", time.Duration(cfg.BatchLatency), cfg.PostgresBatch)
+			set.tuners = append(set.tuners, t)
+			pg.Tune(t)
+		}
+		set.pg = pg
+		set.sinks = append(set.sinks, pg)
+	}
+	if cfg.S3 != "" {
+		s3, err := NewS3Sink(cfg.S3, cfg.S3Endpoint, cfg.S3Region, cfg.S3Segment, aead, cfg.Compress)
+		if err != nil {
+			return nil, fmt.Errorf("настройка S3: %w", err)
+		}
+		set.s3 = s3
+		set.sinks = append(set.sinks, s3)
+	}
+	if cfg.Webhook != "" {
+		set.webhook = NewWebhookSink(cfg.Webhook, cfg.WebhookBatch, time.Duration(cfg.WebhookTimeout))
+		if cfg.BatchLatency > 0 {
+			t := NewBatchTuner(prefix+"webhook", time.Duration(cfg.BatchLatency), cfg.WebhookBatch)
+			set.tuners = append(set.tuners, t)
+			set.webhook.Tune(t)
+		}
+		set.sinks = append(set.sinks, set.webhook)
+	}
+	return set, nil
+}
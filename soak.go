@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"time"
+)
+
+const (
+	soakPoll        = 10 * time.Millisecond  // как часто проверять, опустел ли конвейер
+	soakSettle      = 200 * time.Millisecond // сколько на выходе ничего не должно меняться, не меньше
+	soakDrain       = 30 * time.Second       // сколько ждать, пока конвейер опустеет
+	soakMemorySlack = 8 << 20                // рост кучи, который не считается утечкой
+)
+
+// Soak — прогон на выносливость. Каждые Interval он ставит источник
+// на паузу, ждёт, пока конвейер опустеет, и проверяет, что всё
+// сгенерированное получено, устарело или не обработано — и по количеству,
+// и по сумме, — что куча после сборки мусора выросла не больше чем
+// в MemoryGrowth раз и что горутин стало не больше чем на Goroutines
+// по сравнению с первой проверкой. На первом же отклонении он сообщает
+// о нарушении, пишет снимок состояния и завершает процесс.
+//
+// Опустевшим конвейер считается не по этим же счётчикам, а когда источник
+// стоит, каналы между этапами пусты и на выходе Settle ничего не
+// меняется: Settle должно быть больше самой долгой обработки, чтобы
+// ни одно число не оставалось у обработчика или раздатчика. Поэтому
+// потерянное число видно как расхождение счётчиков источника и выхода,
+// а не как конвейер, который никак не опустеет.
+type Soak struct {
+	Interval     time.Duration
+	MemoryGrowth float64
+	Goroutines   int
+	Settle       time.Duration // не меньше soakSettle
+
+	checks     int
+	heap       uint64 // куча при первой проверке
+	goroutines int    // горутины при первой проверке
+}
+
+// Run проверяет конвейер, пока не отменён контекст ctx или не закончился
+// источник. Источник ставится на паузу через ctl.
+func (s *Soak) Run(ctx context.Context, ctl *Control, mon *Monitor, depths []*ChannelDepth, alert AlertHook) {
+	tick := time.NewTicker(s.Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		if ctl.Released() {
+			return
+		}
+		ctl.Pause()
+		v, ok := s.check(ctx, ctl, depths)
+		ctl.Resume()
+		if !ok {
+			return
+		}
+		if v != nil {
+			DumpStats(os.Stderr, mon, depths)
+			alert(*v)
+			log.Fatalf("Ошибка: прогон на выносливость, проверка %d: %v\n", s.checks, v)
+		}
+	}
+}
+
+// check ждёт, пока конвейер опустеет, и проверяет его. Возвращает false,
+// если ждать пришлось до конца работы.
+func (s *Soak) check(ctx context.Context, ctl *Control, depths []*ChannelDepth) (*Violation, bool) {
+	s.checks++
+	settle := max(s.Settle, soakSettle)
+	start := time.Now()
+	received, changed := receivedItems(), start
+	for {
+		now := time.Now()
+		if r := receivedItems(); r != received {
+			received, changed = r, now
+		}
+		if ctl.Parked() && channelsEmpty(depths) && now.Sub(changed) >= settle {
+			break
+		}
+		if now.Sub(start) > soakDrain {
+			return &Violation{Kind: ViolationDrain, Worker: -1,
+				Detail: fmt.Sprintf("конвейер не опустел за %v", soakDrain)}, true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-time.After(soakPoll):
+		}
+	}
+	if ctl.Released() {
+		// источник закончился, дальше проверяет сам конвейер
+		return nil, false
+	}
+
+	generated := generatedItems.Value()
+	sum, receivedSum := sumGenerated.Value(), sumProcessed.Value()+sumExpired.Value()+sumFailed.Value()
+	if generated != received || sum != receivedSum {
+		return &Violation{Kind: ViolationChecksum, Worker: -1,
+			Detail: fmt.Sprintf("сгенерировано %d чисел на сумму %d, получено %d на сумму %d",
+				generated, sum, received, receivedSum)}, true
+	}
+
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	goroutines := runtime.NumGoroutine()
+	if s.checks == 1 {
+		s.heap, s.goroutines = ms.HeapAlloc, goroutines
+	}
+	if ms.HeapAlloc > soakMemorySlack+uint64(float64(s.heap)*s.MemoryGrowth) {
+		return &Violation{Kind: ViolationMemory, Worker: -1,
+			Detail: fmt.Sprintf("куча выросла с %d до %d байт", s.heap, ms.HeapAlloc)}, true
+	}
+	if goroutines > s.goroutines+s.Goroutines {
+		return &Violation{Kind: ViolationGoroutines, Worker: -1,
+			Detail: fmt.Sprintf("горутин стало %d, а было %d", goroutines, s.goroutines)}, true
+	}
+	log.Printf("soak: проверка %d пройдена: %d чисел, куча %d байт, горутин %d\n",
+		s.checks, generated, ms.HeapAlloc, goroutines)
+	return nil, true
+}
+
+// receivedItems возвращает, сколько чисел дошло до выхода конвейера:
+// получено, устарело или не обработано.
+func receivedItems() int64 {
+	return processedItems.Value() + expiredItems.Value() + failedItems.Value()
+}
+
+// channelsEmpty сообщает, были ли пусты все каналы depths при последнем
+// замере.
+func channelsEmpty(depths []*ChannelDepth) bool {
+	for _, d := range depths {
+		if cur, _ := d.Depth(); cur != 0 {
+			return false
+		}
+	}
+	return true
+}
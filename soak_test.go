@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// TestSoakLostItem проверяет, что потерянное число видно как расхождение
+// счётчиков, а не как конвейер, который не опустел.
+func TestSoakLostItem(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctl := NewControl(1)
+	ctl.Pause()
+	go ctl.Wait(ctx) // источник стоит на паузе
+
+	// число сгенерировано, но так и не дошло до выхода
+	generatedItems.Add(1)
+	sumGenerated.Add(7)
+	defer func() {
+		generatedItems.Add(-1)
+		sumGenerated.Add(-7)
+	}()
+
+	s := &Soak{MemoryGrowth: 2, Goroutines: 10}
+	v, ok := s.check(ctx, ctl, nil)
+	if !ok {
+		t.Fatal("проверка не дождалась, пока конвейер опустеет")
+	}
+	if v == nil || v.Kind != ViolationChecksum {
+		t.Fatalf("нарушение %+v, ожидалось расхождение счётчиков", v)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	socketFlushSize     = 4096                  // при таком объёме буфер отправляется сразу
+	socketFlushInterval = 50 * time.Millisecond // а иначе не реже, чем раз в этот интервал
+	socketRetryWindow   = 30 * time.Second      // сколько пытаться переподключиться
+	socketMaxBackoff    = 5 * time.Second       // наибольшая пауза между попытками
+)
+
+var socketReconnects = newCounter("socket_reconnects") // сколько раз переподключались к сокету
+
+// SocketSink пишет значения чисел по одному в строке в Unix-сокет,
+// который слушает процесс-получатель. Если получатель перезапустился,
+// SocketSink переподключается с нарастающими паузами и повторяет
+// неотправленные строки, поэтому строки из прерванной отправки могут
+// прийти повторно. Шифрование и сжатие после каждого переподключения
+// начинаются заново, с нового потока.
+type SocketSink struct {
+	path      string
+	aead      cipher.AEAD
+	compress  string
+	conn      net.Conn
+	z         compressor // conn, при необходимости со сжатием и шифрованием
+	pending   []byte     // строки, ещё не отправленные получателю
+	lastFlush time.Time  // когда буфер отправлялся в последний раз
+}
+
+// DialSocket подключается к Unix-сокету path. Если aead не nil,
+// результаты шифруются; сжимаются они по compress.
+func DialSocket(path string, aead cipher.AEAD, compress string) (*SocketSink, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &SocketSink{path: path, aead: aead, compress: compress, conn: conn, lastFlush: time.Now()}
+	s.z = newCompressor(NewSealWriter(conn, aead), compress)
+	return s, nil
+}
+
+// Put добавляет значение числа it в буфер и при необходимости отправляет его.
+func (s *SocketSink) Put(it Item) error {
+	n := len(s.pending)
+	s.pending = strconv.AppendInt(s.pending, it.Value, 10)
+	s.pending = append(s.pending, '\n')
+	sinkBytes.Add(int64(len(s.pending) - n))
+	if len(s.pending) < socketFlushSize && time.Since(s.lastFlush) < socketFlushInterval {
+		return nil
+	}
+	return s.flush()
+}
+
+// Close отправляет оставшиеся строки, завершает сжатый поток
+// и закрывает соединение.
+func (s *SocketSink) Close() error {
+	err := s.flush()
+	if err == nil {
+		err = s.z.Close()
+	}
+	if cerr := s.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// flush отправляет буфер, переподключаясь при ошибках.
+func (s *SocketSink) flush() error {
+	s.lastFlush = time.Now()
+	if len(s.pending) == 0 {
+		return nil
+	}
+	for {
+		_, err := s.z.Write(s.pending)
+		if err == nil {
+			err = s.z.Flush()
+		}
+		if err == nil {
+			s.pending = s.pending[:0]
+			return nil
+		}
+		log.Printf("socket: %v, переподключаемся\n", err)
+		if err := s.reconnect(); err != nil {
+			return err
+		}
+	}
+}
+
+// reconnect заново подключается к сокету, пока не истечёт socketRetryWindow.
+func (s *SocketSink) reconnect() error {
+	s.conn.Close()
+
+	deadline := time.Now().Add(socketRetryWindow)
+	backoff := 50 * time.Millisecond
+	for {
+		conn, err := net.Dial("unix", s.path)
+		if err == nil {
+			socketReconnects.Add(1)
+			s.conn = conn
+			s.z = newCompressor(NewSealWriter(conn, s.aead), s.compress)
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("не удалось переподключиться к %s за %v: %w", s.path, socketRetryWindow, err)
+		}
+		time.Sleep(backoff)
+		backoff = min(2*backoff, socketMaxBackoff)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadSource читает из r числа по одному в строке и отправляет их в ch
+// так же, как Generator: с порядковыми номерами, сроком годности и профилем
+// нагрузки из opts, вызывая fn для каждого отправленного числа. Пустые строки
+// пропускаются. Через пробел после числа может быть записано время его
+// рождения в микросекундах Unix, как его пишет -timed: тогда при скорости
+// opts.Speed промежутки между числами воспроизводятся, поделённые на неё.
+// Канал ch закрывается, когда ввод закончился или отменён контекст ctx;
+// владеть им должен этап stdin.
+func ReadSource(ctx context.Context, r io.Reader, out *ChannelOwner[Item], opts GeneratorOptions, fn func(int64)) error {
+	defer out.Close("stdin")
+	ch := out.C
+	run, _ := RunFrom(ctx)
+	opts.run = run.ID
+
+	pace := newPacer(opts.Schedule)
+	pace.speed = opts.Speed
+	sc := bufio.NewScanner(r)
+	var line, seq int64
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		value, bornText, timed := strings.Cut(text, " ")
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("строка %d: %w", line, err)
+		}
+		var born time.Time
+		if timed {
+			us, err := strconv.ParseInt(strings.TrimSpace(bornText), 10, 64)
+			if err != nil {
+				return fmt.Errorf("строка %d: время рождения: %w", line, err)
+			}
+			born = time.UnixMicro(us)
+		}
+		seq++
+		if !pace.Wait(ctx) || !pace.Replay(ctx, born) || !opts.send(ctx, ch, seq, v) {
+			return nil
+		}
+		fn(v)
+	}
+	return sc.Err()
+}
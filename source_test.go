@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseSpeed проверяет разбор скорости воспроизведения.
+func TestParseSpeed(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want Speed
+		ok   bool
+	}{
+		{"max", 0, true},
+		{"2x", 2, true},
+		{"0.5x", 0.5, true},
+		{"3", 3, true},
+		{"0x", 0, false},
+		{"-1x", 0, false},
+		{"fast", 0, false},
+	} {
+		got, err := ParseSpeed(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("%q: %v, ошибка %v; ожидалось %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+// TestReadSourceSpeed воспроизводит запись с промежутками в 40 мс между
+// числами с разной скоростью и проверяет, сколько длилось воспроизведение.
+func TestReadSourceSpeed(t *testing.T) {
+	if testing.Short() {
+		t.Skip("зависит от времени")
+	}
+	born := time.Now().Add(-time.Hour)
+	var rec strings.Builder
+	for i := range 6 {
+		fmt.Fprintf(&rec, "%d %d\n", i+1, born.Add(time.Duration(i)*40*time.Millisecond).UnixMicro())
+	}
+	// запись длится 200 мс
+	for _, tt := range []struct {
+		speed    Speed
+		min, max time.Duration
+	}{
+		{1, 200 * time.Millisecond, 300 * time.Millisecond},
+		{2, 100 * time.Millisecond, 180 * time.Millisecond},
+		{0, 0, 50 * time.Millisecond},
+	} {
+		out := NewChannelOwner[Item]("in", "stdin", 6)
+		var sum int64
+		start := time.Now()
+		err := ReadSource(context.Background(), strings.NewReader(rec.String()), out,
+			GeneratorOptions{Speed: tt.speed}, func(v int64) { sum += v })
+		took := time.Since(start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum != 21 {
+			t.Errorf("скорость %v: сумма %d, ожидалась 21", tt.speed, sum)
+		}
+		if took < tt.min || took > tt.max {
+			t.Errorf("скорость %v: воспроизведение заняло %v, ожидалось от %v до %v", tt.speed, took, tt.min, tt.max)
+		}
+	}
+}
+
+// TestReadSourceTimedLine проверяет, что строка со временем рождения
+// читается, а испорченное время — ошибка с номером строки.
+func TestReadSourceTimedLine(t *testing.T) {
+	out := NewChannelOwner[Item]("in", "stdin", 2)
+	err := ReadSource(context.Background(), strings.NewReader("5 1700000000000000\n7\n"), out, GeneratorOptions{}, func(int64) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := (<-out.C).Value + (<-out.C).Value; v != 12 {
+		t.Errorf("сумма %d, ожидалась 12", v)
+	}
+	out = NewChannelOwner[Item]("in", "stdin", 2)
+	err = ReadSource(context.Background(), strings.NewReader("5\n6 вчера\n"), out, GeneratorOptions{}, func(int64) {})
+	if err == nil || !strings.Contains(err.Error(), "строка 2") {
+		t.Errorf("ошибка %v, ожидалась ошибка в строке 2", err)
+	}
+}
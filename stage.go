@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+)
+
+// Stage — этап обработки одного числа. Контекст ctx несёт идентификатор
+// числа, сведения о запуске и срок, к которому число устаревает.
+type Stage func(ctx context.Context, it Item) (Item, error)
+
+// passThrough — этап, который передаёт число дальше как есть.
+func passThrough(ctx context.Context, it Item) (Item, error) {
+	return it, nil
+}
+
+// stageStats — счётчики этапа в metrics.
+type stageStats struct {
+	in     expvar.Int // сколько чисел пришло на этап
+	out    expvar.Int // сколько чисел этап обработал
+	errors expvar.Int // сколько раз этап вернул ошибку
+	busy   expvar.Int // сколько микросекунд этап работал
+}
+
+var (
+	stagesMu sync.Mutex
+	stages   = make(map[string]*stageStats)
+)
+
+// statsFor возвращает счётчики этапа name, регистрируя их в metrics
+// под именем stage_<name> при первом обращении. Одноимённые этапы,
+// например одинаковые этапы разных обработчиков, считаются вместе.
+func statsFor(name string) *stageStats {
+	stagesMu.Lock()
+	defer stagesMu.Unlock()
+	if s, ok := stages[name]; ok {
+		return s
+	}
+	s := new(stageStats)
+	m := new(expvar.Map)
+	m.Set("in", &s.in)
+	m.Set("out", &s.out)
+	m.Set("errors", &s.errors)
+	m.Set("busy_us", &s.busy)
+	metrics.Set("stage_"+name, m)
+	stages[name] = s
+	return s
+}
+
+// Instrument оборачивает этап next так, что для него считаются пришедшие
+// и обработанные числа, ошибки и время работы.
+func Instrument(name string, next Stage) Stage {
+	s := statsFor(name)
+	return func(ctx context.Context, it Item) (Item, error) {
+		s.in.Add(1)
+		start := time.Now()
+		res, err := next(ctx, it)
+		s.busy.Add(time.Since(start).Microseconds())
+		if err != nil {
+			s.errors.Add(1)
+			if id, ok := TraceFrom(ctx); ok && traceItems {
+				log.Printf("[%v] этап %s: %v\n", id, name, err)
+			}
+			return res, err
+		}
+		s.out.Add(1)
+		return res, nil
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+)
+
+// TLSFiles — файлы сертификатов для сетевых режимов.
+type TLSFiles struct {
+	Cert string // сертификат этого процесса
+	Key  string // закрытый ключ к сертификату
+	CA   string // сертификаты, которым доверяем при проверке другой стороны
+}
+
+// Enabled сообщает, задано ли что-нибудь для TLS.
+func (f TLSFiles) Enabled() bool {
+	return f.Cert != "" || f.CA != ""
+}
+
+// ServerConfig возвращает настройки TLS для приёма подключений. Если задан
+// CA, клиенты обязаны предъявить подписанный им сертификат. Без сертификата
+// возвращает nil: подключения принимаются без TLS.
+func (f TLSFiles) ServerConfig() (*tls.Config, error) {
+	if f.Cert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(f.Cert, f.Key)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if f.CA != "" {
+		pool, err := loadCertPool(f.CA)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// ClientConfig возвращает настройки TLS для подключения к addr: сервер
+// проверяется по CA, если он задан, а иначе по системным сертификатам;
+// собственный сертификат, если задан, предъявляется серверу. Если для TLS
+// ничего не задано, возвращает nil.
+func (f TLSFiles) ClientConfig(addr string) (*tls.Config, error) {
+	if !f.Enabled() {
+		return nil, nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if f.CA != "" {
+		if conf.RootCAs, err = loadCertPool(f.CA); err != nil {
+			return nil, err
+		}
+	}
+	if f.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(f.Cert, f.Key)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// listenTCP начинает принимать подключения по addr, с TLS, если conf не nil.
+func listenTCP(addr string, conf *tls.Config) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil || conf == nil {
+		return ln, err
+	}
+	return tls.NewListener(ln, conf), nil
+}
+
+// dialTCP подключается к addr, с TLS, если conf не nil.
+func dialTCP(addr string, conf *tls.Config) (net.Conn, error) {
+	if conf == nil {
+		return net.Dial("tcp", addr)
+	}
+	return tls.Dial("tcp", addr, conf)
+}
+
+// loadCertPool читает сертификаты в формате PEM из файла path.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: нет сертификатов в формате PEM", path)
+	}
+	return pool, nil
+}
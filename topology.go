@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Topology — устройство работающего конвейера: источник, способ раздачи
+// чисел, обработчики, каналы между этапами и получатели результатов.
+type Topology struct {
+	Source   string            `json:"source"`  // этап-источник
+	Routing  string            `json:"routing"` // shared, weighted, keyed, fair или coordinator
+	Ordered  bool              `json:"ordered"` // восстанавливается ли общий порядок
+	Workers  []TopologyWorker  `json:"workers"` // местные обработчики; у координатора их нет
+	Channels []TopologyChannel `json:"channels"`
+	Sinks    []string          `json:"sinks"`
+}
+
+// TopologyWorker — местный обработчик.
+type TopologyWorker struct {
+	ID     int      `json:"id"`
+	Name   string   `json:"name"`
+	Delay  Duration `json:"delay"`
+	Jitter Duration `json:"jitter"`
+	Buffer int      `json:"buffer"` // ёмкость выходного канала
+	Active bool     `json:"active"` // берёт ли он сейчас числа
+}
+
+// TopologyChannel — канал между этапами.
+type TopologyChannel struct {
+	Name     string `json:"name"`
+	Producer string `json:"producer"`
+	Consumer string `json:"consumer"`
+	Cap      int    `json:"cap"`
+	Len      int    `json:"len"` // заполненность при последнем замере
+}
+
+// BuildTopology описывает конвейер по его работающим частям.
+func BuildTopology(source, routing string, ordered bool, workers []*Worker, depths []*ChannelDepth, sinks []Sink, ctl *Control) Topology {
+	t := Topology{Source: source, Routing: routing, Ordered: ordered}
+	for _, w := range workers {
+		active, _ := ctl.Allows(w.id)
+		t.Workers = append(t.Workers, TopologyWorker{
+			ID:     w.id,
+			Name:   w.name,
+			Delay:  w.lat.Delay,
+			Jitter: w.lat.Jitter,
+			Buffer: w.buffer,
+			Active: active,
+		})
+	}
+	for _, d := range depths {
+		cur, capacity := d.Depth()
+		t.Channels = append(t.Channels, TopologyChannel{
+			Name:     d.Name,
+			Producer: d.Producer,
+			Consumer: d.Consumer,
+			Cap:      capacity,
+			Len:      cur,
+		})
+	}
+	for _, s := range sinks {
+		t.Sinks = append(t.Sinks, sinkName(s))
+	}
+	return t
+}
+
+// sinkName возвращает название получателя s; у получателя за цепью —
+// название того, кто за ней.
+func sinkName(s Sink) string {
+	if b, ok := s.(*BreakerSink); ok {
+		return sinkName(b.Sink)
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", s), "*main.")
+}
+
+// WriteDOT пишет конвейер в w на языке Graphviz DOT: этапы — узлы,
+// каналы — рёбра с ёмкостью и заполненностью.
+func (t Topology) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph pipeline {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	fmt.Fprintf(&b, "\t%q [shape=ellipse];\n", t.Source)
+	for _, wk := range t.Workers {
+		label := fmt.Sprintf("обработчик %s\n%v", wk.Name, time.Duration(wk.Delay))
+		if wk.Jitter > 0 {
+			label += fmt.Sprintf(" + до %v", time.Duration(wk.Jitter))
+		}
+		style := ""
+		if !wk.Active {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&b, "\t%q [label=%q%s];\n", "обработчик "+wk.Name, label, style)
+	}
+	shared := false
+	for _, c := range t.Channels {
+		fmt.Fprintf(&b, "\t%q -> %q [label=%q];\n", c.Producer, c.Consumer,
+			fmt.Sprintf("%s %d/%d", c.Name, c.Len, c.Cap))
+		shared = shared || c.Consumer == "обработчики"
+	}
+	if shared {
+		// общий канал читают все обработчики сразу
+		for _, wk := range t.Workers {
+			fmt.Fprintf(&b, "\t%q -> %q [style=dotted, arrowhead=none];\n", "обработчики", "обработчик "+wk.Name)
+		}
+	}
+	for _, s := range t.Sinks {
+		fmt.Fprintf(&b, "\t%q -> %q;\n", "получатель", s)
+		fmt.Fprintf(&b, "\t%q [shape=cylinder];\n", s)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// TopologyHandler отдаёт устройство конвейера, которое возвращает current:
+// в JSON, а с параметром format=dot — на языке DOT.
+func TopologyHandler(current func() Topology) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t := current()
+		if r.URL.Query().Get("format") == "dot" {
+			w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+			t.WriteDOT(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(t)
+	}
+}
+
+// WriteTopology записывает устройство конвейера в файл path: на языке DOT,
+// если у файла расширение .dot или .gv, иначе в JSON.
+func WriteTopology(path string, t Topology) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	switch filepath.Ext(path) {
+	case ".dot", ".gv":
+		err = t.WriteDOT(f)
+	default:
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(t)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+)
+
+// TraceID — идентификатор, по которому можно проследить путь числа
+// через все этапы.
+type TraceID uint64
+
+// NewTraceID возвращает новый случайный идентификатор.
+func NewTraceID() TraceID {
+	return TraceID(rand.Uint64())
+}
+
+func (id TraceID) String() string {
+	return fmt.Sprintf("%016x", uint64(id))
+}
+
+type traceKey struct{}
+
+// WithTrace возвращает контекст с идентификатором id.
+func WithTrace(ctx context.Context, id TraceID) context.Context {
+	return context.WithValue(ctx, traceKey{}, id)
+}
+
+// traceContext — контекст с идентификатором числа поверх родительского.
+// Обработчик держит один такой контекст и переписывает в нём
+// идентификатор для каждого числа, чтобы путь числа не выделял память;
+// поэтому этап не должен пользоваться контекстом после возврата.
+type traceContext struct {
+	context.Context
+	id TraceID
+}
+
+func (c *traceContext) Value(key any) any {
+	if key == (traceKey{}) {
+		return c.id
+	}
+	return c.Context.Value(key)
+}
+
+// TraceFrom возвращает идентификатор из контекста ctx, если он там есть.
+func TraceFrom(ctx context.Context) (TraceID, bool) {
+	id, ok := ctx.Value(traceKey{}).(TraceID)
+	return id, ok
+}
+
+// traceItems включает журналирование пути каждого числа.
+var traceItems bool
+
+// tracef пишет в журнал сообщение об этапе обработки числа it,
+// если включено журналирование пути чисел. Аргументы упаковываются в any
+// ещё до вызова и выделяют память, даже когда журнал выключен, поэтому
+// на пути каждого числа вызов с аргументами проверяет traceItems сам.
+func tracef(it Item, format string, args ...any) {
+	if !traceItems {
+		return
+	}
+	log.Printf("[%v] %d: %s\n", it.Trace, it.Value, fmt.Sprintf(format, args...))
+}
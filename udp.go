@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// StatsPacket — сводка, которую StatsEmitter раз в интервал отправляет по UDP.
+// В двоичном формате поля идут подряд в этом порядке, big-endian.
+type StatsPacket struct {
+	Time      int64 `json:"time"`      // время замера, UnixNano
+	Generated int64 `json:"generated"` // сколько чисел сгенерировано
+	Processed int64 `json:"processed"` // сколько чисел получено на выходе
+	Expired   int64 `json:"expired"`   // сколько чисел устарело
+	Stale     int64 `json:"stale"`     // сколько раз обработчики переставали отвечать
+	Alarms    int64 `json:"alarms"`    // сколько раз генератор был заблокирован
+	HeapPeak  int64 `json:"heap_peak"` // наибольшая куча, байт
+	Allocated int64 `json:"allocated"` // сколько байт выделено всего
+	NumGC     int64 `json:"num_gc"`    // сколько было сборок мусора
+	GCPause   int64 `json:"gc_pause"`  // сколько длились все паузы на сборку, нс
+	Run       int64 `json:"run"`       // идентификатор запуска
+	Failed    int64 `json:"failed"`    // сколько чисел обработать не удалось
+}
+
+// StatsEmitter отправляет сводки по UDP, не дожидаясь ответа: если
+// получателя нет, сводки просто теряются.
+type StatsEmitter struct {
+	conn   net.Conn
+	binary bool
+	run    RunID
+}
+
+// DialStats готовит отправку сводок на addr в формате format: json или binary.
+func DialStats(addr, format string) (*StatsEmitter, error) {
+	if format != "json" && format != "binary" {
+		return nil, fmt.Errorf("неизвестный формат сводок %q", format)
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsEmitter{conn: conn, binary: format == "binary"}, nil
+}
+
+// Run отправляет сводку каждые interval, пока не отменён контекст ctx,
+// и ещё одну, последнюю, после отмены. В сводке указан запуск из ctx.
+func (e *StatsEmitter) Run(ctx context.Context, interval time.Duration) {
+	defer e.conn.Close()
+	run, _ := RunFrom(ctx)
+	e.run = run.ID
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			e.send()
+			return
+		case <-tick.C:
+			e.send()
+		}
+	}
+}
+
+func (e *StatsEmitter) send() {
+	mem := ReadMemorySummary()
+	p := StatsPacket{
+		Time:      time.Now().UnixNano(),
+		Generated: generatedItems.Value(),
+		Processed: processedItems.Value(),
+		Expired:   expiredItems.Value(),
+		Stale:     staleWorkers.Value(),
+		Alarms:    backpressureAlarms.Value(),
+		HeapPeak:  mem.HeapPeak,
+		Allocated: int64(mem.TotalAlloc),
+		NumGC:     int64(mem.NumGC),
+		GCPause:   int64(mem.PauseTotal),
+		Run:       int64(e.run),
+		Failed:    failedItems.Value(),
+	}
+
+	var data []byte
+	if e.binary {
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.BigEndian, p)
+		data = buf.Bytes()
+	} else {
+		data, _ = json.Marshal(p)
+	}
+	// ошибки отправки не важны: сводки не должны мешать конвейеру
+	e.conn.Write(data)
+}
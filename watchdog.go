@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Watchdog ограничивает время, за которое конвейер должен доработать после
+// того, как источник перестал выдавать числа. Если конвейер не успел, Watchdog
+// пишет снимок состояния со стеками всех горутин, называет вероятное место
+// зависания и завершает процесс, чтобы прогон в скрипте не висел вечно.
+type Watchdog struct {
+	Timeout time.Duration
+
+	generatorDone atomic.Bool // источник завершился и закрыл входной канал
+	fanInClosed   atomic.Bool // сборщик закрыл канал результатов
+}
+
+// GeneratorDone отмечает завершение источника. Вызов для nil ничего не делает.
+func (w *Watchdog) GeneratorDone() {
+	if w != nil {
+		w.generatorDone.Store(true)
+	}
+}
+
+// FanInClosed отмечает закрытие канала результатов. Вызов для nil ничего
+// не делает.
+func (w *Watchdog) FanInClosed() {
+	if w != nil {
+		w.fanInClosed.Store(true)
+	}
+}
+
+// Run ждёт, пока отменят контекст ctx или завершится источник, и после
+// этого даёт конвейеру Timeout на то, чтобы закрылся done. Каналы depths
+// идут в порядке конвейера: входной, выходы обработчиков, результаты.
+func (w *Watchdog) Run(ctx context.Context, done <-chan struct{}, mon *Monitor, depths []*ChannelDepth) {
+	poll := time.NewTicker(10 * time.Millisecond)
+	defer poll.Stop()
+	for !w.generatorDone.Load() {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			// дальше ждём уже по таймеру
+		case <-poll.C:
+			continue
+		}
+		break
+	}
+
+	timer := time.NewTimer(w.Timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return
+	case <-timer.C:
+	}
+
+	DumpStats(os.Stderr, mon, depths)
+	log.Fatalf("Ошибка: конвейер не доработал за %v: %s\n", w.Timeout, w.classify(depths))
+}
+
+// classify называет вероятное место зависания по состоянию этапов
+// и заполненности каналов.
+func (w *Watchdog) classify(depths []*ChannelDepth) string {
+	in := depths[0]
+	result := depths[len(depths)-1]
+	var upstream int
+	for _, d := range depths[:len(depths)-1] {
+		upstream += d.len()
+	}
+
+	switch {
+	case !w.generatorDone.Load() && in.len() >= in.cap:
+		return "генератор заблокирован: входной канал полон, обработчики его не читают"
+	case !w.generatorDone.Load():
+		return "генератор не завершился, хотя входной канал не полон"
+	case result.len() > 0:
+		return "получатель не читает канал результатов"
+	case upstream > 0:
+		return "получатель голодает: числа застряли у обработчиков или сборщика"
+	case !w.fanInClosed.Load():
+		return "сборщик не закрыл канал результатов, хотя все каналы пусты"
+	default:
+		return "все этапы завершились, но результаты не дочитаны"
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	webhookFlushInterval = time.Second     // неполный пакет отправляется не реже этого
+	webhookAttempts      = 3               // сколько раз пытаться отправить пакет
+	webhookMaxBackoff    = 5 * time.Second // наибольшая пауза между попытками
+	webhookQueue         = 16              // сколько пакетов может ждать отправки
+)
+
+var (
+	webhookSent    = newCounter("webhook_sent")         // сколько пакетов отправлено
+	webhookDropped = newCounter("webhook_failed_items") // сколько чисел отправить не удалось
+)
+
+// WebhookSink отправляет результаты POST-запросами пакетами в виде
+// JSON-массива объектов с теми же полями, что у ParquetSink, и номером
+// трассировки. Пакет отправляется, когда в нём набралось batch чисел или
+// он копится дольше webhookFlushInterval. Отправка идёт в фоне; сетевые
+// ошибки, ответы 5xx и 429 повторяются с нарастающими паузами.
+// Неотправленные числа учитываются в Failed; если задана цепь, пакеты
+// отправляются через неё, и неотправленный пакет уходит к ней.
+type WebhookSink struct {
+	url       string
+	batch     int
+	http      *http.Client
+	pending   []byte    // JSON-объекты текущего пакета через запятую
+	items     []Item    // числа текущего пакета, только если задана цепь
+	n         int       // сколько в нём чисел
+	oldest    time.Time // когда родилось самое старое число пакета
+	lastFlush time.Time
+	tune      *BatchTuner
+	guard     *batchGuard
+
+	queue  chan webhookBatch
+	done   chan struct{}
+	failed atomic.Int64
+}
+
+// webhookBatch — пакет, который ждёт отправки.
+type webhookBatch struct {
+	body   []byte
+	items  []Item // только если задана цепь
+	n      int
+	oldest time.Time
+}
+
+// NewWebhookSink создаёт получателя, который отправляет пакеты по batch
+// чисел на url, ожидая ответа не дольше timeout.
+func NewWebhookSink(url string, batch int, timeout time.Duration) *WebhookSink {
+	s := &WebhookSink{
+		url:       url,
+		batch:     batch,
+		http:      &http.Client{Timeout: timeout},
+		lastFlush: time.Now(),
+		queue:     make(chan webhookBatch, webhookQueue),
+		done:      make(chan struct{}),
+	}
+	go s.send()
+	return s
+}
+
+// Tune поручает выбор размера пакетов t вместо постоянного batch.
+// Неполный пакет тогда отправляется не позже чем через половину t.Target.
+// Вызывать Tune можно только до первого Put.
+func (s *WebhookSink) Tune(t *BatchTuner) {
+	s.tune = t
+}
+
+// Guard отправляет пакеты через цепь b; числа неотправленных пакетов
+// получает fail, и они учитываются в Failed. fail вызывается из
+// отправляющей горутины.
+func (s *WebhookSink) Guard(b *Breaker, fail func([]Item)) {
+	s.guard = &batchGuard{b: b, fail: func(items []Item) {
+		s.drop(len(items))
+		fail(items)
+	}}
+}
+
+// Put добавляет число it в пакет и при необходимости ставит пакет
+// в очередь на отправку.
+func (s *WebhookSink) Put(it Item) error {
+	now := time.Now()
+	start := len(s.pending)
+	if s.n > 0 {
+		s.pending = append(s.pending, ',')
+	}
+	if s.n == 0 || it.Born.Before(s.oldest) {
+		s.oldest = it.Born
+	}
+	s.pending = append(s.pending, `{"trace":"`...)
+	s.pending = append(s.pending, it.Trace.String()...)
+	s.pending = append(s.pending, '"')
+	for _, c := range itemColumns {
+		s.pending = append(s.pending, `,"`...)
+		s.pending = append(s.pending, c.name...)
+		s.pending = append(s.pending, `":`...)
+		v := c.value(it, now)
+		if c.kind == columnTimestamp {
+			s.pending = append(s.pending, '"')
+			s.pending = time.UnixMicro(v).UTC().AppendFormat(s.pending, time.RFC3339Nano)
+			s.pending = append(s.pending, '"')
+		} else {
+			s.pending = strconv.AppendInt(s.pending, v, 10)
+		}
+	}
+	s.pending = append(s.pending, '}')
+	sinkBytes.Add(int64(len(s.pending) - start))
+	if s.guard != nil {
+		s.items = append(s.items, it)
+	}
+	s.n++
+	batch, interval := s.batch, webhookFlushInterval
+	if s.tune != nil {
+		batch, interval = s.tune.Size(), min(interval, s.tune.Target/2)
+	}
+	if s.n < batch && now.Sub(s.lastFlush) < interval {
+		return nil
+	}
+	s.flush()
+	return nil
+}
+
+// flush ставит накопленный пакет в очередь на отправку.
+func (s *WebhookSink) flush() {
+	s.lastFlush = time.Now()
+	if s.n == 0 {
+		return
+	}
+	body := make([]byte, 0, len(s.pending)+2)
+	body = append(append(append(body, '['), s.pending...), ']')
+	// пакет уходит в отправляющую горутину, поэтому числа пакета
+	// отдаются вместе с ним, а не переиспользуются
+	s.queue <- webhookBatch{body: body, items: s.items, n: s.n, oldest: s.oldest}
+	s.pending, s.items, s.n = s.pending[:0], nil, 0
+}
+
+// send отправляет пакеты из очереди, пока она не закрыта.
+func (s *WebhookSink) send() {
+	defer close(s.done)
+	for b := range s.queue {
+		if err := s.guard.write(b.items, func() error { return s.post(b) }); err != nil {
+			log.Printf("webhook: не отправлено %d чисел: %v\n", b.n, err)
+			s.drop(b.n)
+		}
+	}
+}
+
+// drop учитывает n неотправленных чисел.
+func (s *WebhookSink) drop(n int) {
+	s.failed.Add(int64(n))
+	webhookDropped.Add(int64(n))
+}
+
+// post отправляет пакет b, повторяя попытку после ошибок, которые
+// могут пройти сами.
+func (s *WebhookSink) post(b webhookBatch) error {
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		resp, err := s.http.Post(s.url, "application/json", bytes.NewReader(b.body))
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			switch {
+			case resp.StatusCode < 300:
+				webhookSent.Add(1)
+				if s.tune != nil {
+					s.tune.Observe(b.n, b.oldest)
+				}
+				return nil
+			case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+				err = fmt.Errorf("webhook: %s", resp.Status)
+			default:
+				return fmt.Errorf("webhook: %s", resp.Status)
+			}
+		}
+		if attempt == webhookAttempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff = min(2*backoff, webhookMaxBackoff)
+	}
+}
+
+// Failed возвращает, сколько чисел отправить не удалось. Пакеты
+// отправляются в фоне, поэтому до Close счёт может быть неполным.
+func (s *WebhookSink) Failed() int64 {
+	return s.failed.Load()
+}
+
+// Close отправляет оставшиеся числа и ждёт окончания отправки.
+func (s *WebhookSink) Close() error {
+	s.flush()
+	close(s.queue)
+	<-s.done
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWebhook отвечает по очереди кодами status, а когда они
+// кончаются — последним из них, и считает запросы и полученные числа.
+type fakeWebhook struct {
+	mu       sync.Mutex
+	status   []int
+	requests int
+	items    int
+}
+
+func (f *fakeWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	status := f.status[min(f.requests, len(f.status))-1]
+	if status == http.StatusOK {
+		var batch []map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.items += len(batch)
+	}
+	w.WriteHeader(status)
+}
+
+// TestWebhookSink проверяет повтор отправки после ответов 5xx и учёт
+// чисел, которые отправить так и не удалось, с цепью и без неё.
+func TestWebhookSink(t *testing.T) {
+	cases := []struct {
+		name     string
+		status   []int
+		guard    bool
+		requests int
+		items    int
+		failed   int64
+	}{
+		{"повтор после 5xx", []int{http.StatusServiceUnavailable, http.StatusOK}, false, 3, 4, 0},
+		{"постоянный 5xx", []int{http.StatusInternalServerError}, false, 2 * webhookAttempts, 0, 4},
+		{"отказ без повтора", []int{http.StatusBadRequest}, false, 2, 0, 4},
+		{"повтор после 5xx через цепь", []int{http.StatusServiceUnavailable, http.StatusOK}, true, 3, 4, 0},
+		// после первого пакета цепь размыкается, и второй до сервера не доходит
+		{"постоянный 5xx через цепь", []int{http.StatusInternalServerError}, true, webhookAttempts, 0, 4},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := &fakeWebhook{status: tc.status}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+			dropped := webhookDropped.Value()
+
+			s := NewWebhookSink(srv.URL, 2, time.Second)
+			var failed []Item
+			if tc.guard {
+				s.Guard(NewBreaker(t.Name(), 1, 1, time.Minute, nil), func(items []Item) {
+					failed = append(failed, items...)
+				})
+			}
+			for i := range 4 {
+				if err := s.Put(Item{Seq: int64(i + 1), Value: int64(i + 1), Born: time.Now()}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			if fake.requests != tc.requests {
+				t.Errorf("%d запросов, ожидалось %d", fake.requests, tc.requests)
+			}
+			if fake.items != tc.items {
+				t.Errorf("получено %d чисел, ожидалось %d", fake.items, tc.items)
+			}
+			if n := s.Failed(); n != tc.failed {
+				t.Errorf("не отправлено %d чисел, ожидалось %d", n, tc.failed)
+			}
+			if n := webhookDropped.Value() - dropped; n != tc.failed {
+				t.Errorf("webhook_failed_items вырос на %d, ожидалось %d", n, tc.failed)
+			}
+			if tc.guard && int64(len(failed)) != tc.failed {
+				t.Errorf("цепь получила %d чисел, ожидалось %d", len(failed), tc.failed)
+			}
+		})
+	}
+}
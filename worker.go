@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WorkerOption настраивает обработчик, создаваемый NewWorker.
+type WorkerOption func(*Worker)
+
+// WithDelay задаёт профиль паузы после каждого числа; по умолчанию
+// DefaultLatency.
+func WithDelay(lat LatencyProfile) WorkerOption {
+	return func(w *Worker) { w.lat = lat }
+}
+
+// WithName задаёт имя обработчика для журналов и трассировки;
+// по умолчанию это его номер.
+func WithName(name string) WorkerOption {
+	return func(w *Worker) { w.name = name }
+}
+
+// WithBuffer задаёт ёмкость выходного канала; по умолчанию он без буфера.
+func WithBuffer(n int) WorkerOption {
+	return func(w *Worker) { w.buffer = n }
+}
+
+// WithProcess задаёт обработку числа; по умолчанию число передаётся
+// дальше как есть.
+func WithProcess(fn Stage) WorkerOption {
+	return func(w *Worker) { w.process = fn }
+}
+
+// WithRetry разрешает повторить неудачную обработку до attempts раз
+// с паузой backoff, удваивающейся после каждой попытки.
+func WithRetry(attempts int, backoff time.Duration) WorkerOption {
+	return func(w *Worker) {
+		w.attempts = attempts
+		w.backoff = backoff
+	}
+}
+
+// WithControl подчиняет обработчик ручному управлению: пока ctl не
+// разрешает ему брать числа, он только присылает сигналы жизни.
+func WithControl(ctl *Control) WorkerOption {
+	return func(w *Worker) { w.ctl = ctl }
+}
+
+// workerRestarts — сколько раз супервизор перезапускал обработчиков.
+var workerRestarts = newCounter("worker_restarts")
+
+// Worker читает числа из общего входного канала и пишет результаты
+// в свой выходной канал, помечая их своим номером. Устаревшие числа
+// и числа, обработать которые не удалось, уходят туда же с ошибкой.
+// После каждого числа обработчик выдерживает паузу по профилю задержки.
+// Каждые HeartbeatInterval обработчик отправляет сигнал жизни в монитор:
+// пока ждёт очередное число, выдерживает паузу или ждёт повтора. Если
+// обработчик завис на обработке числа или на записи результата, сигналы
+// перестают поступать, и монитор это замечает; зависшего на обработке
+// супервизор может перезапустить методом Restart.
+type Worker struct {
+	id       int
+	name     string
+	in       <-chan Item
+	out      *ChannelOwner[Result[Item]]
+	mon      *Monitor
+	lat      LatencyProfile
+	buffer   int
+	process  Stage
+	attempts int
+	backoff  time.Duration
+	ctl      *Control
+
+	mu    sync.Mutex
+	ctx   context.Context // контекст из Run
+	cur   *workerLoop     // цикл, который берёт числа
+	loops int             // сколько циклов ещё работает, включая отставленные
+	done  chan struct{}   // закрывается, когда завершился последний цикл
+}
+
+// workerLoop — один цикл обработчика. После перезапуска отставленный
+// цикл дорабатывает своё число и завершается, а числа берёт новый.
+type workerLoop struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	hb      Heartbeat
+	tick    *time.Ticker
+	timer   *time.Timer // паузы и ожидание повтора; создаётся при первой паузе
+	retired atomic.Bool // цикл отставлен перезапуском
+	busy    atomic.Bool // цикл обрабатывает число
+	// trace и deadline — контексты обработки очередного числа без срока
+	// и со сроком; см. itemContext. deadline создаётся при первом числе
+	// со сроком
+	trace    traceContext
+	deadline *deadlineContext
+}
+
+// NewWorker создаёт обработчик номер id, читающий числа из in и
+// отправляющий сигналы жизни в mon. Запускается он методом Run.
+func NewWorker(id int, in <-chan Item, mon *Monitor, opts ...WorkerOption) *Worker {
+	w := &Worker{
+		id:      id,
+		name:    strconv.Itoa(id),
+		in:      in,
+		mon:     mon,
+		lat:     DefaultLatency,
+		process: passThrough,
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.out = NewChannelOwner[Result[Item]]("out"+strconv.Itoa(id), w.stage(), w.buffer)
+	return w
+}
+
+// Out возвращает выходной канал обработчика. Он закрывается, когда
+// Run завершается.
+func (w *Worker) Out() <-chan Result[Item] {
+	return w.out.C
+}
+
+// stage возвращает название обработчика как этапа конвейера.
+func (w *Worker) stage() string {
+	return "обработчик " + w.name
+}
+
+// Run обрабатывает числа, пока не закроется входной канал и не
+// завершатся все циклы обработчика, в том числе отставленные
+// перезапуском. Контексты обработки чисел происходят от ctx.
+func (w *Worker) Run(ctx context.Context) {
+	w.mu.Lock()
+	w.ctx = ctx
+	w.start()
+	w.mu.Unlock()
+	<-w.done
+}
+
+// Restart перезапускает обработчик, зависший на обработке числа: отменяет
+// контекст этой обработки и запускает новый цикл, который берёт следующие
+// числа. Зависшее число уйдёт к неудачным, как только этап вернёт
+// управление. Обработчик, который ждёт отправки результата, не
+// перезапускается: новый цикл упёрся бы в того же получателя. Возвращает,
+// перезапущен ли обработчик.
+func (w *Worker) Restart() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	l := w.cur
+	if l == nil || w.loops == 0 || !l.busy.Load() {
+		return false
+	}
+	l.retired.Store(true)
+	l.cancel()
+	w.start()
+	workerRestarts.Add(1)
+	return true
+}
+
+// start запускает новый цикл. Вызывается под w.mu.
+func (w *Worker) start() {
+	l := &workerLoop{hb: Heartbeat{Worker: w.id}, tick: time.NewTicker(HeartbeatInterval)}
+	l.ctx, l.cancel = context.WithCancel(w.ctx)
+	l.trace.Context = l.ctx
+	w.cur = l
+	w.loops++
+	go w.loop(l)
+}
+
+// loop берёт и обрабатывает числа, пока не закроется входной канал или
+// цикл не отставят.
+func (w *Worker) loop(l *workerLoop) {
+	defer func() {
+		l.tick.Stop()
+		l.cancel()
+		w.mon.Done(l.hb)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.loops--; w.loops == 0 {
+			w.out.Close(w.stage())
+			close(w.done)
+		}
+	}()
+
+	w.mon.Beat(l.hb)
+	for !l.retired.Load() {
+		in := w.in
+		allowed, changed := w.ctl.Allows(w.id)
+		if !allowed {
+			in = nil
+		}
+		select {
+		case v, ok := <-in:
+			if !ok {
+				return
+			}
+			v.Worker = w.id
+			if v.Expired(time.Now()) {
+				if traceItems {
+					tracef(v, "устарело в обработчике %s", w.name)
+				}
+				w.out.C <- Result[Item]{Value: v, Err: ErrExpired, Worker: w.id}
+				continue
+			}
+			ictx, cancel := l.itemContext(v)
+			l.busy.Store(true)
+			res, err := w.handle(ictx, l, v)
+			l.busy.Store(false)
+			cancel()
+			if err != nil {
+				if traceItems {
+					tracef(v, "не обработано в обработчике %s: %v", w.name, err)
+				}
+				w.out.C <- Result[Item]{Value: v, Err: err, Worker: w.id}
+				continue
+			}
+			start := time.Now()
+			w.out.C <- Result[Item]{Value: res, Worker: w.id}
+			sent := time.Now()
+			if traceItems {
+				tracef(v, "обработано в обработчике %s", w.name)
+			}
+			l.hb.Processed++
+			l.hb.LastItem = sent
+			l.hb.Blocked += sent.Sub(start)
+			w.wait(l, w.lat.Next(), nil)
+			l.hb.Busy += time.Since(sent)
+		case <-changed:
+		case <-l.tick.C:
+			w.mon.Beat(l.hb)
+		}
+	}
+}
+
+// wait выдерживает паузу d, продолжая присылать сигналы жизни. Возвращает
+// false, если раньше закрылся stop.
+func (w *Worker) wait(l *workerLoop, d time.Duration, stop <-chan struct{}) bool {
+	if d <= 0 {
+		return true
+	}
+	if l.timer == nil {
+		l.timer = time.NewTimer(d)
+	} else {
+		l.timer.Reset(d)
+	}
+	for {
+		select {
+		case <-l.timer.C:
+			return true
+		case <-l.tick.C:
+			w.mon.Beat(l.hb)
+		case <-stop:
+			l.timer.Stop()
+			return false
+		}
+	}
+}
+
+// noCancel — отмена контекста, которому отменять нечего.
+func noCancel() {}
+
+// itemContext возвращает контекст обработки числа v — один из
+// переиспользуемых контекстов цикла, чтобы путь числа не выделял память
+// ни с -ttl, ни без него.
+func (l *workerLoop) itemContext(v Item) (context.Context, context.CancelFunc) {
+	if v.Deadline.IsZero() {
+		l.trace.id = v.Trace
+		return &l.trace, noCancel
+	}
+	if l.deadline == nil {
+		l.deadline = newDeadlineContext(l.ctx)
+	}
+	l.deadline.reset(v)
+	return l.deadline, l.deadline.stop
+}
+
+// deadlineContext — контекст обработки числа со сроком. Как и
+// traceContext, цикл держит один такой контекст и переставляет в нём
+// срок для каждого числа: таймер и канал Done переиспользуются, а новый
+// канал создаётся, только когда срок прошлого числа истёк.
+type deadlineContext struct {
+	traceContext
+	timer *time.Timer // закрывает done, когда срок истёк
+	stop  func()      // останавливает таймер; это отмена контекста числа
+
+	mu       sync.Mutex
+	deadline time.Time
+	done     chan struct{}
+	err      error // почему закрыт done
+}
+
+func newDeadlineContext(parent context.Context) *deadlineContext {
+	c := &deadlineContext{traceContext: traceContext{Context: parent}, done: make(chan struct{})}
+	c.timer = time.AfterFunc(time.Hour, c.expire)
+	c.timer.Stop()
+	c.stop = func() { c.timer.Stop() }
+	context.AfterFunc(parent, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.close(parent.Err())
+	})
+	return c
+}
+
+// reset переставляет контекст на число v.
+func (c *deadlineContext) reset(v Item) {
+	c.id = v.Trace
+	c.mu.Lock()
+	if c.err != nil && c.Context.Err() == nil {
+		c.done, c.err = make(chan struct{}), nil
+	}
+	c.deadline = v.Deadline
+	c.mu.Unlock()
+	c.timer.Reset(time.Until(v.Deadline))
+}
+
+// expire закрывает done, если срок числа истёк. Таймер, сработавший уже
+// после остановки, застаёт срок следующего числа и ничего не делает.
+func (c *deadlineContext) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !time.Now().Before(c.deadline) {
+		c.close(context.DeadlineExceeded)
+	}
+}
+
+// close закрывает done с ошибкой err. Вызывается под c.mu.
+func (c *deadlineContext) close(err error) {
+	if c.err == nil {
+		c.err = err
+		close(c.done)
+	}
+}
+
+func (c *deadlineContext) Deadline() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deadline, true
+}
+
+func (c *deadlineContext) Done() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done
+}
+
+func (c *deadlineContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// handle обрабатывает число, повторяя неудачные попытки, пока не
+// кончится срок из ctx. Число, срок которого кончился во время повторов,
+// устарело.
+func (w *Worker) handle(ctx context.Context, l *workerLoop, v Item) (Item, error) {
+	backoff := w.backoff
+	for attempt := 0; ; attempt++ {
+		res, err := w.process(ctx, v)
+		// разомкнутая цепь не пропустит и повтор
+		if err == nil || attempt >= w.attempts || errors.Is(err, ErrCircuitOpen) {
+			return res, err
+		}
+		if traceItems {
+			tracef(v, "попытка %d в обработчике %s: %v", attempt+1, w.name, err)
+		}
+		if !w.wait(l, backoff, ctx.Done()) {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return v, ErrExpired
+			}
+			return v, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestWorkerItemContext проверяет, что этап получает контекст числа:
+// с его идентификатором, сведениями о запуске и сроком числа.
+func TestWorkerItemContext(t *testing.T) {
+	run := RunInfo{ID: 42}
+	in := make(chan Item)
+	w := NewWorker(0, in, NewMonitor(time.Second),
+		WithDelay(LatencyProfile{}),
+		WithProcess(func(ctx context.Context, it Item) (Item, error) {
+			if id, ok := TraceFrom(ctx); !ok || id != it.Trace {
+				t.Errorf("число %d: в контексте идентификатор %v, ожидался %v", it.Value, id, it.Trace)
+			}
+			if r, ok := RunFrom(ctx); !ok || r.ID != run.ID {
+				t.Errorf("число %d: в контексте запуск %v, ожидался %v", it.Value, r.ID, run.ID)
+			}
+			if deadline, ok := ctx.Deadline(); ok != !it.Deadline.IsZero() || !deadline.Equal(it.Deadline) {
+				t.Errorf("число %d: в контексте срок %v, ожидался %v", it.Value, deadline, it.Deadline)
+			}
+			return it, nil
+		}))
+	go w.Run(WithRun(context.Background(), run))
+
+	items := []Item{
+		{Seq: 1, Value: 1, Trace: NewTraceID()},
+		{Seq: 2, Value: 2, Trace: NewTraceID(), Deadline: time.Now().Add(time.Minute)},
+		{Seq: 3, Value: 3, Trace: NewTraceID()},
+	}
+	for _, it := range items {
+		in <- it
+		if r := <-w.Out(); r.Err != nil {
+			t.Fatalf("число %d: %v", it.Value, r.Err)
+		}
+	}
+	close(in)
+	for range w.Out() {
+	}
+}
+
+// TestWorkerDeadlineReused проверяет, что контекст числа со сроком,
+// который цикл переиспользует, истекает по сроку своего числа, а следующее
+// число получает его открытым.
+func TestWorkerDeadlineReused(t *testing.T) {
+	in := make(chan Item)
+	w := NewWorker(0, in, NewMonitor(time.Second),
+		WithDelay(LatencyProfile{}),
+		WithProcess(func(ctx context.Context, it Item) (Item, error) {
+			if it.Value == 1 {
+				<-ctx.Done()
+			}
+			return it, ctx.Err()
+		}))
+	go w.Run(context.Background())
+
+	in <- Item{Seq: 1, Value: 1, Deadline: time.Now().Add(20 * time.Millisecond)}
+	if r := <-w.Out(); !errors.Is(r.Err, context.DeadlineExceeded) {
+		t.Errorf("число 1: ошибка %v, ожидалось истечение срока", r.Err)
+	}
+	for i := range int64(3) {
+		in <- Item{Seq: 2 + i, Value: 2 + i, Deadline: time.Now().Add(time.Minute)}
+		if r := <-w.Out(); r.Err != nil {
+			t.Errorf("число %d: %v, контекст остался закрытым", 2+i, r.Err)
+		}
+	}
+	close(in)
+	for range w.Out() {
+	}
+}
+
+// TestWorkerRetryExpires проверяет, что повторы прекращаются, когда
+// кончается срок числа, и число считается устаревшим.
+func TestWorkerRetryExpires(t *testing.T) {
+	in := make(chan Item)
+	w := NewWorker(0, in, NewMonitor(time.Second),
+		WithDelay(LatencyProfile{}),
+		WithRetry(100, 10*time.Millisecond),
+		WithProcess(func(ctx context.Context, it Item) (Item, error) { return it, errFlaky }))
+	go w.Run(context.Background())
+
+	in <- Item{Seq: 1, Value: 1, Deadline: time.Now().Add(50 * time.Millisecond)}
+	if r := <-w.Out(); !errors.Is(r.Err, ErrExpired) {
+		t.Errorf("ошибка %v, ожидалась ErrExpired", r.Err)
+	}
+	close(in)
+	for range w.Out() {
+	}
+}
+
+// TestWorkerBeatsDuringDelay проверяет, что обработчик с паузой дольше
+// времени ожидания монитора не отмечается как зависший.
+func TestWorkerBeatsDuringDelay(t *testing.T) {
+	mon := NewMonitor(4 * HeartbeatInterval)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go mon.Run(ctx)
+
+	in := make(chan Item)
+	w := NewWorker(0, in, mon, WithDelay(LatencyProfile{Delay: Duration(8 * HeartbeatInterval)}))
+	go w.Run(context.Background())
+	for i := range 2 {
+		in <- Item{Seq: int64(i + 1), Value: 1}
+		if r := <-w.Out(); r.Err != nil {
+			t.Fatal(r.Err)
+		}
+	}
+	close(in)
+	for range w.Out() {
+	}
+	if n := mon.Missed(); n != 0 {
+		t.Errorf("обработчик %d раз отмечен как зависший во время паузы", n)
+	}
+}
+
+// TestSupervisorRestart проверяет, что обработчик, зависший на обработке
+// числа, перезапускается по сигналу монитора: зависшее число уходит
+// к неудачным, а остальные обрабатываются новым циклом.
+func TestSupervisorRestart(t *testing.T) {
+	mon := NewMonitor(4 * HeartbeatInterval)
+	supervisor := NewSupervisor()
+	mon.OnStale = func(hb Heartbeat) { supervisor.Restart(hb) }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go mon.Run(ctx)
+
+	in := make(chan Item)
+	w := NewWorker(0, in, mon,
+		WithDelay(LatencyProfile{}),
+		WithBuffer(1),
+		WithProcess(func(ctx context.Context, it Item) (Item, error) {
+			if it.Value == 1 {
+				<-ctx.Done()
+				return it, ctx.Err()
+			}
+			return it, nil
+		}))
+	supervisor.Watch(w)
+	go w.Run(context.Background())
+
+	before := workerRestarts.Value()
+	go func() {
+		for i := range 5 {
+			in <- Item{Seq: int64(i + 1), Value: int64(i + 1)}
+		}
+		close(in)
+	}()
+	var processed, failed int
+	for r := range w.Out() {
+		if r.Err != nil {
+			if r.Value.Value != 1 || !errors.Is(r.Err, context.Canceled) {
+				t.Errorf("число %d: ошибка %v", r.Value.Value, r.Err)
+			}
+			failed++
+		} else {
+			processed++
+		}
+	}
+	if processed != 4 || failed != 1 {
+		t.Errorf("обработано %d, неудачных %d; ожидалось 4 и 1", processed, failed)
+	}
+	if got := workerRestarts.Value() - before; got != 1 {
+		t.Errorf("перезапусков %d, ожидался 1", got)
+	}
+	if got := mon.Processed(0); got != 4 {
+		t.Errorf("монитор насчитал %d обработанных чисел, ожидалось 4", got)
+	}
+}